@@ -0,0 +1,409 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/customer"
+	"github.com/stripe/stripe-go/v73/subscription"
+	"github.com/stripe/stripe-go/v73/taxid"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/hex"
+	"os/exec"
+	"strings"
+	"bufio"
+	"bytes"
+	"time"
+	"fmt"
+	"io"
+	"os"
+)
+
+// UserBackup is a customer as written by UserExport, one per line.
+type UserBackup struct {
+	Customer      *stripe.Customer       `json:"customer"`
+	TaxIDs        []*stripe.TaxID        `json:"tax_ids,omitempty"`
+	Subscriptions []*stripe.Subscription `json:"subscriptions,omitempty"`
+}
+
+// UserExportOptions configure UserExport.
+type UserExportOptions struct {
+	Sanitize bool // Replace personal data, see UserBackupSanitize.
+	Encrypt  bool // Encrypt with BackupPassword.
+}
+
+// UserRestoreOptions configure UserRestore.
+type UserRestoreOptions struct {
+	Subscriptions bool // Recreate active and trialing subscriptions.
+	Dry           bool // Only report what would be done.
+}
+
+// UserExport writes all customers with their tax IDs and subscriptions
+// in JSON lines, returns the number of customers written. Canceled
+// subscriptions aren't listed by Stripe and aren't restored.
+func UserExport(w io.Writer, o UserExportOptions) (n int, err error) {
+	var enc *backupCipher
+	if o.Encrypt {
+		if enc, err = backupEncrypt(w); err != nil {
+			return
+		}
+		w = enc
+		defer func() {
+			if cerr := enc.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	p := &stripe.CustomerListParams{}
+	p.Filters.AddFilter("limit", "", "100")
+	p.AddExpand("data.tax_ids")
+	p.AddExpand("data.subscriptions")
+	i := customer.List(p)
+	for i.Next() {
+		var line []byte
+		b := &UserBackup{ Customer: i.Customer() }
+		if b.Customer.TaxIDs != nil {
+			b.TaxIDs = b.Customer.TaxIDs.Data
+			b.Customer.TaxIDs = nil
+		}
+		if s := b.Customer.Subscriptions; s != nil && !s.HasMore {
+			b.Subscriptions = s.Data
+		} else if b.Subscriptions, err = UserSubs(b.Customer.ID); err != nil {
+			return
+		}
+		b.Customer.Subscriptions = nil
+		if o.Sanitize {
+			UserBackupSanitize(b)
+		}
+		if line, err = json.Marshal(b); err != nil {
+			return
+		}
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			return
+		}
+		n++
+	}
+	err = i.Err()
+	return
+}
+
+// UserBackupSanitize replaces the email with a pseudonym derived from
+// it and removes names, phones, addresses, tax IDs, password hashes and
+// team links, so that live data can be used to seed test mode. Other
+// metadata is kept as is, it must not hold personal data.
+func UserBackupSanitize(b *UserBackup) {
+	c := b.Customer
+	if len(c.Email)>0 {
+		sum := sha256.Sum256([]byte(strings.ToLower(c.Email)))
+		c.Email = "user-" + hex.EncodeToString(sum[:6]) + "@example.com"
+	}
+	c.Name, c.Phone, c.Description = "", "", ""
+	c.Address, c.Shipping = nil, nil
+	delete(c.Metadata, "hash1")
+	delete(c.Metadata, "ecode")
+	delete(c.Metadata, TeamKey)
+	delete(c.Metadata, TeamInviteKey)
+	delete(c.Metadata, TeamEcodeKey)
+	b.TaxIDs = nil
+}
+
+// UserRestore creates the customers read from a UserExport file in the
+// current account, encrypted files are detected. Customers whose email
+// already exists are skipped. Team links are set to the new customer
+// IDs once all are created, links to owners not in the file are dropped
+// with a "warning". The report function is called for each customer,
+// it returns an error if any failed.
+func UserRestore(r io.Reader, o UserRestoreOptions, report func (r UserImportResult)) (err error) {
+	var br     *bufio.Reader
+	var dec    *backupCipher
+	var failed  int
+	var total   int
+	var links []userRestoreLink
+	var ids     map[string]string = map[string]string{}
+
+	br = bufio.NewReader(r)
+	if magic, _ := br.Peek(8); string(magic) == "Salted__" {
+		if dec, err = backupDecrypt(br); err != nil {
+			return
+		}
+		br = bufio.NewReader(dec.out)
+	}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(nil, 16 * 1024 * 1024)
+	for line := 1; scanner.Scan(); line++ {
+		var b UserBackup
+		if len(bytes.TrimSpace(scanner.Bytes()))==0 {
+			continue
+		}
+		total++
+		res := UserImportResult{ Line: line }
+		if err = json.Unmarshal(scanner.Bytes(), &b); err == nil && b.Customer == nil {
+			err = fmt.Errorf("missing customer")
+		}
+		if err == nil {
+			res.Email = b.Customer.Email
+			res.Status, err = userRestoreOne(&b, o, ids)
+			if res.Status == "created" || res.Status == "valid" {
+				links = append(links, userRestoreLinks(line, &b, ids)...)
+			}
+		}
+		if err != nil {
+			res.Status, res.Error = "error", err.Error()
+			failed++
+			err = nil
+		}
+		report(res)
+	}
+	err = scanner.Err()
+
+	/* Team links. */
+	set, dropped := userRestoreRemap(links, ids)
+	for _, l := range dropped {
+		report(UserImportResult{ Line: l.Line, Email: l.Email, Status: "warning",
+			Error: fmt.Sprintf("%s %s not restored, link dropped", l.Key, l.Owner) })
+	}
+	for _, l := range set {
+		if o.Dry {
+			continue
+		}
+		p := &stripe.CustomerParams{}
+		p.AddMetadata(l.Key, l.Owner)
+		c, uerr := customer.Update(l.ID, p)
+		if uerr != nil {
+			report(UserImportResult{ Line: l.Line, Email: l.Email, Status: "error", Error: uerr.Error() })
+			failed++
+			continue
+		}
+		mirrorPutCustomer(c)
+	}
+
+	if dec != nil {
+		if cerr := dec.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d of %d users failed", failed, total)
+	}
+	return
+}
+
+// userRestoreLink is a team link of a restored customer, the owner is
+// a customer ID of the exported account.
+type userRestoreLink struct {
+	Line  int
+	Email string
+	ID    string
+	Key   string
+	Owner string
+}
+
+// userRestoreLinks returns the team links of a restored customer.
+func userRestoreLinks(line int, b *UserBackup, ids map[string]string) (links []userRestoreLink) {
+	id, found := ids[b.Customer.ID]
+	if !found {
+		return nil
+	}
+	for _, k := range []string{ TeamKey, TeamInviteKey } {
+		if o := b.Customer.Metadata[k]; len(o)>0 {
+			links = append(links, userRestoreLink{ line, b.Customer.Email, id, k, o })
+		}
+	}
+	return
+}
+
+// userRestoreRemap points the links to the restored owners, the links
+// to owners not restored are dropped.
+func userRestoreRemap(links []userRestoreLink, ids map[string]string) (set, dropped []userRestoreLink) {
+	for _, l := range links {
+		if id, found := ids[l.Owner]; found {
+			l.Owner = id
+			set = append(set, l)
+		} else {
+			dropped = append(dropped, l)
+		}
+	}
+	return
+}
+
+// userRestoreOne creates the customer, the new ID is added to ids.
+// Skipped customers are linked with the existing one. The team links
+// are set later by UserRestore.
+func userRestoreOne(b *UserBackup, o UserRestoreOptions, ids map[string]string) (status string, err error) {
+	var c *stripe.Customer
+	old := b.Customer
+	if len(old.Email)>0 {
+		if e, found := UserSearch(old.Email); found {
+			if len(old.ID)>0 {
+				ids[old.ID] = e.ID
+			}
+			return "skipped", nil
+		}
+	}
+	if o.Dry {
+		if len(old.ID)>0 {
+			ids[old.ID] = old.ID
+		}
+		return "valid", nil
+	}
+
+	/* Customer. */
+	params := &stripe.CustomerParams{}
+	params.Email       = stripe.String(old.Email)
+	params.Name        = stripe.String(old.Name)
+	params.Phone       = stripe.String(old.Phone)
+	params.Description = stripe.String(old.Description)
+	for _, l := range old.PreferredLocales {
+		params.PreferredLocales = append(params.PreferredLocales, stripe.String(l))
+	}
+	if a := old.Address; a != nil {
+		params.Address = &stripe.AddressParams{
+			City:       stripe.String(a.City),
+			Country:    stripe.String(a.Country),
+			Line1:      stripe.String(a.Line1),
+			Line2:      stripe.String(a.Line2),
+			PostalCode: stripe.String(a.PostalCode),
+			State:      stripe.String(a.State),
+		}
+	}
+	for k, v := range old.Metadata {
+		if k != TeamKey && k != TeamInviteKey {
+			params.AddMetadata(k, v)
+		}
+	}
+	params.AddMetadata("restored_from", old.ID)
+	if c, err = customer.New(params); err != nil {
+		return
+	}
+	mirrorPutCustomer(c)
+	if len(old.ID)>0 {
+		ids[old.ID] = c.ID
+	}
+
+	/* Tax IDs. */
+	for _, t := range b.TaxIDs {
+		_, err = taxid.New(&stripe.TaxIDParams{
+			Customer: stripe.String(c.ID),
+			Type:     stripe.String(string(t.Type)),
+			Value:    stripe.String(t.Value),
+		})
+		if err != nil {
+			return "created", fmt.Errorf("tax id %s: %s", t.Value, err)
+		}
+	}
+
+	/* Subscriptions, with a trial until the original period end so
+	 * nothing is charged before a payment method is added. */
+	for _, s := range b.Subscriptions {
+		if !o.Subscriptions || (s.Status != stripe.SubscriptionStatusActive && s.Status != stripe.SubscriptionStatusTrialing) {
+			continue
+		}
+		sp := &stripe.SubscriptionParams{ Customer: stripe.String(c.ID) }
+		for _, it := range s.Items.Data {
+			var priceID string
+			if priceID, err = userRestorePrice(it.Price); err != nil {
+				return "created", fmt.Errorf("subscription %s: %s", s.ID, err)
+			}
+			ip := &stripe.SubscriptionItemsParams{ Price: stripe.String(priceID) }
+			if it.Price.Recurring == nil || it.Price.Recurring.UsageType != stripe.PriceRecurringUsageTypeMetered {
+				ip.Quantity = stripe.Int64(it.Quantity)
+			}
+			sp.Items = append(sp.Items, ip)
+		}
+		if s.CurrentPeriodEnd > time.Now().Add(time.Hour).Unix() {
+			sp.TrialEnd = stripe.Int64(s.CurrentPeriodEnd)
+		}
+		for k, v := range s.Metadata {
+			sp.AddMetadata(k, v)
+		}
+		sp.AddMetadata("restored_from", s.ID)
+		ns, serr := subscription.New(sp)
+		if serr != nil {
+			return "created", fmt.Errorf("subscription %s: %s", s.ID, serr)
+		}
+		mirrorPutSubscription(ns)
+	}
+	return "created", nil
+}
+
+// userRestorePrice finds the price by lookup key, price IDs differ
+// between accounts and modes.
+func userRestorePrice(p *stripe.Price) (id string, err error) {
+	var pr   *stripe.Price
+	var found bool
+	if p == nil {
+		return "", fmt.Errorf("missing price")
+	}
+	if len(p.LookupKey)==0 {
+		return "", fmt.Errorf("%s: no matching price, it has no lookup key", p.ID)
+	}
+	if pr, found, err = PriceLookup(p.LookupKey); err != nil {
+		return
+	} else if !found {
+		return "", fmt.Errorf("%s: no matching price with lookup key %s", p.ID, p.LookupKey)
+	}
+	return pr.ID, nil
+}
+
+// backupCipher runs "openssl enc" with BackupPassword, writing to in
+// encrypts and reading from out decrypts.
+type backupCipher struct {
+	cmd    *exec.Cmd
+	in      io.WriteCloser
+	out     io.Reader
+	stderr  bytes.Buffer
+}
+
+func backupEncrypt(w io.Writer) (c *backupCipher, err error) {
+	c, err = backupOpenSSL()
+	if err != nil {
+		return
+	}
+	c.cmd.Stdout = w
+	if c.in, err = c.cmd.StdinPipe(); err != nil {
+		return
+	}
+	err = c.cmd.Start()
+	return
+}
+
+func backupDecrypt(r io.Reader) (c *backupCipher, err error) {
+	c, err = backupOpenSSL("-d")
+	if err != nil {
+		return
+	}
+	c.cmd.Stdin = r
+	if c.out, err = c.cmd.StdoutPipe(); err != nil {
+		return
+	}
+	err = c.cmd.Start()
+	return
+}
+
+func backupOpenSSL(args ...string) (c *backupCipher, err error) {
+	if len(BackupPassword)==0 {
+		return nil, fmt.Errorf("set USTRIPE_BACKUP_PASSWORD to encrypt/decrypt")
+	}
+	c = &backupCipher{}
+	args = append([]string{ "enc", "-aes-256-cbc", "-pbkdf2", "-pass", "env:USTRIPE_BACKUP_PASSWORD" }, args...)
+	c.cmd = exec.Command("openssl", args...)
+	c.cmd.Env = append(os.Environ(), "USTRIPE_BACKUP_PASSWORD=" + BackupPassword)
+	c.cmd.Stderr = &c.stderr
+	return
+}
+
+func (c *backupCipher) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+func (c *backupCipher) Close() (err error) {
+	if c.in != nil {
+		c.in.Close()
+	}
+	if c.out != nil {
+		io.Copy(io.Discard, c.out)
+	}
+	if err = c.cmd.Wait(); err != nil && c.stderr.Len()>0 {
+		err = fmt.Errorf("openssl: %s", strings.TrimSpace(c.stderr.String()))
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUserBackupSanitize(t *testing.T) {
+	b := &UserBackup{
+		Customer: &stripe.Customer{ Email: "John@Example.org", Name: "John", Phone: "600",
+			Address: &stripe.Address{ City: "Bilbao" },
+			Metadata: map[string]string{ "hash1": "$1$x$y", "plan": "pro", TeamKey: "cus_owner", TeamInviteKey: "cus_owner",
+				TeamEcodeKey: "x" } },
+		TaxIDs: []*stripe.TaxID{ { Value: "B00000000" } },
+	}
+	UserBackupSanitize(b)
+	c := b.Customer
+	if !strings.HasSuffix(c.Email, "@example.com") || strings.Contains(c.Email, "john") {
+		t.Errorf("email not sanitized: %s", c.Email)
+	}
+	if len(c.Name)>0 || len(c.Phone)>0 || c.Address != nil || b.TaxIDs != nil || len(c.Metadata["hash1"])>0 ||
+		len(c.Metadata[TeamKey])>0 || len(c.Metadata[TeamInviteKey])>0 || len(c.Metadata[TeamEcodeKey])>0 {
+		t.Errorf("personal data left: %+v", c)
+	}
+	if c.Metadata["plan"] != "pro" {
+		t.Errorf("metadata removed: %v", c.Metadata)
+	}
+	email := c.Email
+	b.Customer.Email = "john@example.org"
+	if UserBackupSanitize(b); b.Customer.Email != email {
+		t.Errorf("pseudonym not stable: %s != %s", b.Customer.Email, email)
+	}
+}
+
+func TestUserRestoreTeams(t *testing.T) {
+	ids := map[string]string{}
+	links := []userRestoreLink{}
+	for n, c := range []*stripe.Customer{
+		{ ID: "cus_member", Email: "m@b.c", Metadata: map[string]string{ TeamKey: "cus_owner" } },
+		{ ID: "cus_owner" , Email: "o@b.c" },
+		{ ID: "cus_orphan", Email: "x@b.c", Metadata: map[string]string{ TeamInviteKey: "cus_gone" } },
+	} {
+		ids[c.ID] = "new_" + c.ID
+		links = append(links, userRestoreLinks(n+1, &UserBackup{ Customer: c }, ids)...)
+	}
+	set, dropped := userRestoreRemap(links, ids)
+	switch {
+	case len(set) != 1 || set[0] != (userRestoreLink{ 1, "m@b.c", "new_cus_member", TeamKey, "new_cus_owner" }):
+		t.Errorf("links not remapped: %+v", set)
+	case len(dropped) != 1 || dropped[0] != (userRestoreLink{ 3, "x@b.c", "new_cus_orphan", TeamInviteKey, "cus_gone" }):
+		t.Errorf("links not dropped: %+v", dropped)
+	}
+}
+
+func TestBackupCipher(t *testing.T) {
+	var enc   bytes.Buffer
+	var data []byte
+	BackupPassword = "secret"
+	defer func() { BackupPassword = "" }()
+	w, err := backupEncrypt(&enc)
+	if err == nil {
+		w.Write([]byte("{}\n"))
+		err = w.Close()
+	}
+	if err != nil {
+		t.Skipf("openssl: %v", err)
+	}
+	if !bytes.HasPrefix(enc.Bytes(), []byte("Salted__")) {
+		t.Fatalf("not encrypted: %q", enc.Bytes())
+	}
+	r, err := backupDecrypt(bytes.NewReader(enc.Bytes()))
+	if err == nil {
+		data, _ = io.ReadAll(r.out)
+		err = r.Close()
+	}
+	if err != nil || string(data) != "{}\n" {
+		t.Errorf("decrypt: %q %v", data, err)
+	}
+	BackupPassword = "wrong"
+	if r, err = backupDecrypt(bytes.NewReader(enc.Bytes())); err == nil {
+		io.ReadAll(r.out)
+		err = r.Close()
+	}
+	if err == nil {
+		t.Errorf("decrypted with a wrong password")
+	}
+}
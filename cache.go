@@ -0,0 +1,206 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/price"
+	"github.com/stripe/stripe-go/v73/taxrate"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"sort"
+	"fmt"
+)
+
+// CacheStats holds the hit/miss counters of a kind of cached object.
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+	Entries       int   `json:"entries"`
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// CacheUsers enables caching UserSearch results for CacheTTL, for long
+// running processes that change customers only through this package.
+var CacheUsers bool
+
+var cacheMutex   sync.Mutex
+var cacheEntries map[string]cacheEntry = map[string]cacheEntry{}
+var cacheStats   map[string]*CacheStats = map[string]*CacheStats{}
+
+// Products returns all defined products, cached for CacheTTL.
+func Products() (prods []*stripe.Product, err error) {
+	if v, found := cacheGet("products", ""); found {
+		return v.([]*stripe.Product), nil
+	}
+	i := ProductList()
+	for i.Next() {
+		prods = append(prods, i.Product())
+	}
+	if err = i.Err(); err != nil {
+		return
+	}
+	cachePut("products", "", prods)
+	for _, p := range prods {
+		cachePut("product", p.ID, p)
+	}
+	return
+}
+
+// PriceFetch returns a price, cached for CacheTTL.
+func PriceFetch(priceID string) (p *stripe.Price, err error) {
+	if v, found := cacheGet("price", priceID); found {
+		return v.(*stripe.Price), nil
+	}
+	p, err = price.Get(priceID, nil)
+	if err != nil {
+		return
+	}
+	cachePut("price", priceID, p)
+	return
+}
+
+// TaxRates returns all defined taxes, cached for CacheTTL.
+func TaxRates() (taxes []*stripe.TaxRate, err error) {
+	if v, found := cacheGet("taxrates", ""); found {
+		return v.([]*stripe.TaxRate), nil
+	}
+	i := TaxList()
+	for i.Next() {
+		taxes = append(taxes, i.TaxRate())
+	}
+	if err = i.Err(); err != nil {
+		return
+	}
+	cachePut("taxrates", "", taxes)
+	return
+}
+
+// TaxRateFetch returns a tax rate, cached for CacheTTL.
+func TaxRateFetch(taxID string) (t *stripe.TaxRate, err error) {
+	if v, found := cacheGet("taxrate", taxID); found {
+		return v.(*stripe.TaxRate), nil
+	}
+	t, err = taxrate.Get(taxID, nil)
+	if err != nil {
+		return
+	}
+	cachePut("taxrate", taxID, t)
+	return
+}
+
+// CacheInvalidate removes an object from the cache. An empty id
+// removes all objects of the kind, an empty kind clears the cache.
+func CacheInvalidate(kind, id string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	for key := range cacheEntries {
+		k, i, _ := strings.Cut(key, "/")
+		if (len(kind)==0 || k == kind) && (len(id)==0 || i == id) {
+			delete(cacheEntries, key)
+			cacheStat(k).Invalidations++
+		}
+	}
+}
+
+// CacheEvent invalidates the cached objects changed by the event.
+func CacheEvent(e *stripe.Event) (err error) {
+	switch {
+	case strings.HasPrefix(e.Type, "product."):
+		CacheInvalidate("product" , eventString(e, "id"))
+		CacheInvalidate("products", "")
+		CacheInvalidate("resolve" , "")
+	case strings.HasPrefix(e.Type, "price."):
+		CacheInvalidate("price"   , eventString(e, "id"))
+		CacheInvalidate("product" , eventString(e, "product"))
+		CacheInvalidate("products", "")
+		CacheInvalidate("prices"  , eventString(e, "product"))
+		CacheInvalidate("lookup"  , "")
+		CacheInvalidate("resolve" , "")
+	case strings.HasPrefix(e.Type, "tax_rate."):
+		CacheInvalidate("taxrate" , eventString(e, "id"))
+		CacheInvalidate("taxrates", "")
+	case strings.HasPrefix(e.Type, "customer."):
+		CacheInvalidate("user"    , eventEmail(e))
+	}
+	return nil
+}
+
+// CacheStatistics returns the statistics per kind of object.
+func CacheStatistics() (stats map[string]CacheStats) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	stats = map[string]CacheStats{}
+	for kind, s := range cacheStats {
+		stats[kind] = *s
+	}
+	for key := range cacheEntries {
+		kind, _, _ := strings.Cut(key, "/")
+		s := stats[kind]
+		s.Entries++
+		stats[kind] = s
+	}
+	return
+}
+
+// CacheStatsPrint prints the cache statistics to the terminal.
+func CacheStatsPrint() {
+	stats := CacheStatistics()
+	kinds := []string{}
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		s := stats[kind]
+		fmt.Printf("%-10s hits=%-6v misses=%-6v invalidations=%-6v entries=%v\n",
+			kind, s.Hits, s.Misses, s.Invalidations, s.Entries)
+	}
+}
+
+// CacheStatsHandler serves the cache statistics in JSON. It isn't
+// served by WebhookServe, mount it in a private listener.
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CacheStatistics())
+}
+
+func cacheGet(kind, id string) (v interface{}, found bool) {
+	var e cacheEntry
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	e, found = cacheEntries[kind + "/" + id]
+	if found && time.Now().After(e.expires) {
+		delete(cacheEntries, kind + "/" + id)
+		found = false
+	}
+	if found {
+		cacheStat(kind).Hits++
+		return e.value, true
+	}
+	cacheStat(kind).Misses++
+	return nil, false
+}
+
+func cachePut(kind, id string, v interface{}) {
+	if CacheTTL <= 0 {
+		return
+	}
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cacheEntries[kind + "/" + id] = cacheEntry{ value: v, expires: time.Now().Add(CacheTTL) }
+}
+
+func cacheStat(kind string) (s *CacheStats) {
+	if s = cacheStats[kind]; s == nil {
+		s = &CacheStats{}
+		cacheStats[kind] = s
+	}
+	return
+}
@@ -0,0 +1,479 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/client"
+	"encoding/json"
+	"strings"
+	"sort"
+	"fmt"
+	"os"
+)
+
+// CatalogManagedKey is the product metadata key marking products
+// created by CatalogApply. Only those are archived when removed from
+// the catalog file.
+const CatalogManagedKey = "ustripe_catalog"
+
+// Catalog is the desired state of the products and prices.
+type Catalog struct {
+	Products []*CatalogProduct `json:"products"`
+}
+
+// CatalogProduct is a product in the catalog file.
+type CatalogProduct struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	TaxCode     string            `json:"tax_code,omitempty"`
+	Features    []string          `json:"features,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Prices      []*CatalogPrice   `json:"prices,omitempty"`
+	unmanaged   bool
+}
+
+// CatalogPrice is a price in the catalog file. Amounts are in the
+// currency's minor unit (cents).
+type CatalogPrice struct {
+	LookupKey     string            `json:"lookup_key,omitempty"`
+	Nickname      string            `json:"nickname,omitempty"`
+	Currency      string            `json:"currency"`
+	UnitAmount    int64             `json:"unit_amount"`
+	Interval      string            `json:"interval,omitempty"`
+	IntervalCount int64             `json:"interval_count,omitempty"`
+	UsageType     string            `json:"usage_type,omitempty"`
+	TaxBehavior   string            `json:"tax_behavior,omitempty"`
+	Default       bool              `json:"default,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// CatalogChange is an step of the plan calculated by CatalogPlan.
+type CatalogChange struct {
+	Action   string // "+" create, "~" update, "-" archive, "!" differs (not applied).
+	Kind     string // "product", "price" or "default".
+	Product  string
+	ID       string // Existing Stripe object.
+	Detail   string
+	product *CatalogProduct
+	price   *CatalogPrice
+}
+
+// CatalogLoad reads a catalog JSON file.
+func CatalogLoad(file string) (c *Catalog, err error) {
+	var fp *os.File
+	fp, err = os.Open(file)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	d := json.NewDecoder(fp)
+	d.DisallowUnknownFields()
+	c = &Catalog{}
+	err = d.Decode(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	err = c.Check()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return
+}
+
+// Check verifies the catalog is well formed.
+func (c *Catalog) Check() (err error) {
+	ids := map[string]bool{}
+	keys := map[string]bool{}
+	for _, p := range c.Products {
+		switch {
+		case len(p.ID)==0:   return fmt.Errorf("product without id")
+		case len(p.Name)==0: return fmt.Errorf("%s: missing name", p.ID)
+		case ids[p.ID]:      return fmt.Errorf("%s: duplicated product", p.ID)
+		}
+		ids[p.ID] = true
+		for _, pr := range p.Prices {
+			switch {
+			case len(pr.Currency)==0:
+				return fmt.Errorf("%s: price without currency", p.ID)
+			case len(pr.LookupKey)>0 && keys[pr.LookupKey]:
+				return fmt.Errorf("%s: duplicated lookup key %s", p.ID, pr.LookupKey)
+			case len(pr.Interval)==0 && (pr.IntervalCount != 0 || len(pr.UsageType)>0):
+				return fmt.Errorf("%s: interval_count/usage_type without interval", p.ID)
+			}
+			keys[pr.LookupKey] = len(pr.LookupKey)>0
+		}
+	}
+	return nil
+}
+
+// CatalogApply makes the Stripe account match the catalog. When dry
+// is true only the plan is returned.
+func CatalogApply(c *Catalog, dry bool) (changes []CatalogChange, err error) {
+	return catalogApply(client.New(stripe.Key, nil), c, dry)
+}
+
+// CatalogCurrent returns the catalog of the account in use.
+func CatalogCurrent() (c *Catalog, err error) {
+	return CatalogFetch(client.New(stripe.Key, nil))
+}
+
+// CatalogFetch builds a catalog with the active products and prices
+// of an account.
+func CatalogFetch(api *client.API) (c *Catalog, err error) {
+	var prods  []*stripe.Product
+	var prices []*stripe.Price
+	prods, prices, err = catalogState(api)
+	if err != nil {
+		return
+	}
+	c = &Catalog{}
+	for _, sp := range prods {
+		if !sp.Active {
+			continue
+		}
+		cp := &CatalogProduct{
+			ID:          sp.ID,
+			Name:        sp.Name,
+			Description: sp.Description,
+			Metadata:    map[string]string{},
+		}
+		if sp.TaxCode != nil {
+			cp.TaxCode = sp.TaxCode.ID
+		}
+		for k, v := range sp.Metadata {
+			if k == "features" {
+				cp.Features = strings.Split(v, "\n")
+			} else if k != CatalogManagedKey {
+				cp.Metadata[k] = v
+			}
+		}
+		for _, p := range prices {
+			if p.Product != nil && p.Product.ID == sp.ID {
+				cpr := catalogPriceOf(p)
+				cpr.Default = sp.DefaultPrice != nil && sp.DefaultPrice.ID == p.ID
+				cp.Prices = append(cp.Prices, cpr)
+			}
+		}
+		c.Products = append(c.Products, cp)
+	}
+	return
+}
+
+// CatalogPlan calculates the changes needed to go from the products
+// and active prices to the catalog.
+func CatalogPlan(c *Catalog, prods []*stripe.Product, prices []*stripe.Price) (changes []CatalogChange) {
+	var stripeProds  map[string]*stripe.Product = map[string]*stripe.Product{}
+	var stripePrices map[string][]*stripe.Price = map[string][]*stripe.Price{}
+	var inCatalog    map[string]bool            = map[string]bool{}
+
+	for _, p := range prods {
+		stripeProds[p.ID] = p
+	}
+	for _, p := range prices {
+		if p.Active && p.Product != nil {
+			stripePrices[p.Product.ID] = append(stripePrices[p.Product.ID], p)
+		}
+	}
+
+	for _, cp := range c.Products {
+		inCatalog[cp.ID] = true
+		sp := stripeProds[cp.ID]
+		if sp == nil {
+			changes = append(changes, CatalogChange{ Action: "+", Kind: "product", Product: cp.ID, Detail: cp.Name, product: cp })
+		} else if diff := catalogProductDiff(cp, sp); len(diff)>0 {
+			changes = append(changes, CatalogChange{ Action: "~", Kind: "product", Product: cp.ID, ID: sp.ID, Detail: diff, product: cp })
+		}
+
+		matched   := map[string]bool{}
+		archives  := []CatalogChange{}
+		for n, cpr := range cp.Prices {
+			var m *stripe.Price
+			for _, a := range stripePrices[cp.ID] {
+				if !matched[a.ID] && cpr.matches(a) {
+					m = a
+					break
+				}
+			}
+			if m != nil {
+				matched[m.ID] = true
+				if m.LookupKey != cpr.LookupKey && len(cpr.LookupKey)>0 {
+					changes = append(changes, CatalogChange{ Action: "~", Kind: "price", Product: cp.ID, ID: m.ID,
+						Detail: "lookup_key " + cpr.LookupKey, product: cp, price: cpr })
+				}
+			} else {
+				changes = append(changes, CatalogChange{ Action: "+", Kind: "price", Product: cp.ID,
+					Detail: cpr.String(), product: cp, price: cpr })
+			}
+			if cp.defaultPrice() == n && (sp == nil || sp.DefaultPrice == nil || m == nil || sp.DefaultPrice.ID != m.ID) {
+				d := CatalogChange{ Action: "~", Kind: "default", Product: cp.ID, Detail: cpr.String(), product: cp, price: cpr }
+				if m != nil {
+					d.ID = m.ID
+				}
+				archives = append([]CatalogChange{d}, archives...)
+			}
+		}
+		for _, a := range stripePrices[cp.ID] {
+			if !matched[a.ID] {
+				archives = append(archives, CatalogChange{ Action: "-", Kind: "price", Product: cp.ID, ID: a.ID,
+					Detail: catalogPriceOf(a).String() })
+			}
+		}
+		changes = append(changes, archives...)
+	}
+
+	ids := []string{}
+	for id := range stripeProds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		sp := stripeProds[id]
+		if !inCatalog[id] && sp.Active && sp.Metadata[CatalogManagedKey] == "y" {
+			changes = append(changes, CatalogChange{ Action: "-", Kind: "product", Product: id, ID: id, Detail: sp.Name })
+		}
+	}
+	return
+}
+
+// String returns a diff like representation of the change.
+func (c CatalogChange) String() string {
+	return fmt.Sprintf("%s %-8s %-20s %s", c.Action, c.Kind, c.Product, c.Detail)
+}
+
+// String returns a short description of the price.
+func (p *CatalogPrice) String() (s string) {
+	s = Money{ Minor: float64(p.UnitAmount), Currency: p.Currency }.String()
+	if len(p.Interval)>0 {
+		s += fmt.Sprintf("/%v %s", p.intervalCount(), p.Interval)
+	}
+	if len(p.UsageType)>0 {
+		s += " " + p.UsageType
+	}
+	if len(p.LookupKey)>0 {
+		s += " key=" + p.LookupKey
+	}
+	return
+}
+
+func catalogApply(api *client.API, c *Catalog, dry bool) (changes []CatalogChange, err error) {
+	var prods  []*stripe.Product
+	var prices []*stripe.Price
+
+	prods, prices, err = catalogState(api)
+	if err != nil {
+		return
+	}
+	changes = CatalogPlan(c, prods, prices)
+	if dry {
+		return
+	}
+	return changes, catalogExecuteAll(api, changes)
+}
+
+func catalogState(api *client.API) (prods []*stripe.Product, prices []*stripe.Price, err error) {
+	pp := &stripe.ProductListParams{}
+	pp.Filters.AddFilter("limit", "", "100")
+	ip := api.Products.List(pp)
+	for ip.Next() {
+		prods = append(prods, ip.Product())
+	}
+	if err = ip.Err(); err != nil {
+		return
+	}
+	pr := &stripe.PriceListParams{ Active: stripe.Bool(true) }
+	pr.Filters.AddFilter("limit", "", "100")
+	ir := api.Prices.List(pr)
+	for ir.Next() {
+		prices = append(prices, ir.Price())
+	}
+	err = ir.Err()
+	return
+}
+
+func catalogExecuteAll(api *client.API, changes []CatalogChange) (err error) {
+	defer CacheInvalidate("", "")
+	created := map[*CatalogPrice]string{}
+	for _, ch := range changes {
+		err = catalogExecute(api, ch, created)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ch.String(), err)
+		}
+	}
+	return nil
+}
+
+func catalogExecute(api *client.API, ch CatalogChange, created map[*CatalogPrice]string) (err error) {
+	var p *stripe.Price
+	switch {
+	case ch.Kind == "product" && ch.Action == "-":
+		_, err = api.Products.Update(ch.ID, &stripe.ProductParams{ Active: stripe.Bool(false) })
+	case ch.Kind == "product":
+		params := &stripe.ProductParams{
+			Active:      stripe.Bool(true),
+			Name:        stripe.String(ch.product.Name),
+			Description: stripe.String(ch.product.Description),
+		}
+		if len(ch.product.TaxCode)>0 {
+			params.TaxCode = stripe.String(ch.product.TaxCode)
+		}
+		for k, v := range ch.product.metadata() {
+			params.AddMetadata(k, v)
+		}
+		if ch.Action == "+" {
+			params.ID = stripe.String(ch.product.ID)
+			_, err = api.Products.New(params)
+		} else {
+			_, err = api.Products.Update(ch.ID, params)
+		}
+	case ch.Kind == "price" && ch.Action == "-":
+		_, err = api.Prices.Update(ch.ID, &stripe.PriceParams{ Active: stripe.Bool(false) })
+	case ch.Kind == "price" && ch.Action == "~":
+		_, err = api.Prices.Update(ch.ID, &stripe.PriceParams{
+			LookupKey:         stripe.String(ch.price.LookupKey),
+			TransferLookupKey: stripe.Bool(true),
+		})
+	case ch.Kind == "price":
+		p, err = api.Prices.New(ch.price.params(ch.Product))
+		if err == nil {
+			created[ch.price] = p.ID
+		}
+	case ch.Kind == "default":
+		id := ch.ID
+		if len(id)==0 {
+			id = created[ch.price]
+		}
+		_, err = api.Products.Update(ch.Product, &stripe.ProductParams{ DefaultPrice: stripe.String(id) })
+	}
+	return
+}
+
+func catalogProductDiff(cp *CatalogProduct, sp *stripe.Product) (diff string) {
+	d := []string{}
+	if !sp.Active {
+		d = append(d, "active")
+	}
+	if cp.Name != sp.Name {
+		d = append(d, "name")
+	}
+	if cp.Description != sp.Description {
+		d = append(d, "description")
+	}
+	if len(cp.TaxCode)>0 && (sp.TaxCode == nil || sp.TaxCode.ID != cp.TaxCode) {
+		d = append(d, "tax_code")
+	}
+	for k, v := range cp.metadata() {
+		if sp.Metadata[k] != v {
+			d = append(d, "metadata." + k)
+		}
+	}
+	sort.Strings(d)
+	return strings.Join(d, ",")
+}
+
+func catalogPriceOf(p *stripe.Price) (c *CatalogPrice) {
+	c = &CatalogPrice{
+		LookupKey:   p.LookupKey,
+		Nickname:    p.Nickname,
+		Currency:    string(p.Currency),
+		UnitAmount:  p.UnitAmount,
+		TaxBehavior: string(p.TaxBehavior),
+		Metadata:    p.Metadata,
+	}
+	if p.Recurring != nil {
+		c.Interval      = string(p.Recurring.Interval)
+		c.IntervalCount = p.Recurring.IntervalCount
+		c.UsageType     = string(p.Recurring.UsageType)
+	}
+	return
+}
+
+func (cp *CatalogProduct) metadata() (m map[string]string) {
+	m = map[string]string{}
+	for k, v := range cp.Metadata {
+		m[k] = v
+	}
+	if len(cp.Features)>0 {
+		m["features"] = strings.Join(cp.Features, "\n")
+	}
+	if !cp.unmanaged {
+		m[CatalogManagedKey] = "y"
+	}
+	return
+}
+
+func (cp *CatalogProduct) defaultPrice() int {
+	for n, p := range cp.Prices {
+		if p.Default {
+			return n
+		}
+	}
+	return 0
+}
+
+func (p *CatalogPrice) intervalCount() int64 {
+	if p.IntervalCount == 0 {
+		return 1
+	}
+	return p.IntervalCount
+}
+
+func (p *CatalogPrice) usageType() string {
+	if len(p.UsageType)==0 {
+		return string(stripe.PriceRecurringUsageTypeLicensed)
+	}
+	return p.UsageType
+}
+
+func (p *CatalogPrice) matches(s *stripe.Price) bool {
+	switch {
+	case !strings.EqualFold(string(s.Currency), p.Currency):
+		return false
+	case s.UnitAmount != p.UnitAmount:
+		return false
+	case len(p.TaxBehavior)>0 && string(s.TaxBehavior) != p.TaxBehavior:
+		return false
+	case len(p.Interval)==0:
+		return s.Recurring == nil
+	case s.Recurring == nil:
+		return false
+	case string(s.Recurring.Interval) != p.Interval:
+		return false
+	case s.Recurring.IntervalCount != p.intervalCount():
+		return false
+	case string(s.Recurring.UsageType) != p.usageType():
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *CatalogPrice) params(prodID string) (params *stripe.PriceParams) {
+	params = &stripe.PriceParams{
+		Product:    stripe.String(prodID),
+		Currency:   stripe.String(strings.ToLower(p.Currency)),
+		UnitAmount: stripe.Int64(p.UnitAmount),
+	}
+	if len(p.LookupKey)>0 {
+		params.LookupKey         = stripe.String(p.LookupKey)
+		params.TransferLookupKey = stripe.Bool(true)
+	}
+	if len(p.Nickname)>0 {
+		params.Nickname = stripe.String(p.Nickname)
+	}
+	if len(p.TaxBehavior)>0 {
+		params.TaxBehavior = stripe.String(p.TaxBehavior)
+	}
+	if len(p.Interval)>0 {
+		params.Recurring = &stripe.PriceRecurringParams{
+			Interval:      stripe.String(p.Interval),
+			IntervalCount: stripe.Int64(p.intervalCount()),
+		}
+		if len(p.UsageType)>0 {
+			params.Recurring.UsageType = stripe.String(p.UsageType)
+		}
+	}
+	for k, v := range p.Metadata {
+		params.AddMetadata(k, v)
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"testing"
+)
+
+func TestCatalogPlan(t *testing.T) {
+	c := &Catalog{ Products: []*CatalogProduct{
+		{ ID: "pro", Name: "Pro", Prices: []*CatalogPrice{
+			{ Currency: "eur", UnitAmount: 1200, Interval: "month", LookupKey: "pro_monthly" },
+		}},
+		{ ID: "new", Name: "New" },
+	}}
+	prods := []*stripe.Product{
+		{ ID: "pro", Name: "Pro", Active: true, DefaultPrice: &stripe.Price{ ID: "price_old" },
+			Metadata: map[string]string{ CatalogManagedKey: "y" }},
+		{ ID: "old", Name: "Old", Active: true, Metadata: map[string]string{ CatalogManagedKey: "y" }},
+		{ ID: "manual", Name: "Manual", Active: true },
+	}
+	prices := []*stripe.Price{
+		{ ID: "price_old", Active: true, Product: &stripe.Product{ ID: "pro" }, Currency: "eur",
+			UnitAmount: 1000, LookupKey: "pro_monthly",
+			Recurring: &stripe.PriceRecurring{ Interval: "month", IntervalCount: 1, UsageType: "licensed" }},
+	}
+	MoneyLanguage = "en"
+	res := []string{
+		"+ price    pro                  €12.00/1 month key=pro_monthly",
+		"~ default  pro                  €12.00/1 month key=pro_monthly",
+		"- price    pro                  €10.00/1 month licensed key=pro_monthly",
+		"+ product  new                  New",
+		"- product  old                  Old",
+	}
+	changes := CatalogPlan(c, prods, prices)
+	if len(changes) != len(res) {
+		t.Fatalf("expected %v changes, got %v: %v", len(res), len(changes), changes)
+	}
+	for i, ch := range changes {
+		if ch.String() != res[i] {
+			t.Fatalf("change %v: %q != %q", i, ch.String(), res[i])
+		}
+	}
+}
+
+func TestPromoteChanges(t *testing.T) {
+	c := &Catalog{ Products: []*CatalogProduct{
+		{ ID: "pro", Name: "Pro", Prices: []*CatalogPrice{
+			{ Currency: "eur", UnitAmount: 1200, Interval: "month", LookupKey: "pro_monthly" },
+		}, unmanaged: true },
+		{ ID: "new", Name: "New", Prices: []*CatalogPrice{
+			{ Currency: "eur", UnitAmount: 500 },
+		}, unmanaged: true },
+	}}
+	prods := []*stripe.Product{
+		{ ID: "pro", Name: "Pro v1", Active: true, DefaultPrice: &stripe.Price{ ID: "price_pro" }},
+		{ ID: "live", Name: "Live only", Active: true },
+	}
+	prices := []*stripe.Price{
+		{ ID: "price_pro", Active: true, Product: &stripe.Product{ ID: "pro" }, Currency: "eur",
+			UnitAmount: 1200, LookupKey: "pro_monthly",
+			Recurring: &stripe.PriceRecurring{ Interval: "month", IntervalCount: 1, UsageType: "licensed" }},
+	}
+	MoneyLanguage = "en"
+	apply, differ := promoteChanges(CatalogPlan(c, prods, prices))
+	res := []string{
+		"+ product  new                  New",
+		"+ price    new                  €5.00",
+		"~ default  new                  €5.00",
+		"! product  pro                  name",
+	}
+	changes := append(apply, differ...)
+	if len(changes) != len(res) {
+		t.Fatalf("expected %v changes, got %v: %v", len(res), len(changes), changes)
+	}
+	for i, ch := range changes {
+		if ch.String() != res[i] {
+			t.Errorf("change %v: %q != %q", i, ch.String(), res[i])
+		}
+	}
+	if _, tagged := c.Products[1].metadata()[CatalogManagedKey]; tagged {
+		t.Errorf("promoted products are tagged with %s", CatalogManagedKey)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bufio"
+	"strings"
+	"github.com/harkaitz/ustripe"
+)
+
+// batchLine is a command read by batch.
+type batchLine struct {
+	n    int
+	text string
+	argv []string
+	cmd  *command
+	err  error
+}
+
+func init() {
+	commands = append(commands, &command{
+		name: "batch", usage: "[FILE] [--continue-on-error] [--dry-run] [report=FILE]",
+		brief: "Run commands read from a file or stdin.",
+		doc:
+`Each line is a command with the same syntax as the command line, quotes
+and backslashes are supported, empty lines and lines starting with '#'
+are ignored. All lines are checked before running any, with --dry-run
+only the check is done. The first failing line stops the batch unless
+--continue-on-error is given. A report with the line number, status
+(ok|error|skipped|valid), command and error is written in TSV to stderr
+or the report file.`,
+		keys: []string{ "report" }, flags: []string{ "continue-on-error", "dry-run" },
+		output: true, run: cmdBatch,
+	})
+}
+
+func cmdBatch(c *command, kvs map[string]string, args []string) (err error) {
+	var in      io.Reader = os.Stdin
+	var report  io.Writer = os.Stderr
+	var lines []*batchLine
+	var failed  error
+	var errors  int
+
+	if len(args)>1 {
+		return usagef("batch: only one file accepted")
+	} else if len(args)==1 && args[0] != "-" {
+		fp, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		in = fp
+	}
+	if file, found := kvs["report"]; found {
+		fp, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		report = fp
+	}
+	continueOnError := kvs["continue-on-error"] == "y"
+
+	/* Read and check all lines before running any. */
+	format, fields := outputFormat, outputFields
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		l := &batchLine{ n: n, text: strings.TrimSpace(scanner.Text()) }
+		if len(l.text)==0 || l.text[0] == '#' {
+			continue
+		}
+		lines = append(lines, l)
+		if l.argv, l.err = batchSplit(l.text); l.err != nil {
+			l.err = usagef("%s", l.err)
+		} else if len(l.argv)==0 {
+			continue
+		} else if l.cmd = commandGet(l.argv[0]); l.cmd == nil || l.cmd.raw || l.cmd.name == "batch" || l.cmd.name == "tui" {
+			l.err = usagef("invalid command: %s", l.argv[0])
+		} else {
+			_, _, l.err = l.cmd.parse(l.argv[1:])
+		}
+		outputFormat, outputFields, helpFlag = format, fields, false
+		if l.err != nil && failed == nil {
+			failed = l.err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	/* Run. */
+	ustripe.CacheUsers = true
+	for _, l := range lines {
+		status := "ok"
+		switch {
+		case l.argv == nil && l.err == nil:
+			continue
+		case l.err != nil:
+			status = "error"
+		case kvs["dry-run"] == "y":
+			status = "valid"
+		case failed != nil && !continueOnError:
+			status = "skipped"
+		default:
+			outputFormat, outputFields, helpFlag = format, fields, false
+			if l.err = l.cmd.exec(l.argv[1:]); l.err != nil {
+				status = "error"
+				if failed == nil {
+					failed = l.err
+				}
+			}
+		}
+		msg := ""
+		if l.err != nil {
+			errors++
+			msg = strings.ReplaceAll(l.err.Error(), "\n", " ")
+		}
+		name := ""
+		if len(l.argv)>0 {
+			name = l.argv[0]
+		}
+		fmt.Fprintf(report, "%d\t%s\t%s\t%s\n", l.n, status, name, msg)
+	}
+	if failed != nil {
+		code := 1
+		if e, ok := failed.(*exitError); ok {
+			code = e.code
+		}
+		return &exitError{ code, fmt.Errorf("batch: %d of %d lines failed", errors, len(lines)) }
+	}
+	return nil
+}
+
+// batchSplit splits a line in words like sh(1) does, without expansions.
+func batchSplit(line string) (words []string, err error) {
+	var word  strings.Builder
+	var quote rune
+	var inWord, escaped bool
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchSplit(t *testing.T) {
+	for _, c := range []struct {
+		line  string
+		words []string
+	}{
+		{ `user-edit e=a@b.c name="John Doe"`, []string{ "user-edit", "e=a@b.c", "name=John Doe" } },
+		{ `  a   'b c'  d\ e ""`             , []string{ "a", "b c", "d e", "" } },
+		{ `x "it's" 'say "hi"' \\`           , []string{ "x", "it's", `say "hi"`, `\` } },
+	} {
+		words, err := batchSplit(c.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(words, c.words) {
+			t.Errorf("%s: got %q, want %q", c.line, words, c.words)
+		}
+	}
+	if _, err := batchSplit(`a "b`); err == nil {
+		t.Errorf("expected unterminated quote error")
+	}
+}
@@ -0,0 +1,1140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"log"
+	"strings"
+	"strconv"
+	"time"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"github.com/harkaitz/ustripe"
+	"github.com/stripe/stripe-go/v73"
+)
+
+// commands are listed in the help in this order, an empty command is
+// printed as a separator.
+var commands = []*command{
+	{ name: "hash1" , usage: "p=PASSWORD", brief: "Calculate hash of the password.",
+		reqs: []string{ "password" }, run: cmdHash1 },
+	{ name: "login" , usage: "e=EMAIL p=PASS", brief: "Check password.",
+		reqs: []string{ "email", "password" }, run: cmdLogin },
+	{ name: "chpass", usage: "e=EMAIL p=PASS", brief: "Change password.",
+		reqs: []string{ "email", "password" }, run: cmdChpass },
+	{ name: "www"   , usage: "[PAGE]", brief: "Open the stripe dashboard and resources.",
+		run: cmdWWW },
+	{},
+	{ name: "tax-list", brief: "List defined taxes.", output: true, run: cmdTaxList },
+	{},
+	{ name: "prod-list" , brief: "List defined products.", output: true, run: cmdProdList },
+	{ name: "prod-price", usage: "PRODUCT...", brief: "Convert from product to price.",
+		complete: "product", run: cmdProdPrice },
+	{ name: "price-list", usage: "PRODUCT...", brief: "List prices of a product (* default).",
+		complete: "product", output: true, run: cmdPriceList },
+	{},
+	{ name: "catalog-apply", usage: "FILE [dry=y]", brief: "Make products/prices match a JSON catalog.",
+		flags: []string{ "dry" }, run: cmdCatalogApply },
+	{ name: "catalog-dump" , brief: "Print the catalog in JSON.", run: cmdCatalogDump },
+	{ name: "promote"      , usage: "[confirm=y]", brief: "Copy missing test products/prices/taxes to live.",
+		flags: []string{ "confirm" }, run: cmdPromote },
+	{},
+	{ name: "user-list"    , brief: "List users.", output: true, run: cmdUserList },
+	{ name: "user-get-json", usage: "e=EMAIL", brief: "Print user JSON.",
+		reqs: []string{ "email" }, run: cmdUserGetJSON },
+	{ name: "user-get-subs", usage: "e=EMAIL [PROD...] [status=y [all=y]]", brief: "User's subscribed products.",
+		doc:
+`With status=y the subscriptions are printed with status and expiry,
+all=y includes the ones not granting access.`,
+		reqs: []string{ "email" }, flags: []string{ "status", "all" }, output: true, run: cmdUserGetSubs },
+	{ name: "user-info"    , usage: "e=EMAIL", brief: "User information.",
+		reqs: []string{ "email" }, output: true, run: cmdUserInfo },
+	{},
+	{ name: "user-add"     , usage: "e=EMAIL p=PASS [l=LANG] [v=y|n] [@KEY=VALUE...]", brief: "Add new user.",
+		reqs: []string{ "email", "password" }, keys: []string{ "language", "verified" },
+		meta: true, run: cmdUserAdd },
+	{ name: "user-import"  , usage: "FILE [format=csv|json] [update=y] [workers=N] [rate=N] [progress=FILE] [map=COL:KEY,...]",
+		brief: "Create or update users from a CSV/JSON file.",
+		doc:
+`The columns are "email", "password" or "password_hash" (crypt $1$,
+$5$ or $6$), the user-edit parameters and @KEY metadata, other names
+can be mapped with map=. Existing users are skipped unless update=y.
+Imported users are appended to the progress file (FILE.progress) and
+skipped when the import is run again. By default 4 workers and 20
+users per second.`,
+		keys: []string{ "format", "workers", "rate", "progress", "map" },
+		flags: []string{ "update" }, output: true, run: cmdUserImport },
+	{ name: "user-export"  , usage: "[FILE] [sanitize=y] [encrypt=y]", brief: "Export all users in JSON lines.",
+		doc:
+`Users are written with their tax IDs and subscriptions. With sanitize=y
+emails are replaced by pseudonyms and names, phones, addresses, tax IDs,
+password hashes and team links removed, other metadata is kept. With
+encrypt=y the file is encrypted with "openssl enc" and
+USTRIPE_BACKUP_PASSWORD.`,
+		flags: []string{ "sanitize", "encrypt" }, run: cmdUserExport },
+	{ name: "user-restore" , usage: "[FILE] [subs=y] [dry=y]", brief: "Create users from a user-export file.",
+		doc:
+`Users are created in the current account and mode, existing emails are
+skipped. Team links are set to the new IDs, links to owners missing in
+the file are dropped with a warning. With subs=y active subscriptions are
+recreated with prices matched by lookup key and a trial until their
+current period end. Encrypted files are decrypted with
+USTRIPE_BACKUP_PASSWORD.`,
+		flags: []string{ "subs", "dry" }, output: true, run: cmdUserRestore },
+	{ name: "user-del"     , usage: "EMAIL...", brief: "Delete user.",
+		complete: "email", run: cmdUserDel },
+	{ name: "user-edit"    , usage: "e=EMAIL PARAMS...", brief: "Edit user.",
+		doc:
+`Parameters: l=LANG v=y|n name= phone= description= cif= addr1= addr2=
+city= zipcode= state= country= @KEY=VALUE`,
+		reqs: []string{ "email" }, keys: ustripe.UserEditKeys,
+		meta: true, run: cmdUserEdit },
+	{ name: "user-data-export", usage: "e=EMAIL [out=FILE]", brief: "Export all data kept about the user in JSON.",
+		reqs: []string{ "email" }, keys: []string{ "out" }, run: cmdUserDataExport },
+	{ name: "user-erase"   , usage: "e=EMAIL [anonymize=y] [confirm=y]", brief: "Erase the user (GDPR).",
+		doc:
+`Cancels the subscriptions, deletes the customer (anonymize=y keeps it
+without personal data, with its invoices) and removes the user from
+the event store, mirror, cache, dead letters, usage buffer and dunning
+file, then runs HOOKS_DIR/user.erased. Without confirm=y only the
+actions are listed. The receipt is signed with USTRIPE_ERASURE_KEY and
+appended to USTRIPE_ERASURE_FILE.`,
+		reqs: []string{ "email" }, flags: []string{ "anonymize", "confirm" }, run: cmdUserErase },
+	{ name: "user-mail-v"  , usage: "e=EMAIL", brief: "Send validation mail.",
+		reqs: []string{ "email" }, run: cmdUserMailV },
+	{ name: "user-validate", usage: "e=EMAIL ecode=ECODE", brief: "Validate.",
+		reqs: []string{ "email", "ecode" }, run: cmdUserValidate },
+	{},
+	{ name: "invoice-list"    , usage: "e=EMAIL [status=S] [since=T]", brief: "List invoices.",
+		reqs: []string{ "email" }, keys: []string{ "status", "since" }, output: true, run: cmdInvoiceList },
+	{ name: "invoice-info"    , usage: "ID...", brief: "Invoice information.", output: true, run: cmdInvoiceInfo },
+	{ name: "invoice-get-json", usage: "ID...", brief: "Print invoice JSON.", run: cmdInvoiceInfo },
+	{ name: "invoice-pdf"     , usage: "ID [out=FILE]", brief: "Download the PDF.",
+		keys: []string{ "out" }, run: cmdInvoicePDF },
+	{ name: "invoice-send"    , usage: "ID...", brief: "Mail the invoice.", run: cmdInvoiceSend },
+	{ name: "invoice-export"  , usage: "format=facturae [dir=DIR] [check=n] ID...|e=EMAIL [since=T]",
+		brief: "Export paid invoices to Facturae 3.2.2 XML files.",
+		doc:
+`Documents are checked with xmllint against a bundled subset of the
+schema that covers the structure written, this is not a validation
+against the official Facturae XSD. Set USTRIPE_FACTURAE_XSD to the
+official schema to validate, or check=n to skip the check.`,
+		reqs: []string{ "format" }, keys: []string{ "dir", "check", "email", "since" },
+		run: cmdInvoiceExport },
+	{},
+	{ name: "refund"     , usage: "e=EMAIL [invoice=ID|charge=ID] [amount=N] [reason=R] [confirm=y]",
+		brief: "Refund a charge or invoice (last paid invoice by default).",
+		doc: "In RELEASE_MODE confirm=y is required.",
+		reqs: []string{ "email" }, keys: []string{ "invoice", "charge", "amount", "reason" },
+		flags: []string{ "confirm" }, run: cmdRefund },
+	{ name: "credit-note", usage: "e=EMAIL [invoice=ID] [amount=N] [reason=R] [memo=M] [refund=y] [confirm=y]",
+		brief: "Issue a credit note (refunded or to the customer balance).",
+		doc: "In RELEASE_MODE confirm=y is required.",
+		reqs: []string{ "email" }, keys: []string{ "invoice", "amount", "reason", "memo" },
+		flags: []string{ "refund", "confirm" }, run: cmdRefund },
+	{},
+	{ name: "metrics", usage: "[since=T] [view=mrr|months|cohorts]", brief: "MRR/ARR, MRR movements, churn and cohorts.",
+		doc:
+`Without view nor output format all the tables are printed. Otherwise
+the table selected with view, "months" by default, is written in the
+output format. Amounts are in the currency's minor unit.`,
+		keys: []string{ "since", "view" }, output: true, run: cmdMetrics },
+	{},
+	{ name: "dunning-run"   , usage: "[poll=y]", brief: "Send due payment reminders and downgrade.",
+		doc: "Subscriptions past the grace period are downgraded.",
+		flags: []string{ "poll" }, run: cmdDunningRun },
+	{ name: "dunning-status", usage: "[e=EMAIL]", brief: "Show failed payment cases.",
+		keys: []string{ "email" }, output: true, run: cmdDunningStatus },
+	{},
+	{ name: "export-ledger", usage: "from=T to=T [format=csv|journal] [group=month|quarter|all]",
+		brief: "Accounting export of the balance transactions.",
+		reqs: []string{ "from", "to" }, keys: []string{ "format", "group" }, run: cmdExportLedger },
+	{},
+	{ name: "team-list"  , usage: "owner=EMAIL", brief: "Seats and members of a team.",
+		reqs: []string{ "owner" }, output: true, run: cmdTeamList },
+	{ name: "team-invite", usage: "owner=EMAIL e=EMAIL", brief: "Invite a member to the team.",
+		doc:
+`The invitation takes a seat, the seats are the quantity of
+USTRIPE_TEAM_PRODUCT in the owner's subscriptions. The member joins
+when accepting it with team-accept.`,
+		reqs: []string{ "owner", "email" }, run: cmdTeamInvite },
+	{ name: "team-accept", usage: "e=EMAIL ecode=ECODE [p=PASSWORD]", brief: "Accept a team invitation.",
+		doc: "New members must choose a password.",
+		reqs: []string{ "email", "ecode" }, keys: []string{ "password" }, run: cmdTeamAccept },
+	{ name: "team-remove", usage: "owner=EMAIL e=EMAIL", brief: "Remove a member from the team.",
+		reqs: []string{ "owner", "email" }, run: cmdTeamRemove },
+	{},
+	{ name: "subscribe", usage: "us=URL uc=URL c=CUSTOMER|e=EMAIL @PROD=NUM[,TAX]...", brief: "Create session.",
+		doc:
+`  success_url | us = SUCCESS-URL
+  cancel_url  | uc = CANCEL-URL
+  customer    | c  = CUSTOMER or
+  email       | e  = EMAIL
+  reference   | r  = REFERENCE
+  interval    | i  = month|year|3 month|...
+  browse           = y|n
+  @PROD=NUM[,TAX]    (PROD: product, price or lookup key)`,
+		keys: []string{ "success_url", "cancel_url", "customer", "email", "reference", "interval" },
+		flags: []string{ "browse" }, meta: true, output: true, run: cmdSubscribe },
+	{},
+	{ name: "usage-report" , usage: "e=EMAIL prod=PROD q=NUM [ts=T] [action=A] [buffer=y]",
+		brief: "Report metered usage (A: increment|set).",
+		reqs: []string{ "email", "product", "quantity" }, keys: []string{ "timestamp", "action" },
+		flags: []string{ "buffer" }, run: cmdUsageReport },
+	{ name: "usage-flush"  , usage: "[every=DURATION]", brief: "Send buffered usage.",
+		keys: []string{ "every" }, run: cmdUsageFlush },
+	{ name: "usage-summary", usage: "e=EMAIL prod=PROD", brief: "Usage per billing period.",
+		reqs: []string{ "email", "product" }, output: true, run: cmdUsageSummary },
+	{},
+	{ name: "webhook"      , usage: "[addr=ADDR]", brief: "Run HOOKS_DIR/EVENT_TYPE for each event.",
+		keys: []string{ "addr" }, run: cmdWebhook },
+	{ name: "webhook-retry", brief: "Redeliver events in HOOKS_FAILED_DIR.", run: cmdWebhookRetry },
+	{},
+	{ name: "events-poll"  , usage: "[since=T] [every=DURATION]", brief: "Fetch new events from Stripe.",
+		keys: []string{ "since", "every" }, run: cmdEventsPoll },
+	{ name: "events-list"  , usage: "[since=T] [until=T] [type=T] [c=C]", brief: "List stored events.",
+		keys: []string{ "since", "until", "type", "customer" }, output: true, run: cmdEventsList },
+	{ name: "events-show"  , usage: "ID...", brief: "Print stored event JSON.", run: cmdEventsShow },
+	{ name: "events-replay", usage: "[since=T] [until=T] [type=T] [c=C]", brief: "Dispatch stored events again.",
+		keys: []string{ "since", "until", "type", "customer" }, run: cmdEventsReplay },
+	{},
+	{ name: "mirror-sync"  , brief: "Download customers, products and subscriptions.", run: cmdMirrorSync },
+	{ name: "mirror-status", brief: "Print mirror information.", run: cmdMirrorStatus },
+}
+
+func cmdHash1(c *command, kvs map[string]string, args []string) (err error) {
+	hash, err := ustripe.PasswordHash(kvs["password"])
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", hash)
+	return
+}
+
+func cmdLogin(c *command, kvs map[string]string, args []string) (err error) {
+	user, err := ustripe.UserLogin(kvs["email"], kvs["password"])
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", user.ID)
+	return
+}
+
+func cmdChpass(c *command, kvs map[string]string, args []string) (err error) {
+	_, err = ustripe.UserChangePass(kvs["email"], kvs["password"])
+	return
+}
+
+func cmdWWW(c *command, kvs map[string]string, args []string) (err error) {
+	return mainBrowse(args...)
+}
+
+func cmdTaxList(c *command, kvs map[string]string, args []string) (err error) {
+	taxes, err := ustripe.TaxRates()
+	if err != nil {
+		return
+	}
+	for _, t := range taxes {
+		if out != nil {
+			if err = mainWrite(ustripe.TaxRateRecord(t)); err != nil {
+				return
+			}
+		} else {
+			ustripe.TaxPrint(t)
+		}
+	}
+	return
+}
+
+func cmdProdList(c *command, kvs map[string]string, args []string) (err error) {
+	prods, err := ustripe.Products()
+	if err != nil {
+		return
+	}
+	for _, p := range prods {
+		if out != nil {
+			if err = mainWrite(ustripe.ProductRecord(p)); err != nil {
+				return
+			}
+		} else {
+			ustripe.ProductPrint(p, true, true)
+		}
+	}
+	return
+}
+
+func cmdProdPrice(c *command, kvs map[string]string, args []string) (err error) {
+	for _, prodID := range args {
+		priceID, err := ustripe.Product2Price(prodID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", priceID)
+	}
+	return
+}
+
+func cmdPriceList(c *command, kvs map[string]string, args []string) (err error) {
+	for _, prodID := range args {
+		prod, err := ustripe.ProductFetch(prodID)
+		if err != nil {
+			return err
+		}
+		prices, err := ustripe.PriceList(prodID)
+		if err != nil {
+			return err
+		}
+		for _, p := range prices {
+			isDefault := prod.DefaultPrice != nil && prod.DefaultPrice.ID == p.ID
+			if out != nil {
+				if err = mainWrite(ustripe.PriceRecord(p, isDefault)); err != nil {
+					return err
+				}
+			} else {
+				ustripe.PricePrint(p, isDefault)
+			}
+		}
+	}
+	return
+}
+
+func cmdCatalogApply(c *command, kvs map[string]string, args []string) (err error) {
+	if len(args) != 1 {
+		return usagef("please specify a catalog file")
+	}
+	cat, err := ustripe.CatalogLoad(args[0])
+	if err != nil {
+		return
+	}
+	changes, err := ustripe.CatalogApply(cat, kvs["dry"] == "y")
+	for _, ch := range changes {
+		fmt.Println(ch.String())
+	}
+	return
+}
+
+func cmdCatalogDump(c *command, kvs map[string]string, args []string) (err error) {
+	cat, err := ustripe.CatalogCurrent()
+	if err != nil {
+		return
+	}
+	catJSON, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", catJSON)
+	return
+}
+
+func cmdPromote(c *command, kvs map[string]string, args []string) (err error) {
+	changes, err := ustripe.Promote(kvs["confirm"] != "y")
+	for _, ch := range changes {
+		fmt.Println(ch.String())
+	}
+	return
+}
+
+func cmdUserList(c *command, kvs map[string]string, args []string) (err error) {
+	i := ustripe.UserIter()
+	for i.Next() {
+		if out != nil {
+			if err = mainWrite(ustripe.UserRecord(i.Customer())); err != nil {
+				return
+			}
+		} else {
+			ustripe.UserPrint(i.Customer())
+		}
+	}
+	return i.Err()
+}
+
+func cmdUserGetJSON(c *command, kvs map[string]string, args []string) (err error) {
+	user, found := ustripe.UserSearch(kvs["email"])
+	if !found {
+		return notFoundf("%s: user not found", kvs["email"])
+	}
+	customerJSON, err := ustripe.UserJSON(user)
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", customerJSON)
+	return
+}
+
+func cmdUserGetSubs(c *command, kvs map[string]string, args []string) (err error) {
+	user, found := ustripe.UserSearch(kvs["email"])
+	if !found {
+		return notFoundf("%s: user not found", kvs["email"])
+	}
+	if kvs["status"] == "y" {
+		infos, err := ustripe.UserSubsInfo(user.ID)
+		if err != nil {
+			return err
+		}
+		for _, i := range infos {
+			if !i.Access && kvs["all"] != "y" {
+				continue
+			}
+			if out != nil {
+				if err = mainWrite(ustripe.SubscriptionInfoRecord(i)); err != nil {
+					return err
+				}
+			} else {
+				ustripe.SubscriptionInfoPrintREC(i)
+			}
+		}
+		return nil
+	}
+	prods, err := ustripe.UserProducts(user)
+	if err != nil {
+		return
+	}
+	show  := func (p string) error {
+		if out != nil {
+			return mainWrite(ustripe.Record{ { Key: "product", Value: p }, { Key: "price", Value: prods[p] } })
+		}
+		fmt.Printf("%s\n", p)
+		return nil
+	}
+	if len(args)>0 {
+		for _, p := range args {
+			if _, f := prods[p]; f {
+				return show(p)
+			}
+		}
+		return notFoundf("%s: not subscribed", kvs["email"])
+	}
+	for p := range prods {
+		if err = show(p); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func cmdUserInfo(c *command, kvs map[string]string, args []string) (err error) {
+	user, found := ustripe.UserSearch(kvs["email"])
+	if !found {
+		return notFoundf("%s: user not found", kvs["email"])
+	}
+	if out != nil {
+		return mainWrite(ustripe.UserRecord(user))
+	}
+	ustripe.UserPrintREC(user)
+	return
+}
+
+func cmdUserAdd(c *command, kvs map[string]string, args []string) (err error) {
+	cus, err := ustripe.UserAdd(kvs["email"], kvs["password"], kvs)
+	if err != nil {
+		return
+	}
+	ustripe.UserPrint(cus)
+	return
+}
+
+func cmdUserImport(c *command, kvs map[string]string, args []string) (err error) {
+	var opts    ustripe.UserImportOptions
+	var columns map[string]string = map[string]string{}
+	var recs  []ustripe.UserImportRecord
+
+	if len(args) != 1 {
+		return usagef("user-import: specify one file")
+	}
+	format, found := kvs["format"]
+	if !found {
+		format = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+		if format == "jsonl" {
+			format = "json"
+		}
+	}
+	for _, m := range strings.Split(kvs["map"], ",") {
+		if col, key, found := strings.Cut(m, ":"); found {
+			columns[col] = key
+		} else if len(m)>0 {
+			return usagef("user-import: invalid map: %s", m)
+		}
+	}
+	opts.Update = kvs["update"] == "y"
+	opts.Workers, opts.Rate = 4, 20
+	if w, found := kvs["workers"]; found {
+		if opts.Workers, err = strconv.Atoi(w); err != nil || opts.Workers < 1 {
+			return usagef("user-import: invalid workers: %s", w)
+		}
+	}
+	if r, found := kvs["rate"]; found {
+		if opts.Rate, err = strconv.ParseFloat(r, 64); err != nil || opts.Rate < 0 {
+			return usagef("user-import: invalid rate: %s", r)
+		}
+	}
+	if opts.ProgressFile, found = kvs["progress"]; !found {
+		opts.ProgressFile = args[0] + ".progress"
+	}
+
+	fp, err := os.Open(args[0])
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	if recs, err = ustripe.UserImportRead(fp, format, columns); err != nil {
+		return usagef("%s: %s", args[0], err)
+	}
+
+	return ustripe.UserImport(recs, opts, mainImportReport)
+}
+
+func cmdUserExport(c *command, kvs map[string]string, args []string) (err error) {
+	var w  io.Writer = os.Stdout
+	var fp *os.File
+	var n   int
+	if len(args)>1 {
+		return usagef("user-export: only one file accepted")
+	} else if len(args)==1 && args[0] != "-" {
+		fp, err = os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return
+		}
+		defer func() {
+			if cerr := fp.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		w = fp
+	}
+	n, err = ustripe.UserExport(w, ustripe.UserExportOptions{
+		Sanitize: kvs["sanitize"] == "y",
+		Encrypt:  kvs["encrypt"] == "y",
+	})
+	log.Printf("%d users exported", n)
+	return
+}
+
+func cmdUserRestore(c *command, kvs map[string]string, args []string) (err error) {
+	var r io.Reader = os.Stdin
+	if len(args)>1 {
+		return usagef("user-restore: only one file accepted")
+	} else if len(args)==1 && args[0] != "-" {
+		fp, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		r = fp
+	}
+	return ustripe.UserRestore(r, ustripe.UserRestoreOptions{
+		Subscriptions: kvs["subs"] == "y",
+		Dry:           kvs["dry"] == "y",
+	}, mainImportReport)
+}
+
+// mainImportReport prints the result of importing/restoring a user,
+// as a record when an output format is given.
+func mainImportReport(r ustripe.UserImportResult) {
+	if out != nil {
+		if err := mainWrite(ustripe.Record{
+			{ Key: "line"  , Value: r.Line   },
+			{ Key: "email" , Value: r.Email  },
+			{ Key: "status", Value: r.Status },
+			{ Key: "error" , Value: r.Error  },
+		}); err != nil {
+			log.Print(err)
+		}
+	} else {
+		fmt.Printf("%d\t%s\t%s\t%s\n", r.Line, r.Email, r.Status, r.Error)
+	}
+}
+
+func cmdUserDel(c *command, kvs map[string]string, args []string) (err error) {
+	for _, email := range args {
+		_, derr := ustripe.UserDel(email)
+		if derr != nil {
+			log.Print(derr)
+			err = derr
+		}
+	}
+	return
+}
+
+func cmdUserEdit(c *command, kvs map[string]string, args []string) (err error) {
+	cus, err := ustripe.UserEdit(kvs["email"], kvs)
+	if err != nil {
+		return
+	}
+	ustripe.UserPrintREC(cus)
+	return
+}
+
+func cmdUserDataExport(c *command, kvs map[string]string, args []string) (err error) {
+	d, err := ustripe.UserDataExport(kvs["email"])
+	if err != nil {
+		return
+	}
+	dataJSON, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return
+	}
+	if file, found := kvs["out"]; found {
+		return os.WriteFile(file, append(dataJSON, '\n'), 0600)
+	}
+	fmt.Printf("%s\n", dataJSON)
+	return
+}
+
+func cmdUserErase(c *command, kvs map[string]string, args []string) (err error) {
+	ustripe.UserEraseHook = ustripe.HookUserErased
+	r, err := ustripe.UserErase(kvs["email"], ustripe.UserEraseOptions{
+		Anonymize: kvs["anonymize"] == "y",
+		Dry:       kvs["confirm"] != "y",
+	})
+	if r != nil {
+		ustripe.ErasureReceiptPrintREC(r)
+	}
+	return
+}
+
+func cmdUserMailV(c *command, kvs map[string]string, args []string) (err error) {
+	id, found := ustripe.UserID(kvs["email"])
+	if !found {
+		return notFoundf("%s: user not found", kvs["email"])
+	}
+	return ustripe.UserSendValidationMail(id)
+}
+
+func cmdUserValidate(c *command, kvs map[string]string, args []string) (err error) {
+	_, err = ustripe.UserValidate(kvs["email"], kvs["ecode"])
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", kvs["email"])
+	return
+}
+
+func cmdInvoiceList(c *command, kvs map[string]string, args []string) (err error) {
+	since, err := mainTime(kvs, "since", time.Time{})
+	if err != nil {
+		return
+	}
+	invoices, err := ustripe.InvoiceList(kvs["email"], kvs["status"], since)
+	if err != nil {
+		return
+	}
+	for _, inv := range invoices {
+		if out != nil {
+			if err = mainWrite(ustripe.InvoiceRecord(inv)); err != nil {
+				return
+			}
+			continue
+		}
+		ustripe.InvoicePrint(inv)
+	}
+	return
+}
+
+func cmdInvoiceInfo(c *command, kvs map[string]string, args []string) (err error) {
+	for _, id := range args {
+		inv, err := ustripe.InvoiceGet(id)
+		if err != nil {
+			return err
+		}
+		switch {
+		case out != nil:
+			if err = mainWrite(ustripe.InvoiceRecord(inv)); err != nil {
+				return err
+			}
+			continue
+		case c.name == "invoice-info":
+			ustripe.InvoicePrintREC(inv)
+			continue
+		}
+		invoiceJSON, err := ustripe.InvoiceJSON(inv)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", invoiceJSON)
+	}
+	return
+}
+
+func cmdInvoicePDF(c *command, kvs map[string]string, args []string) (err error) {
+	if len(args) != 1 {
+		return usagef("please specify an invoice")
+	}
+	file, found := kvs["out"]
+	if !found {
+		return ustripe.InvoicePDF(args[0], os.Stdout)
+	}
+	fp, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = ustripe.InvoicePDF(args[0], fp)
+	if err1 := fp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(file)
+	}
+	return
+}
+
+func cmdInvoiceExport(c *command, kvs map[string]string, args []string) (err error) {
+	if kvs["format"] != "facturae" {
+		return usagef("unsupported format: %s", kvs["format"])
+	}
+	invoices := []*stripe.Invoice{}
+	for _, id := range args {
+		inv, err := ustripe.InvoiceGet(id)
+		if err != nil {
+			return err
+		}
+		invoices = append(invoices, inv)
+	}
+	if email, found := kvs["email"]; found {
+		since, err := mainTime(kvs, "since", time.Time{})
+		if err != nil {
+			return err
+		}
+		l, err := ustripe.InvoiceList(email, "paid", since)
+		if err != nil {
+			return err
+		}
+		invoices = append(invoices, l...)
+	}
+	dir, found := kvs["dir"]
+	if !found {
+		dir = "."
+	}
+	for _, inv := range invoices {
+		doc, err := ustripe.FacturaeExport(inv)
+		if err != nil {
+			return err
+		}
+		if kvs["check"] != "n" {
+			if err = ustripe.FacturaeCheck(doc); err != nil {
+				return fmt.Errorf("%s: %s", inv.Number, err)
+			}
+		}
+		file := filepath.Join(dir, inv.Number + ".xml")
+		err = os.WriteFile(file, doc, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Println(file)
+	}
+	return
+}
+
+func cmdInvoiceSend(c *command, kvs map[string]string, args []string) (err error) {
+	for _, id := range args {
+		_, err = ustripe.InvoiceSend(id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func cmdRefund(c *command, kvs map[string]string, args []string) (err error) {
+	if ustripe.ReleaseMode && kvs["confirm"] != "y" {
+		return usagef("running in RELEASE_MODE, add confirm=y to proceed")
+	}
+	amount, found := kvs["amount"]
+	if found && len(amount)==0 {
+		return usagef("empty amount")
+	}
+	switch {
+	case c.name == "credit-note":
+		cn, err := ustripe.CreditNoteNew(kvs["email"], kvs["invoice"], amount, kvs["reason"], kvs["memo"], kvs["refund"] == "y")
+		if err != nil {
+			return err
+		}
+		ustripe.CreditNotePrintREC(cn)
+	case len(kvs["charge"])>0:
+		r, err := ustripe.RefundCharge(kvs["email"], kvs["charge"], amount, kvs["reason"])
+		if err != nil {
+			return err
+		}
+		ustripe.RefundPrintREC(r)
+	default:
+		r, err := ustripe.RefundInvoice(kvs["email"], kvs["invoice"], amount, kvs["reason"])
+		if err != nil {
+			return err
+		}
+		ustripe.RefundPrintREC(r)
+	}
+	return
+}
+
+func cmdMetrics(c *command, kvs map[string]string, args []string) (err error) {
+	since, err := mainTime(kvs, "since", time.Now().AddDate(-1, 0, 0))
+	if err != nil {
+		return
+	}
+	m, err := ustripe.MetricsCompute(since)
+	if err != nil {
+		return
+	}
+	view, found := kvs["view"]
+	if out == nil && !found {
+		ustripe.MetricsPrint(m)
+		return
+	} else if !found {
+		view = "months"
+	}
+	recs, err := ustripe.MetricsRecords(m, view)
+	if err != nil {
+		return usagef("metrics: %s", err)
+	}
+	w := out
+	if w == nil {
+		w, _ = ustripe.NewRenderer(os.Stdout, "table", nil)
+		defer func() {
+			if ferr := w.Flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+		}()
+	}
+	for _, rec := range recs {
+		if err = w.Write(rec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func cmdDunningRun(c *command, kvs map[string]string, args []string) (err error) {
+	if kvs["poll"] == "y" {
+		if err = ustripe.DunningPoll(); err != nil {
+			return
+		}
+	}
+	return ustripe.DunningRun(time.Now())
+}
+
+func cmdDunningStatus(c *command, kvs map[string]string, args []string) (err error) {
+	l, err := ustripe.DunningStatus(kvs["email"])
+	if err != nil {
+		return
+	}
+	for _, d := range l {
+		if out != nil {
+			if err = mainWrite(ustripe.DunningRecord(d)); err != nil {
+				return
+			}
+		} else {
+			ustripe.DunningPrintREC(d)
+		}
+	}
+	return
+}
+
+func cmdExportLedger(c *command, kvs map[string]string, args []string) (err error) {
+	from, err := ustripe.ParseTime(kvs["from"])
+	if err != nil {
+		return
+	}
+	to, err := ustripe.ParseTime(kvs["to"])
+	if err != nil {
+		return
+	}
+	txs, err := ustripe.LedgerTransactions(from, to)
+	if err != nil {
+		return
+	}
+	switch kvs["format"] {
+	case "journal":
+		return ustripe.LedgerWriteJournal(os.Stdout, txs)
+	case "csv", "":
+		invs, err := ustripe.LedgerInvoices(from, to)
+		if err != nil {
+			return err
+		}
+		sums, err := ustripe.LedgerSummarize(txs, invs, kvs["group"])
+		if err != nil {
+			return err
+		}
+		return ustripe.LedgerWriteCSV(os.Stdout, sums)
+	default:
+		return usagef("unsupported format: %s", kvs["format"])
+	}
+}
+
+func cmdTeamList(c *command, kvs map[string]string, args []string) (err error) {
+	owner, found := ustripe.UserSearch(kvs["owner"])
+	if !found {
+		return notFoundf("%s: team owner not found", kvs["owner"])
+	}
+	seats, err := ustripe.TeamSeats(owner.ID)
+	if err != nil {
+		return
+	}
+	invites := ustripe.TeamInvites(owner.ID)
+	show    := func (u *stripe.Customer, role string) error {
+		if out != nil {
+			rec := ustripe.Record{ { Key: "role", Value: role }, { Key: "seats", Value: seats } }
+			return mainWrite(append(rec, ustripe.UserRecord(u)...))
+		}
+		ustripe.UserPrint(u)
+		return nil
+	}
+	if out == nil {
+		fmt.Printf("Seats: %v\n", seats)
+		fmt.Printf("Invited: %v\n", len(invites))
+	}
+	if err = show(owner, "owner"); err != nil {
+		return
+	}
+	for _, m := range ustripe.TeamMembers(owner.ID) {
+		if err = show(m, "member"); err != nil {
+			return
+		}
+	}
+	for _, m := range invites {
+		if err = show(m, "invited"); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func cmdTeamInvite(c *command, kvs map[string]string, args []string) (err error) {
+	_, err = ustripe.TeamInvite(kvs["owner"], kvs["email"])
+	return
+}
+
+func cmdTeamAccept(c *command, kvs map[string]string, args []string) (err error) {
+	_, err = ustripe.TeamAccept(kvs["email"], kvs["ecode"], kvs["password"])
+	return
+}
+
+func cmdTeamRemove(c *command, kvs map[string]string, args []string) (err error) {
+	return ustripe.TeamRemove(kvs["owner"], kvs["email"])
+}
+
+func cmdSubscribe(c *command, kvs map[string]string, args []string) (err error) {
+	ses, err := ustripe.SubscriptionNew(kvs)
+	if err != nil {
+		return
+	}
+	switch {
+	case kvs["browse"] == "y":
+		return ustripe.OpenBrowser(ses.URL)
+	case out != nil:
+		return mainWrite(ustripe.CheckoutRecord(ses))
+	default:
+		ustripe.SubscriptionPrintREC(ses)
+	}
+	return
+}
+
+func cmdUsageReport(c *command, kvs map[string]string, args []string) (err error) {
+	quantity, err := strconv.ParseInt(kvs["quantity"], 10, 64)
+	if err != nil {
+		return usagef("invalid quantity: %s", kvs["quantity"])
+	}
+	ts, err := mainTime(kvs, "timestamp", time.Now())
+	if err != nil {
+		return
+	}
+	action, found := kvs["action"]
+	if !found {
+		action = "increment"
+	}
+	if kvs["buffer"] == "y" {
+		return ustripe.UsageBuffer(kvs["email"], kvs["product"], quantity, ts, action)
+	}
+	_, err = ustripe.UsageReport(kvs["email"], kvs["product"], quantity, ts, action)
+	return
+}
+
+func cmdUsageFlush(c *command, kvs map[string]string, args []string) (err error) {
+	every, _ := time.ParseDuration(kvs["every"])
+	for {
+		n, err := ustripe.UsageFlush()
+		if err != nil {
+			if every == 0 {
+				return err
+			}
+			log.Print(err)
+		}
+		log.Printf("usage-flush: %v records sent", n)
+		if every == 0 {
+			return nil
+		}
+		time.Sleep(every)
+	}
+}
+
+func cmdUsageSummary(c *command, kvs map[string]string, args []string) (err error) {
+	sums, err := ustripe.UsageSummary(kvs["email"], kvs["product"])
+	if err != nil {
+		return
+	}
+	for _, s := range sums {
+		if out != nil {
+			if err = mainWrite(ustripe.UsageSummaryRecord(s)); err != nil {
+				return
+			}
+		} else {
+			ustripe.UsageSummaryPrint(s)
+		}
+	}
+	return
+}
+
+func cmdWebhook(c *command, kvs map[string]string, args []string) (err error) {
+	addr, found := kvs["addr"]
+	if !found {
+		addr = ":4242"
+	}
+	return ustripe.WebhookServe(addr)
+}
+
+func cmdWebhookRetry(c *command, kvs map[string]string, args []string) (err error) {
+	return ustripe.HookRetryDeadLetters()
+}
+
+func cmdEventsPoll(c *command, kvs map[string]string, args []string) (err error) {
+	every, _ := time.ParseDuration(kvs["every"])
+	since, err := mainTime(kvs, "since", time.Time{})
+	if err != nil {
+		return
+	}
+	for {
+		n, err := ustripe.EventPoll(since)
+		if every == 0 {
+			if err == nil {
+				log.Printf("events-poll: %v new events", n)
+			}
+			return err
+		}
+		if err != nil {
+			log.Printf("events-poll: %s", err)
+		} else {
+			log.Printf("events-poll: %v new events", n)
+		}
+		time.Sleep(every)
+	}
+}
+
+func cmdEventsList(c *command, kvs map[string]string, args []string) (err error) {
+	f, err := mainEventFilter(kvs)
+	if err != nil {
+		return
+	}
+	events, err := ustripe.EventList(f)
+	if err != nil {
+		return
+	}
+	for _, e := range events {
+		if out != nil {
+			if err = mainWrite(ustripe.EventRecord(e)); err != nil {
+				return
+			}
+		} else {
+			ustripe.EventPrint(e)
+		}
+	}
+	return
+}
+
+func cmdEventsShow(c *command, kvs map[string]string, args []string) (err error) {
+	for _, id := range args {
+		e, err := ustripe.EventGet(id)
+		if err != nil {
+			return err
+		}
+		eventJSON, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", eventJSON)
+	}
+	return
+}
+
+func cmdEventsReplay(c *command, kvs map[string]string, args []string) (err error) {
+	f, err := mainEventFilter(kvs)
+	if err != nil {
+		return
+	}
+	n, failed, err := ustripe.EventReplay(f)
+	if err == nil || failed > 0 {
+		log.Printf("events-replay: %v events dispatched, %v failed", n, failed)
+	}
+	return
+}
+
+func cmdMirrorSync(c *command, kvs map[string]string, args []string) (err error) {
+	if len(ustripe.MirrorFile)==0 {
+		return fmt.Errorf("please set USTRIPE_MIRROR_FILE")
+	}
+	return ustripe.MirrorSync()
+}
+
+func cmdMirrorStatus(c *command, kvs map[string]string, args []string) (err error) {
+	return ustripe.MirrorStatus()
+}
+
+func mainTime(kvs map[string]string, key string, def time.Time) (t time.Time, err error) {
+	s, found := kvs[key]
+	if !found {
+		return def, nil
+	}
+	t, err = ustripe.ParseTime(s)
+	if err != nil {
+		err = usagef("%s: %s", key, err)
+	}
+	return
+}
+
+func mainEventFilter(kvs map[string]string) (f ustripe.EventFilter, err error) {
+	if f.Since, err = mainTime(kvs, "since", time.Time{}); err != nil {
+		return
+	}
+	if f.Until, err = mainTime(kvs, "until", time.Time{}); err != nil {
+		return
+	}
+	f.Type     = kvs["type"]
+	f.Customer = kvs["customer"]
+	return
+}
+
+func mainBrowse(args ...string) (err error) {
+	help := []string{
+		`doc-api       : API documentation.`,
+		`doc-testing   : Fake testing user account doc.`,
+		`dashboard     : Show graphs.`,
+		`customers     : Show customers.`,
+		`api-keys      : Open API key configuration place.`,
+		`api-keys-test : Open API key configuration place (Testing).`,
+		`webhooks      : Open STRIPE webhooks page.`,
+		`cfg-invoice   : Set the company NIF.`,
+		`cfg-branding  : Set the company colors etc.`,
+		`cfg-profile   : Set the language, ...`,
+		`cfg-team      : Team members, etc.`,
+	}
+	if len(args)==0 {
+		fmt.Println(strings.Join(help, "\n"))
+		return nil
+	}
+	cmd := args[0]
+	rel := ustripe.ReleaseMode
+	switch {
+	case cmd == "doc-api"          : ustripe.OpenBrowser("https://stripe.com/docs/api/balance/balance_retrieve?lang=go")
+	case cmd == "doc-testing"      : ustripe.OpenBrowser("https://stripe.com/docs/testing")
+	case cmd == "dashboard"        : ustripe.OpenBrowser("https://dashboard.stripe.com/login")
+	case cmd == "customers" &&  rel: ustripe.OpenBrowser("https://dashboard.stripe.com/customers")
+	case cmd == "customers" && !rel: ustripe.OpenBrowser("https://dashboard.stripe.com/test/customers")
+	case cmd == "api-keys"  &&  rel: ustripe.OpenBrowser("https://dashboard.stripe.com/apikeys")
+	case cmd == "api-keys"  && !rel: ustripe.OpenBrowser("https://dashboard.stripe.com/test/apikeys")
+	case cmd == "webhooks"         : ustripe.OpenBrowser("https://dashboard.stripe.com/webhooks")
+	case cmd == "cfg-invoice"      : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/billing/invoice")
+	case cmd == "cfg-branding"     : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/branding")
+	case cmd == "cfg-profile"      : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/user")
+	case cmd == "cfg-team"         : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/team")
+	default: return usagef("invalid argument: %s", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+	"strings"
+	"encoding/json"
+	"path/filepath"
+	"github.com/harkaitz/ustripe"
+)
+
+// completionCache keeps the dynamic completion values, they are
+// downloaded again after completionTTL. Emails are not cached, they
+// are only completed from a fresh mirror.
+type completionCache struct {
+	Updated  int64    `json:"updated"`
+	Products []string `json:"products"`
+	Taxes    []string `json:"taxes"`
+}
+
+var completionTTL = time.Hour
+
+func init() {
+	commands = append(commands, []*command{
+		{},
+		{ name: "completion", usage: "bash|zsh|fish", brief: "Print the shell completion script.",
+			doc:
+`Load it with 'source <(ustripe completion bash)', product IDs and tax
+rates are cached for an hour in USTRIPE_COMPLETION_CACHE. Emails are
+completed when the mirror is fresh.`,
+			run: cmdCompletion },
+		{ name: "__complete", raw: true, run: cmdComplete },
+	}...)
+}
+
+const completionBash string =
+`_ustripe() {
+    local line="${COMP_LINE:0:$COMP_POINT}" words cur
+    read -r -a words <<< "$line"
+    [[ "$line" == *" " ]] && words+=("")
+    cur="${words[${#words[@]}-1]}"
+    local IFS=$'\n'
+    COMPREPLY=($(ustripe __complete "${words[@]:1}" 2>/dev/null))
+    if [[ "$cur" == *=* && "$COMP_WORDBREAKS" == *=* ]]; then
+        COMPREPLY=("${COMPREPLY[@]#"${cur%%=*}="}")
+    fi
+    if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *= ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _ustripe ustripe
+`
+
+const completionZsh string =
+`#compdef ustripe
+_ustripe() {
+    local -a all keys vals
+    all=("${(@f)$(ustripe __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    keys=(${(M)all:#*=})
+    vals=(${all:#*=})
+    (( ${#keys} )) && compadd -S '' -- "${keys[@]}"
+    (( ${#vals} )) && compadd -- "${vals[@]}"
+}
+compdef _ustripe ustripe
+`
+
+const completionFish string =
+`complete -c ustripe -f -a '(ustripe __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+
+func cmdCompletion(c *command, kvs map[string]string, args []string) (err error) {
+	if len(args) != 1 {
+		return usagef("please specify bash, zsh or fish")
+	}
+	switch args[0] {
+	case "bash": fmt.Print(completionBash)
+	case "zsh":  fmt.Print(completionZsh)
+	case "fish": fmt.Print(completionFish)
+	default:     return usagef("unsupported shell: %s", args[0])
+	}
+	return
+}
+
+// cmdComplete prints the candidates for the last argument, the
+// previous ones are the command line after "ustripe".
+func cmdComplete(c *command, kvs map[string]string, args []string) (err error) {
+	for _, s := range completeWords(args) {
+		fmt.Println(s)
+	}
+	return
+}
+
+func completeWords(words []string) (r []string) {
+	if len(words)==0 {
+		words = []string{ "" }
+	}
+	cur := words[len(words)-1]
+	if len(words)==1 || words[0] == "help" && len(words)==2 {
+		for _, c := range commands {
+			if len(c.name)>0 && c.name[0] != '_' {
+				r = append(r, c.name)
+			}
+		}
+		r = append(r, "help")
+		return completeFilter(r, cur)
+	}
+	c := commandGet(words[0])
+	if c == nil {
+		return nil
+	}
+	given := map[string]bool{}
+	for _, w := range words[1:len(words)-1] {
+		if key, _, found := strings.Cut(strings.TrimLeft(w, "-"), "="); found {
+			if a, found := aliases[key]; found {
+				key = a
+			}
+			given[key] = true
+		}
+	}
+	switch {
+	case completeOption(c, words[len(words)-2]) != "":
+		r = completeValues(completeOption(c, words[len(words)-2]), c)
+	case strings.Contains(cur, "="):
+		key, val, _ := strings.Cut(cur, "=")
+		prefix := key + "="
+		key = strings.TrimLeft(key, "-")
+		if a, found := aliases[key]; found {
+			key = a
+		}
+		var vals []string
+		if strings.HasPrefix(key, "@") {
+			/* @PROD=NUM[,TAX] */
+			num, _, found := strings.Cut(val, ",")
+			if !found {
+				return nil
+			}
+			prefix += num + ","
+			vals = completeValues("tax_rate", c)
+		} else {
+			vals = completeValues(key, c)
+		}
+		for _, v := range vals {
+			r = append(r, prefix + v)
+		}
+	case strings.HasPrefix(cur, "--"):
+		for _, l := range [][]string{ c.reqs, c.keys } {
+			for _, key := range l {
+				r = append(r, "--" + key + "=")
+			}
+		}
+		for _, key := range c.flags {
+			r = append(r, "--" + key)
+		}
+		r = append(r, "--output=", "--fields=", "--help")
+	case strings.HasPrefix(cur, "@") && c.meta:
+		for _, p := range completionLoad().Products {
+			r = append(r, "@" + p + "=")
+		}
+	default:
+		for _, l := range [][]string{ c.reqs, c.keys, c.flags } {
+			for _, key := range l {
+				if !given[key] {
+					r = append(r, key + "=")
+				}
+			}
+		}
+		r = append(r, completeValues("", c)...)
+	}
+	return completeFilter(r, cur)
+}
+
+// completeOption returns the key when word is an option that takes
+// the next word as value, "-e" or "--email".
+func completeOption(c *command, word string) (key string) {
+	switch {
+	case strings.Contains(word, "=") || !strings.HasPrefix(word, "-") || word == "--":
+		return ""
+	case strings.HasPrefix(word, "--"):
+		key = word[2:]
+	case len(word) == 2:
+		key = word[1:]
+		if a, found := aliases[key]; found {
+			key = a
+		}
+	default:
+		return ""
+	}
+	switch key {
+	case "o", "output": return "output"
+	case "f", "fields": return ""
+	}
+	for _, l := range [][]string{ c.reqs, c.keys } {
+		for _, k := range l {
+			if k == key {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+func completeValues(key string, c *command) (r []string) {
+	for _, f := range c.flags {
+		if f == key {
+			return []string{ "y", "n" }
+		}
+	}
+	if len(key)==0 {
+		key = c.complete
+	}
+	switch key {
+	case "email", "owner": return ustripe.MirrorEmails()
+	case "product":        return completionLoad().Products
+	case "tax_rate":       return completionLoad().Taxes
+	case "interval":       return []string{ "day", "week", "month", "year" }
+	case "output":         return ustripe.OutputFormats
+	}
+	return nil
+}
+
+func completeFilter(l []string, prefix string) (r []string) {
+	for _, s := range l {
+		if strings.HasPrefix(s, prefix) {
+			r = append(r, s)
+		}
+	}
+	sort.Strings(r)
+	return
+}
+
+// completionLoad returns the cached values, downloading them again
+// when stale. Errors are ignored, completion must not print them.
+func completionLoad() (cc *completionCache) {
+	cc = &completionCache{}
+	file := completionFile()
+	if data, err := os.ReadFile(file); err == nil {
+		if json.Unmarshal(data, cc) == nil && time.Since(time.Unix(cc.Updated, 0)) < completionTTL {
+			return
+		}
+	}
+	cc = &completionCache{ Updated: time.Now().Unix() }
+	prods, perr := ustripe.Products()
+	for _, p := range prods {
+		cc.Products = append(cc.Products, p.ID)
+	}
+	taxes, terr := ustripe.TaxRates()
+	for _, t := range taxes {
+		cc.Taxes = append(cc.Taxes, t.ID)
+	}
+	/* Partial results are used but not cached. */
+	if perr != nil || terr != nil {
+		return
+	}
+	if data, err := json.Marshal(cc); err == nil {
+		if os.MkdirAll(filepath.Dir(file), 0700) == nil {
+			os.WriteFile(file, data, 0600)
+		}
+	}
+	return
+}
+
+func completionFile() string {
+	if s := os.Getenv("USTRIPE_COMPLETION_CACHE"); len(s)>0 {
+		return s
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	mode := "test"
+	if ustripe.ReleaseMode {
+		mode = "live"
+	}
+	return filepath.Join(dir, "ustripe", "completion-" + mode + ".json")
+}
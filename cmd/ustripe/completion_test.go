@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"reflect"
+	"testing"
+	"path/filepath"
+	"github.com/harkaitz/ustripe"
+)
+
+func TestCompleteWords(t *testing.T) {
+	defer func(f string) { ustripe.MirrorFile = f }(ustripe.MirrorFile)
+	ustripe.MirrorFile = filepath.Join(t.TempDir(), "mirror.json")
+	mirror := fmt.Sprintf(`{"synced_at":%d,"customers":{"cus_1":{"id":"cus_1","email":"a@b.c"}}}`, time.Now().Unix())
+	if err := os.WriteFile(ustripe.MirrorFile, []byte(mirror), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		words []string
+		want  []string
+	}{
+		{ []string{ "user-erase", "--confirm=" }   , []string{ "--confirm=n", "--confirm=y" } },
+		{ []string{ "user-erase", "--email=" }     , []string{ "--email=a@b.c" } },
+		{ []string{ "user-erase", "e=" }           , []string{ "e=a@b.c" } },
+		{ []string{ "user-erase", "-e", "" }       , []string{ "a@b.c" } },
+		{ []string{ "user-erase", "--email", "a" } , []string{ "a@b.c" } },
+		{ []string{ "user-erase", "-o", "js" }     , []string{ "json", "jsonl" } },
+		{ []string{ "user-erase", "--conf" }       , []string{ "--confirm" } },
+	} {
+		if r := completeWords(c.words); !reflect.DeepEqual(r, c.want) {
+			t.Errorf("%q: got %q, want %q", c.words, r, c.want)
+		}
+	}
+}
@@ -4,276 +4,394 @@ import (
 	"fmt"
 	"os"
 	"log"
+	"errors"
 	"strings"
 	"github.com/harkaitz/ustripe"
+	"github.com/pborman/getopt/v2"
 )
 
 const help string =
-`Usage: ustripe [OPTIONS...]
+`Usage: ustripe [-o FORMAT] [-f FIELDS] COMMAND [OPTIONS...] [KEY=VALUE...]
+       ustripe help COMMAND
 
 Simple mechanism to handle subscriptions with Stripe.
 
+Global options (also accepted after the command):
+
+    -o, --output=rec|json|jsonl|csv|table|tsv : Output format of user-list,
+                           user-info, user-get-subs, user-import, user-restore,
+                           prod-list, price-list, tax-list, subscribe,
+                           invoice-list, invoice-info, dunning-status,
+                           team-list, usage-summary, events-list, metrics and
+                           batch, other commands reject it.
+    -f, --fields=FIELD[,...] : Output only these fields.
+    -h, --help               : Print help, of the command if given.
+
+Options of the commands can be given as "--key=VALUE", "--key VALUE" or
+"key=VALUE", most common keys have a short alias ("-e EMAIL", "e=EMAIL").
+Boolean options are set with "--key" or "key=y".
+
+Exit status: 0 success, 1 error, 2 usage error, 3 not found.
+
 Environment variables:
 
-    RELEASE_MODE, STRIPE[_TEST]_SECRET_KEY, SENDMAIL_COMMAND
+    RELEASE_MODE, STRIPE[_TEST]_SECRET_KEY, SENDMAIL_COMMAND,
+    STRIPE[_TEST]_WEBHOOK_SECRET, USTRIPE_HOOKS_DIR,
+    USTRIPE_HOOKS_FAILED_DIR, USTRIPE_HOOKS_TIMEOUT, USTRIPE_EVENTS_FILE,
+    USTRIPE_MIRROR_FILE, USTRIPE_MIRROR_MAX_AGE, USTRIPE_CACHE_TTL,
+    USTRIPE_USAGE_FILE, USTRIPE_FACTURAE_XSD, FACTURAE_SELLER_{TAXID,NAME,
+    ADDRESS,POSTCODE,TOWN,PROVINCE}, USTRIPE_DUNNING_FILE,
+    USTRIPE_DUNNING_SCHEDULE (e.g. "0,72h,168h"), USTRIPE_DUNNING_GRACE,
+    USTRIPE_ACCESS_STATUSES (default "active,trialing"), USTRIPE_PAST_DUE_GRACE,
+    USTRIPE_COMPLETION_CACHE, USTRIPE_BACKUP_PASSWORD, USTRIPE_ERASURE_KEY,
+    USTRIPE_ERASURE_FILE, USTRIPE_TEAM_PRODUCT
 
 Subcommands:
 
-    hash1  p=PASSWORD     : Calculate hash of the password.
-    login  e=EMAIL p=PASS : Check password.
-    chpass e=EMAIL p=PASS : Change password.
-    www    ...            : Open the stripe dashboard and resources.
-
-    tax-list  : List defined taxes.
-
-    prod-list              : List defined products.
-    prod-price PRODUCTS... : Convert from product to price.
-
-    user-list                            : List users.
-    user-get-json  e=EMAIL               : Print user JSON.
-    user-get-subs  e=EMAIL [PROD1[,...]] : User's subscriptions.
-    user-info      e=EMAIL               : User information.
-    
-    user-add      e=EMAIL p=PASS l=LANG  : Add new user.
-    user-del      EMAIL...               : Delete user.
-    user-edit     e=EMAIL PARAMS...      : Edit user.
-    user-mail-v   e=EMAIL                : Send validation mail.
-    user-validate e=EMAIL ecode=ECODE    : Validate.
-
-    subscribe ... : Create session (us,uc,c|e required)
-
-      url_success | us   = SUCCESS-URL
-      url_cancel  | uc   = CANCEL-URL
-      customer    | c    = CUSTOMER or
-      email       | e    = EMAIL
-      reference   | r    = REFERENCE
-      browse             = y|n
-      @PROD=NUM[,TAX]`
+`
 const copyrightLine string =
 `Bug reports, feature requests to gemini|https://harkadev.com/oss
 Copyright (c) 2022 Harkaitz Agirre, harkaitz.aguirre@gmail.com`
 
+// command is a subcommand of ustripe. The keys it accepts are reqs,
+// keys and flags (keys that take "y" when given without value), meta
+// commands also accept "@KEY=VALUE" arguments. Positional arguments
+// are completed as the complete key. Raw commands receive the arguments
+// unparsed. Commands starting with "_" are hidden.
+type command struct {
+	name     string
+	usage    string
+	brief    string
+	doc      string
+	reqs     []string
+	keys     []string
+	flags    []string
+	meta     bool
+	complete string
+	output   bool
+	raw      bool
+	run      func (c *command, kvs map[string]string, args []string) error
+}
+
+// exitError is an error with an exit status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+// aliases maps the short "key=" forms to the key.
+var aliases = map[string]string{
+	"e"          : "email",
+	"p"          : "password",
+	"pass"       : "password",
+	"l"          : "language",
+	"lang"       : "language",
+	"us"         : "success_url",
+	"url_success": "success_url",
+	"uc"         : "cancel_url",
+	"url_cancel" : "cancel_url",
+	"c"          : "customer",
+	"t"          : "tax_rate",
+	"r"          : "reference",
+	"v"          : "verified",
+	"i"          : "interval",
+	"prod"       : "product",
+	"q"          : "quantity",
+	"ts"         : "timestamp",
+}
+
+var out          *ustripe.Renderer
+var outputFormat  string
+var outputFields  string
+var helpFlag      bool
+
 func main() {
+	var cmd *command
+	var err  error
 
-	if len(os.Args)==1 || os.Args[1]=="-h" || os.Args[1]=="--help" || os.Args[1]=="help" {
-		fmt.Println(help + "\n\n" + copyrightLine)
+	set := getopt.New()
+	save := mainGlobalOptions(set)
+	if err = set.Getopt(os.Args, nil); err != nil {
+		mainExit(usagef("%s", err))
+	}
+	save()
+	args := set.Args()
+	if len(args)>0 && args[0] == "help" {
+		args, helpFlag = args[1:], true
+	}
+	if len(args)==0 {
+		mainHelp(os.Stdout)
+		return
+	}
+	if cmd = commandGet(args[0]); cmd == nil {
+		mainExit(usagef("invalid command: %s", args[0]))
+	}
+	if helpFlag {
+		cmd.help(os.Stdout)
 		return
 	}
+	mainExit(cmd.exec(args[1:]))
+}
 
-	cmd  := os.Args[1]
-	argv := os.Args[2:]
-	
-	switch cmd {
-	case "hash1":
-		kvs, _ :=mainParams(argv, "password")
-		hash, err := ustripe.PasswordHash(kvs["password"])
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Printf("%s\n", hash)
-	case "login":
-		kvs, _ :=mainParams(argv, "email", "password")
-		user, err := ustripe.UserLogin(kvs["email"], kvs["password"])
-		if err != nil {
-			log.Fatal(err)
+// exec parses the arguments and runs the command.
+func (c *command) exec(argv []string) (err error) {
+	if c.raw {
+		return c.run(c, nil, argv)
+	}
+	kvs, args, err := c.parse(argv)
+	if err != nil {
+		return
+	}
+	if helpFlag {
+		c.help(os.Stdout)
+		return nil
+	}
+	if err = mainOutput(); err != nil {
+		return
+	}
+	if out != nil && !c.output {
+		return usagef("%s: output format and fields not supported", c.name)
+	}
+	err = c.run(c, kvs, args)
+	if out != nil {
+		if ferr := out.Flush(); ferr != nil && err == nil {
+			err = ferr
 		}
-		fmt.Printf("%s\n", user.ID)
-	case "chpass":
-		kvs, _ :=mainParams(argv, "email", "password")
-		_, err := ustripe.UserChangePass(kvs["email"], kvs["password"])
-		if err != nil {
-			log.Fatal(err)
+	}
+	return
+}
+
+// parse reads "--key VALUE", "--key=VALUE", "-k VALUE" and "key=VALUE"
+// options in any order, and checks the keys are known and the required
+// ones given.
+func (c *command) parse(argv []string) (kvs map[string]string, args []string, err error) {
+	kvs = map[string]string{}
+	set, vals, flags, save := c.options()
+	rest := argv
+	for len(rest)>0 {
+		if err = set.Getopt(append([]string{ c.name }, rest...), nil); err != nil {
+			return nil, nil, usagef("%s: %s", c.name, err)
 		}
-	case "www":
-		err := mainBrowse(argv...)
-		if err != nil {
-			log.Fatal(err)
+		rest = set.Args()
+		if len(rest)==0 {
+			break
 		}
-	case "tax-list":
-		for i := ustripe.TaxList(); i.Next(); {
-			ustripe.TaxPrint(i.TaxRate())
+		if set.State() == getopt.DashDash {
+			args = append(args, rest...)
+			break
 		}
-	case "prod-list":
-		for i := ustripe.ProductList(); i.Next(); {
-			p := i.Product()
-			ustripe.ProductPrint(p, true, true)
+		arg := rest[0]
+		rest = rest[1:]
+		key, val, isKV := strings.Cut(arg, "=")
+		if !isKV || len(key)==0 {
+			args = append(args, arg)
+			continue
 		}
-	case "prod-price":
-		for _, prodID := range argv {
-			priceID, err := ustripe.Product2Price(prodID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			fmt.Printf("%s\n", priceID)
+		if a, found := aliases[key]; found {
+			key = a
 		}
-	case "user-list":
-		for i := ustripe.UserIter(); i.Next(); {
-			c := i.Customer()
-			ustripe.UserPrint(c)
+		if !c.accepts(key) {
+			return nil, nil, usagef("%s: unknown key: %s", c.name, key)
 		}
-	case "user-get-json":
-		kvs, _ := mainParams(argv, "email")
-		user, found := ustripe.UserSearch(kvs["email"])
-		if found {
-			customerJSON, err := ustripe.UserJSON(user)
-			if err != nil {
-				log.Fatal(err)
-			}
-			fmt.Printf("%s\n", customerJSON)
+		kvs[key] = val
+	}
+	save()
+	for key, val := range vals {
+		if set.IsSet(key) {
+			kvs[key] = *val
 		}
-	case "user-get-subs":
-		kvs, args := mainParams(argv, "email")
-		user, found := ustripe.UserSearch(kvs["email"])
-		if found {
-			prods := ustripe.Subscription2Product(ustripe.UserPaidSubs(user.ID))
-			if len(args)>0 {
-				for _, p := range args {
-					if _, f := prods[p]; f {
-						fmt.Printf("%s\n", p)
-						break
-					}
-				}
+	}
+	for key, val := range flags {
+		if set.IsSet(key) {
+			if *val {
+				kvs[key] = "y"
 			} else {
-				for p := range prods {
-					fmt.Printf("%s\n", p)
-				}
+				kvs[key] = "n"
 			}
 		}
-	case "user-info":
-		kvs, _ := mainParams(argv, "email")
-		user, found := ustripe.UserSearch(kvs["email"])
-		if found {
-			ustripe.UserPrintREC(user)
+	}
+	if helpFlag {
+		return
+	}
+	if max := c.maxArgs(); max >= 0 && len(args) > max {
+		return nil, nil, usagef("%s: unexpected argument: %s", c.name, args[max])
+	}
+	missing := []string{}
+	for _, req := range c.reqs {
+		if _, found := kvs[req]; !found {
+			missing = append(missing, req)
+		}
+	}
+	if len(missing)>0 {
+		return nil, nil, usagef("%s: missing parameters: %s", c.name, strings.Join(missing, " "))
+	}
+	return
+}
+
+// maxArgs returns the number of positional arguments declared in the
+// usage, -1 when unlimited ("ID...").
+func (c *command) maxArgs() (n int) {
+	for _, w := range strings.Fields(c.usage) {
+		w = strings.Trim(w, "[]")
+		if strings.HasPrefix(w, "-") {
+			continue
 		}
-	case "user-add":
-		kvs, _ := mainParams(argv, "email", "password")
-		cus, err := ustripe.UserAdd(kvs["email"], kvs["password"], kvs)
-		if err != nil {
-			log.Fatal(err)
+		for _, alt := range strings.Split(w, "|") {
+			if strings.Contains(alt, "=") {
+				continue
+			}
+			if strings.HasSuffix(alt, "...") {
+				return -1
+			}
+			n++
+			break
 		}
-		ustripe.UserPrint(cus)
-	case "user-del":
-		for _, email := range argv {
-			_, err := ustripe.UserDel(email)
-			if err != nil {
-				log.Print(err)
+	}
+	return
+}
+
+func (c *command) accepts(key string) bool {
+	if c.meta && strings.HasPrefix(key, "@") {
+		return true
+	}
+	for _, l := range [][]string{ c.reqs, c.keys, c.flags } {
+		for _, k := range l {
+			if k == key {
+				return true
 			}
 		}
-	case "user-edit":
-		kvs, _ := mainParams(argv, "email")
-		cus, err := ustripe.UserEdit(kvs["email"], kvs)
-		if err != nil {
-			log.Fatal(err)
+	}
+	return false
+}
+
+func (c *command) options() (set *getopt.Set, vals map[string]*string, flags map[string]*bool, save func ()) {
+	set   = getopt.New()
+	vals  = map[string]*string{}
+	flags = map[string]*bool{}
+	set.SetProgram("ustripe " + c.name)
+	save  = mainGlobalOptions(set)
+	for _, l := range [][]string{ c.reqs, c.keys } {
+		for _, key := range l {
+			vals[key] = set.StringLong(key, commandShort(key), "", "", strings.ToUpper(key))
 		}
-		ustripe.UserPrintREC(cus)
-	case "user-mail-v":
-		kvs, _ :=mainParams(argv, "email")
-		id, found := ustripe.UserID(kvs["email"])
-		if !found {
-			log.Fatal("Customer not found.")
+	}
+	for _, key := range c.flags {
+		flags[key] = set.BoolLong(key, commandShort(key), "")
+	}
+	return
+}
+
+func (c *command) help(w *os.File) {
+	set, _, _, _ := c.options()
+	fmt.Fprintf(w, "Usage: ustripe %s %s\n\n%s\n", c.name, c.usage, c.brief)
+	if len(c.doc)>0 {
+		fmt.Fprintf(w, "\n%s\n", c.doc)
+	}
+	fmt.Fprintf(w, "\nOptions:\n\n")
+	set.PrintOptions(w)
+}
+
+func commandGet(name string) *command {
+	for _, c := range commands {
+		if len(c.name)>0 && c.name == name {
+			return c
 		}
-		err := ustripe.UserSendValidationMail(id)
-		if err != nil {
-			log.Fatal(err)
+	}
+	return nil
+}
+
+func commandShort(key string) (short rune) {
+	for a, k := range aliases {
+		if k == key && len(a) == 1 {
+			return rune(a[0])
 		}
-	case "user-validate":
-		kvs, _ :=mainParams(argv, "email", "ecode")
-		_, err := ustripe.UserValidate(kvs["email"], kvs["ecode"])
-		if err != nil {
-			log.Fatal(err)
+	}
+	return 0
+}
+
+// mainGlobalOptions adds -o, -f and -h to the set, the returned
+// function saves the given ones in outputFormat, outputFields and
+// helpFlag.
+func mainGlobalOptions(set *getopt.Set) (save func ()) {
+	format := set.StringLong("output", 'o', "", "rec|json|jsonl|csv|table|tsv", "FORMAT")
+	fields := set.StringLong("fields", 'f', "", "fields to output", "FIELD,...")
+	help   := set.BoolLong("help", 'h', "print help")
+	return func () {
+		if set.IsSet("output") {
+			outputFormat = *format
 		}
-		fmt.Printf("%s\n", kvs["email"])
-	case "subscribe":
-		kvs, _ :=mainParams(argv)
-		ses, err := ustripe.SubscriptionNew(kvs)
-		if err != nil {
-			log.Fatal(err)
+		if set.IsSet("fields") {
+			outputFields = *fields
 		}
-		switch {
-		case kvs["browse"] == "y":
-			err = ustripe.OpenBrowser(ses.URL)
-			if err != nil {
-				log.Fatal(err)
-			}
-		default:
-			ustripe.SubscriptionPrintREC(ses)
+		if set.IsSet("help") {
+			helpFlag = *help
 		}
-	default:
-		log.Fatal("Invalid argument: " + cmd)
 	}
 }
 
-func mainParams(args []string, reqs ...string) (m map[string]string, r []string) {
-	m = map[string]string{}
-	r = []string{}
-	for _, arg := range args {
-		key, val, splet := strings.Cut(arg, "=")
-		if splet {
-			switch key {
-			case "e"       : key = "email";
-			case "p"       : key = "password";
-			case "pass"    : key = "password";
-			case "l"       : key = "language";
-			case "lang"    : key = "language";
-			case "language": key = "language";
-			case "us"      : key = "url_success";
-			case "uc"      : key = "url_cancel";
-			case "c"       : key = "customer";
-			case "t"       : key = "tax_rate";
-			case "r"       : key = "reference";
-			case "v"       : key = "verified";
-			}
-			m[key] = val
+func mainHelp(w *os.File) {
+	fmt.Fprint(w, help)
+	for _, c := range commands {
+		if len(c.name)==0 {
+			fmt.Fprintf(w, "\n")
+			continue
+		} else if c.name[0] == '_' {
+			continue
+		}
+		line := fmt.Sprintf("    %s %s", c.name, c.usage)
+		if len(line) < 44 {
+			fmt.Fprintf(w, "%-44s : %s\n", line, c.brief)
 		} else {
-			r = append(r, arg)
+			fmt.Fprintf(w, "%s\n%-44s : %s\n", line, "", c.brief)
 		}
 	}
-	missing := ""
-	for _, req := range reqs {
-		if _, f := m[req]; f == false {
-			missing += " " + req
-		}
+	fmt.Fprintf(w, "\n%s\n", copyrightLine)
+}
+
+// mainOutput creates the renderer when an output format or fields
+// were requested.
+func mainOutput() (err error) {
+	out = nil
+	if len(outputFormat)==0 && len(outputFields)==0 {
+		return
 	}
-	if len(missing)>0 {
-		log.Fatalf("Missing parameters:%s", missing)
+	format := outputFormat
+	if len(format)==0 {
+		format = "rec"
+	}
+	var sel []string
+	if len(outputFields)>0 {
+		sel = strings.Split(outputFields, ",")
+	}
+	out, err = ustripe.NewRenderer(os.Stdout, format, sel)
+	if err != nil {
+		err = usagef("%s", err)
 	}
 	return
 }
 
-func mainBrowse(args ...string) (err error) {
-	help := []string{
-		`doc-api       : API documentation.`,
-		`doc-testing   : Fake testing user account doc.`,
-		`dashboard     : Show graphs.`,
-		`customers     : Show customers.`,
-		`api-keys      : Open API key configuration place.`,
-		`api-keys-test : Open API key configuration place (Testing).`,
-		`webhooks      : Open STRIPE webhooks page.`,
-		`cfg-invoice   : Set the company NIF.`,
-		`cfg-branding  : Set the company colors etc.`,
-		`cfg-profile   : Set the language, ...`,
-		`cfg-team      : Team members, etc.`,
-	}
-	if len(args)==0 {
-		fmt.Println(strings.Join(help, "\n"))
-		return nil
+func mainWrite(rec ustripe.Record) (err error) {
+	return out.Write(rec)
+}
+
+func mainExit(err error) {
+	var e *exitError
+	if err == nil {
+		os.Exit(0)
 	}
-	cmd := args[0]
-	rel := ustripe.ReleaseMode
-	switch {
-	case cmd == "doc-api"          : ustripe.OpenBrowser("https://stripe.com/docs/api/balance/balance_retrieve?lang=go")
-	case cmd == "doc-testing"      : ustripe.OpenBrowser("https://stripe.com/docs/testing")
-	case cmd == "dashboard"        : ustripe.OpenBrowser("https://dashboard.stripe.com/login")
-	case cmd == "customers" &&  rel: ustripe.OpenBrowser("https://dashboard.stripe.com/customers")
-	case cmd == "customers" && !rel: ustripe.OpenBrowser("https://dashboard.stripe.com/test/customers")
-	case cmd == "api-keys"  &&  rel: ustripe.OpenBrowser("https://dashboard.stripe.com/apikeys")
-	case cmd == "api-keys"  && !rel: ustripe.OpenBrowser("https://dashboard.stripe.com/test/apikeys")
-	case cmd == "webhooks"         : ustripe.OpenBrowser("https://dashboard.stripe.com/webhooks")
-	case cmd == "cfg-invoice"      : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/billing/invoice")
-	case cmd == "cfg-branding"     : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/branding")
-	case cmd == "cfg-profile"      : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/user")
-	case cmd == "cfg-team"         : ustripe.OpenBrowser("https://dashboard.stripe.com/settings/team")
-	default: return fmt.Errorf("Invalid argument: "+ cmd)
+	log.Print(err)
+	if errors.As(err, &e) {
+		os.Exit(e.code)
 	}
-	return nil
+	os.Exit(1)
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &exitError{ 2, fmt.Errorf(format, args...) }
+}
+
+func notFoundf(format string, args ...interface{}) error {
+	return &exitError{ 3, fmt.Errorf(format, args...) }
 }
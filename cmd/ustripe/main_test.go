@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCommandParse(t *testing.T) {
+	for _, c := range []struct {
+		argv []string
+		kvs  map[string]string
+		args []string
+		code int
+	}{
+		{ []string{ "hash1", "p=x" }                , map[string]string{ "password": "x" }, nil, 0 },
+		{ []string{ "hash1", "--password", "x" }    , map[string]string{ "password": "x" }, nil, 0 },
+		{ []string{ "hash1", "-p", "x" }            , map[string]string{ "password": "x" }, nil, 0 },
+		{ []string{ "hash1", "p=x", "extra" }       , nil, nil, 2 },
+		{ []string{ "hash1" }                       , nil, nil, 2 },
+		{ []string{ "hash1", "p=x", "foo=1" }       , nil, nil, 2 },
+		{ []string{ "user-erase", "e=a@b.c", "--confirm" }, map[string]string{ "email": "a@b.c", "confirm": "y" }, nil, 0 },
+		{ []string{ "user-info", "e=a@b.c", "--", "x" }, nil, nil, 2 },
+		{ []string{ "user-del", "a@b.c", "d@e.f" }  , map[string]string{}, []string{ "a@b.c", "d@e.f" }, 0 },
+		{ []string{ "www", "docs" }                 , map[string]string{}, []string{ "docs" }, 0 },
+		{ []string{ "www", "docs", "more" }         , nil, nil, 2 },
+		{ []string{ "invoice-export", "format=facturae", "in_1", "in_2" }, map[string]string{ "format": "facturae" }, []string{ "in_1", "in_2" }, 0 },
+	} {
+		kvs, args, err := commandGet(c.argv[0]).parse(c.argv[1:])
+		var e *exitError
+		switch {
+		case c.code == 0 && err != nil:
+			t.Errorf("%q: %v", c.argv, err)
+		case c.code != 0 && (!errors.As(err, &e) || e.code != c.code):
+			t.Errorf("%q: expected exit status %v, got %v", c.argv, c.code, err)
+		case c.code == 0 && (!reflect.DeepEqual(kvs, c.kvs) || !reflect.DeepEqual(args, c.args)):
+			t.Errorf("%q: got %q %q, want %q %q", c.argv, kvs, args, c.kvs, c.args)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+	"strings"
+	"strconv"
+	"github.com/harkaitz/ustripe"
+	"github.com/stripe/stripe-go/v73"
+)
+
+// tui is a full-screen terminal interface for customer support. The
+// terminal is put in raw mode with stty(1) and drawn with ANSI escapes.
+type tui struct {
+	tty    *os.File
+	stty    string
+	rows    int
+	cols    int
+	status  string
+}
+
+const (
+	keyEnter  = "\r"
+	keyEscape = "\x1b"
+	keyUp     = "\x1b[A"
+	keyDown   = "\x1b[B"
+	keyCtrlC  = "\x03"
+)
+
+func init() {
+	commands = append(commands, &command{
+		name: "tui", brief: "Interactive interface for customer support.",
+		doc:
+`Search customers by email or name, see their information, subscriptions
+and invoices, resend the validation mail, change the password and cancel
+subscriptions.`,
+		run: cmdTUI,
+	})
+}
+
+func cmdTUI(c *command, kvs map[string]string, args []string) (err error) {
+	t, err := tuiOpen()
+	if err != nil {
+		return
+	}
+	defer t.close()
+	/* Errors are shown in the status line, not logged over the screen. */
+	stripe.DefaultLeveledLogger = &stripe.LeveledLogger{ Level: stripe.LevelNull }
+	for {
+		t.draw("search", nil, "Enter:search  Esc:quit")
+		query, ok := t.input("Search customer (email or name, empty to quit): ", false)
+		if !ok || len(query)==0 {
+			return nil
+		}
+		if quit := t.search(query); quit {
+			return nil
+		}
+	}
+}
+
+func tuiOpen() (t *tui, err error) {
+	t = &tui{}
+	t.tty, err = os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if t.stty, err = t.run("stty", "-g"); err != nil {
+		t.tty.Close()
+		return nil, err
+	}
+	if _, err = t.run("stty", "raw", "-echo"); err != nil {
+		t.tty.Close()
+		return nil, err
+	}
+	if size, err := t.run("stty", "size"); err == nil {
+		fmt.Sscan(size, &t.rows, &t.cols)
+	}
+	if t.rows < 10 || t.cols < 40 {
+		t.rows, t.cols = 24, 80
+	}
+	fmt.Fprint(t.tty, "\x1b[?1049h")
+	return t, nil
+}
+
+func (t *tui) close() {
+	fmt.Fprint(t.tty, "\x1b[?1049l")
+	t.run("stty", t.stty)
+	t.tty.Close()
+}
+
+func (t *tui) run(prog string, args ...string) (s string, err error) {
+	cmd := exec.Command(prog, args...)
+	cmd.Stdin = t.tty
+	data, err := cmd.Output()
+	return strings.TrimSpace(string(data)), err
+}
+
+// draw clears the screen and prints the lines, the status line, the
+// input line and the key help at the bottom.
+func (t *tui) draw(title string, lines []string, keys string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J\x1b[7m" + t.fit(" ustripe: " + title) + "\x1b[0m\r\n")
+	for n, l := range lines {
+		if n >= t.rows - 4 {
+			break
+		}
+		b.WriteString(t.fit(l) + "\r\n")
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H%s\r\n\r\n\x1b[7m%s\x1b[0m", t.rows-2, t.fit(t.status), t.fit(" " + keys))
+	fmt.Fprint(t.tty, b.String())
+	t.status = ""
+}
+
+func (t *tui) fit(s string) string {
+	if len(s) > t.cols {
+		return s[:t.cols]
+	}
+	return s + strings.Repeat(" ", t.cols - len(s))
+}
+
+func (t *tui) key() string {
+	buf := make([]byte, 8)
+	n, err := t.tty.Read(buf)
+	if err != nil || n == 0 {
+		return keyCtrlC
+	}
+	return string(buf[:n])
+}
+
+// input reads a line in the status line, Escape or Ctrl-C cancel.
+func (t *tui) input(prompt string, hidden bool) (s string, ok bool) {
+	for {
+		shown := s
+		if hidden {
+			shown = strings.Repeat("*", len(s))
+		}
+		fmt.Fprintf(t.tty, "\x1b[%d;1H\x1b[2K%s%s", t.rows-1, prompt, shown)
+		switch k := t.key(); {
+		case k == keyEnter:
+			return s, true
+		case k == keyEscape || k == keyCtrlC:
+			return "", false
+		case k == "\x7f" || k == "\b":
+			if len(s)>0 {
+				s = s[:len(s)-1]
+			}
+		case k[0] >= ' ' && k[0] != 0x7f:
+			s += k
+		}
+	}
+}
+
+func (t *tui) confirm(question string) bool {
+	fmt.Fprintf(t.tty, "\x1b[%d;1H\x1b[2K%s [y/N] ", t.rows-1, question)
+	return strings.ToLower(t.key()) == "y"
+}
+
+// search lists the customers matching the query and opens the selected.
+func (t *tui) search(query string) (quit bool) {
+	users, err := ustripe.UserFind(query, t.rows - 5)
+	if err != nil {
+		t.status = "Error: " + err.Error()
+		return false
+	}
+	if len(users)==0 {
+		t.status = "No customers found."
+		return false
+	}
+	sel := 0
+	for {
+		lines := []string{}
+		for n, u := range users {
+			mark := "  "
+			if n == sel {
+				mark = "> "
+			}
+			lines = append(lines, fmt.Sprintf("%s%-20s %-30s %-10s %s", mark, u.ID, u.Email, ustripe.UserVerifiedS(u), u.Name))
+		}
+		t.draw("customers matching " + strconv.Quote(query), lines, "Up/Down:move  Enter:open  /:search  q:quit")
+		switch t.key() {
+		case keyUp, "k":
+			if sel > 0 {
+				sel--
+			}
+		case keyDown, "j":
+			if sel < len(users)-1 {
+				sel++
+			}
+		case keyEnter:
+			if quit = t.customer(users[sel].Email); quit {
+				return
+			}
+		case "/", keyEscape:
+			return false
+		case "q", keyCtrlC:
+			return true
+		}
+	}
+}
+
+// customer shows a customer and runs the actions on it.
+func (t *tui) customer(email string) (quit bool) {
+	for {
+		u, found := ustripe.UserSearch(email)
+		if !found {
+			t.status = email + ": user not found"
+			return false
+		}
+		infos, err := ustripe.UserSubsInfo(u.ID)
+		if err != nil {
+			t.status = err.Error()
+		}
+		invoices, err := ustripe.InvoiceList(email, "", time.Now().AddDate(-1, 0, 0))
+		if err != nil {
+			t.status = err.Error()
+		}
+		lines := []string{ "" }
+		for _, f := range ustripe.UserRecord(u) {
+			lines = append(lines, fmt.Sprintf("%-10s %v", f.Key + ":", f.Value))
+		}
+		lines = append(lines, "", "Subscriptions:")
+		for n, i := range infos {
+			var prods []string
+			for _, item := range i.Items {
+				prods = append(prods, item.Product)
+			}
+			cancel := ""
+			if i.CancelAtPeriodEnd {
+				cancel = " (cancels at period end)"
+			}
+			lines = append(lines, fmt.Sprintf("  %d) %-30s %-10s access=%-5v until %s%s %s",
+				n+1, i.ID, i.Status, i.Access, i.Expires().Format("2006-01-02"), cancel, strings.Join(prods, ",")))
+		}
+		lines = append(lines, "", "Invoices (last year):")
+		for _, inv := range invoices {
+			lines = append(lines, fmt.Sprintf("  %-30s %-15s %s %-13s %s", inv.ID, inv.Number,
+				time.Unix(inv.Created, 0).Format("2006-01-02"), inv.Status, ustripe.NewMoney(inv.Total, inv.Currency)))
+		}
+		t.draw(email, lines, "v:resend validation  p:password  c:cancel subscription  r:refresh  b:back  q:quit")
+		switch t.key() {
+		case "v":
+			if t.confirm("Send the validation mail to " + email + "?") {
+				t.result("Validation mail sent.", ustripe.UserSendValidationMail(u.ID))
+			}
+		case "p":
+			t.password(email)
+		case "c":
+			t.cancel(infos)
+		case "b", keyEscape:
+			return false
+		case "q", keyCtrlC:
+			return true
+		}
+	}
+}
+
+func (t *tui) password(email string) {
+	p1, ok := t.input("New password: ", true)
+	if !ok || len(p1)==0 {
+		return
+	}
+	p2, ok := t.input("Repeat password: ", true)
+	if !ok {
+		return
+	}
+	if p1 != p2 {
+		t.status = "The passwords do not match."
+		return
+	}
+	if t.confirm("Change the password of " + email + "?") {
+		_, err := ustripe.UserChangePass(email, p1)
+		t.result("Password changed.", err)
+	}
+}
+
+func (t *tui) cancel(infos []*ustripe.SubscriptionInfo) {
+	s, ok := t.input("Subscription number to cancel: ", false)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(infos) {
+		t.status = "Invalid subscription number."
+		return
+	}
+	i := infos[n-1]
+	if i.Status == string(stripe.SubscriptionStatusCanceled) {
+		t.status = i.ID + " is already canceled."
+		return
+	}
+	fmt.Fprintf(t.tty, "\x1b[%d;1H\x1b[2KCancel %s [n]ow, at period [e]nd or [a]bort? ", t.rows-1, i.ID)
+	switch t.key() {
+	case "n":
+		if t.confirm("Cancel " + i.ID + " now, access ends immediately?") {
+			_, err = ustripe.SubscriptionCancel(i.ID, false)
+			t.result(i.ID + " canceled.", err)
+		}
+	case "e":
+		if t.confirm("Cancel " + i.ID + " at the end of the period?") {
+			_, err = ustripe.SubscriptionCancel(i.ID, true)
+			t.result(i.ID + " cancels at the end of the period.", err)
+		}
+	}
+}
+
+func (t *tui) result(msg string, err error) {
+	if err != nil {
+		t.status = "Error: " + err.Error()
+	} else {
+		t.status = msg
+	}
+}
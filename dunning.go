@@ -0,0 +1,296 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/invoice"
+	"github.com/stripe/stripe-go/v73/subscription"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+// Dunning is an unpaid renewal being followed by the dunning engine.
+type Dunning struct {
+	Subscription string `json:"subscription"`
+	Customer     string `json:"customer"`
+	Email        string `json:"email"`
+	Invoice      string `json:"invoice"`
+	InvoiceURL   string `json:"invoice_url,omitempty"`
+	Started      int64  `json:"started"`
+	Reminders    int    `json:"reminders"`
+	LastReminder int64  `json:"last_reminder,omitempty"`
+	Resolved     int64  `json:"resolved,omitempty"`
+	Downgraded   int64  `json:"downgraded,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// DunningDowngrade is run when the grace period ends without payment.
+var DunningDowngrade func (d *Dunning) (err error) = DefaultDunningDowngrade
+
+var dunningMutex sync.Mutex
+
+// DunningEvent opens a case on "invoice.payment_failed" and closes it
+// when the invoice is paid.
+func DunningEvent(e *stripe.Event) (err error) {
+	var changed bool
+	if len(DunningFile)==0 || e.Data == nil {
+		return nil
+	}
+	switch e.Type {
+	case "invoice.payment_failed", "invoice.paid", "invoice.payment_succeeded", "invoice.voided":
+	default:
+		return nil
+	}
+	inv := &stripe.Invoice{}
+	if err = json.Unmarshal(e.Data.Raw, inv); err != nil {
+		return
+	}
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	ds, err := dunningLoad()
+	if err != nil {
+		return
+	}
+	if e.Type == "invoice.payment_failed" {
+		changed = dunningOpen(ds, inv, time.Unix(e.Created, 0))
+	} else {
+		changed = dunningClose(ds, inv, time.Unix(e.Created, 0))
+	}
+	if changed {
+		err = dunningSave(ds)
+	}
+	return
+}
+
+// DunningPoll opens cases for open invoices with failed payment attempts
+// and closes the cases whose invoice is no longer open. It can be used
+// instead of, or together with, the webhook.
+func DunningPoll() (err error) {
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	ds, err := dunningLoad()
+	if err != nil {
+		return
+	}
+	p := &stripe.InvoiceListParams{}
+	p.Status = stripe.String("open")
+	p.Filters.AddFilter("limit", "", "100")
+	i := invoice.List(p)
+	open := map[string]bool{}
+	for i.Next() {
+		inv := i.Invoice()
+		if inv.AttemptCount > 0 {
+			open[inv.ID] = true
+			dunningOpen(ds, inv, time.Unix(inv.Created, 0))
+		}
+	}
+	if err = i.Err(); err != nil {
+		return
+	}
+	for _, d := range ds {
+		if d.Resolved == 0 && d.Downgraded == 0 && !open[d.Invoice] {
+			d.Resolved = time.Now().Unix()
+		}
+	}
+	return dunningSave(ds)
+}
+
+// DunningRun sends the reminders due according to DunningSchedule and
+// downgrades the subscriptions whose grace period ended. Errors are
+// recorded in the case and the first one is returned.
+func DunningRun(now time.Time) (err error) {
+	var derr error
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	ds, err := dunningLoad()
+	if err != nil {
+		return
+	}
+	for _, d := range ds {
+		if d.Resolved != 0 || d.Downgraded != 0 {
+			continue
+		}
+		started := time.Unix(d.Started, 0)
+		switch {
+		case !now.Before(started.Add(DunningGrace)):
+			derr = DunningDowngrade(d)
+			if derr == nil {
+				d.Downgraded = now.Unix()
+			}
+		case d.Reminders < len(DunningSchedule) && !now.Before(started.Add(DunningSchedule[d.Reminders])):
+			derr = dunningRemind(d, started.Add(DunningGrace))
+			if derr == nil {
+				d.Reminders++
+				d.LastReminder = now.Unix()
+			}
+		default:
+			continue
+		}
+		d.Error = ""
+		if derr != nil {
+			d.Error = derr.Error()
+			if err == nil {
+				err = derr
+			}
+		}
+	}
+	if serr := dunningSave(ds); serr != nil && err == nil {
+		err = serr
+	}
+	return
+}
+
+// DunningGraced returns true if the subscription has an unpaid renewal
+// still in the grace period.
+func DunningGraced(subscriptionID string) bool {
+	if len(DunningFile)==0 {
+		return false
+	}
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	ds, err := dunningLoad()
+	if err != nil {
+		return false
+	}
+	d := ds[subscriptionID]
+	return d != nil && d.Resolved == 0 && d.Downgraded == 0 &&
+		time.Now().Before(time.Unix(d.Started, 0).Add(DunningGrace))
+}
+
+// DunningStatus lists the cases, of a single customer if the email
+// is given.
+func DunningStatus(email string) (l []*Dunning, err error) {
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	ds, err := dunningLoad()
+	if err != nil {
+		return
+	}
+	for _, d := range ds {
+		if len(email)==0 || d.Email == email {
+			l = append(l, d)
+		}
+	}
+	sort.Slice(l, func (i, j int) bool { return l[i].Started < l[j].Started })
+	return
+}
+
+// DunningPrintREC prints a dunning case in recutils format.
+func DunningPrintREC(d *Dunning) {
+	fmt.Printf("Subscription: %s\n", d.Subscription)
+	fmt.Printf("Customer: %s\n", d.Customer)
+	fmt.Printf("Email: %s\n", d.Email)
+	fmt.Printf("Invoice: %s\n", d.Invoice)
+	fmt.Printf("Status: %s\n", d.status())
+	fmt.Printf("Started: %s\n", time.Unix(d.Started, 0).Format(time.RFC3339))
+	fmt.Printf("GraceEnd: %s\n", time.Unix(d.Started, 0).Add(DunningGrace).Format(time.RFC3339))
+	fmt.Printf("Reminders: %v\n", d.Reminders)
+	if d.LastReminder != 0 {
+		fmt.Printf("LastReminder: %s\n", time.Unix(d.LastReminder, 0).Format(time.RFC3339))
+	}
+	if len(d.Error)>0 {
+		fmt.Printf("Error: %s\n", d.Error)
+	}
+	fmt.Printf("\n")
+}
+
+// DunningRecord returns the case as a Record for Renderer.
+func DunningRecord(d *Dunning) Record {
+	return Record{
+		{ "subscription" , d.Subscription },
+		{ "customer"     , d.Customer },
+		{ "email"        , d.Email },
+		{ "invoice"      , d.Invoice },
+		{ "status"       , d.status() },
+		{ "started"      , time.Unix(d.Started, 0).Format(time.RFC3339) },
+		{ "grace_end"    , time.Unix(d.Started, 0).Add(DunningGrace).Format(time.RFC3339) },
+		{ "reminders"    , d.Reminders },
+		{ "error"        , d.Error },
+	}
+}
+
+func (d *Dunning) status() string {
+	if d.Resolved != 0 {
+		return "resolved"
+	} else if d.Downgraded != 0 {
+		return "downgraded"
+	}
+	return "open"
+}
+
+// DefaultDunningDowngrade cancels the subscription.
+func DefaultDunningDowngrade(d *Dunning) (err error) {
+	_, err = subscription.Cancel(d.Subscription, nil)
+	return
+}
+
+func dunningRemind(d *Dunning, deadline time.Time) (err error) {
+	u, found := UserSearch(d.Email)
+	if !found {
+		return fmt.Errorf("%s: user not found", d.Email)
+	}
+	return SendMail(DunningMail(u, u.Email, d.InvoiceURL, d.Reminders, deadline))
+}
+
+func dunningOpen(ds map[string]*Dunning, inv *stripe.Invoice, t time.Time) bool {
+	if inv.Subscription == nil || inv.Customer == nil {
+		return false
+	}
+	if d := ds[inv.Subscription.ID]; d != nil && d.Invoice == inv.ID {
+		return false
+	}
+	ds[inv.Subscription.ID] = &Dunning{
+		Subscription: inv.Subscription.ID,
+		Customer:     inv.Customer.ID,
+		Email:        inv.CustomerEmail,
+		Invoice:      inv.ID,
+		InvoiceURL:   inv.HostedInvoiceURL,
+		Started:      t.Unix(),
+	}
+	return true
+}
+
+func dunningClose(ds map[string]*Dunning, inv *stripe.Invoice, t time.Time) bool {
+	if inv.Subscription == nil {
+		return false
+	}
+	d := ds[inv.Subscription.ID]
+	if d == nil || d.Invoice != inv.ID || d.Resolved != 0 {
+		return false
+	}
+	d.Resolved = t.Unix()
+	return true
+}
+
+func dunningLoad() (ds map[string]*Dunning, err error) {
+	var data []byte
+	ds = map[string]*Dunning{}
+	data, err = os.ReadFile(DunningFile)
+	if os.IsNotExist(err) {
+		return ds, nil
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &ds); err != nil {
+		return nil, fmt.Errorf("%s: %s", DunningFile, err)
+	}
+	return
+}
+
+func dunningSave(ds map[string]*Dunning) (err error) {
+	var data []byte
+	data, err = json.MarshalIndent(ds, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(DunningFile), "." + filepath.Base(DunningFile) + ".tmp")
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp, DunningFile)
+}
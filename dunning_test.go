@@ -0,0 +1,103 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"encoding/json"
+	"path/filepath"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func testDunningEvent(t *testing.T, typ, invoice string, created time.Time) {
+	e := &stripe.Event{}
+	data := fmt.Sprintf(`{"type": %q, "created": %d, "data": {"object": {"object": "invoice", "id": %q, "subscription": "sub_1", "customer": "cus_1", "customer_email": "a@example.com"}}}`,
+		typ, created.Unix(), invoice)
+	if err := json.Unmarshal([]byte(data), e); err != nil {
+		t.Fatal(err)
+	}
+	if err := DunningEvent(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testDunningCase(t *testing.T) (d *Dunning) {
+	l, err := DunningStatus("a@example.com")
+	if err != nil || len(l) != 1 {
+		t.Fatalf("expected one case, got %v %v", l, err)
+	}
+	return l[0]
+}
+
+func TestDunning(t *testing.T) {
+	dir := t.TempDir()
+	m := mirrorNew()
+	m.SyncedAt = time.Now().Unix()
+	m.Customers["cus_1"] = &stripe.Customer{ ID: "cus_1", Email: "a@example.com" }
+	testMirror(t, m)
+	defer func (s string) { DunningFile = s }(DunningFile)
+	defer func (s string) { SendmailCommand = s }(SendmailCommand)
+	defer func (s []time.Duration, g time.Duration) { DunningSchedule, DunningGrace = s, g }(DunningSchedule, DunningGrace)
+	defer func (f func (*Dunning) error) { DunningDowngrade = f }(DunningDowngrade)
+	mails := filepath.Join(dir, "mails")
+	DunningFile     = filepath.Join(dir, "dunning.json")
+	SendmailCommand = "cat >> " + mails
+	DunningSchedule = []time.Duration{ 0, 72 * time.Hour }
+	DunningGrace    = 7 * 24 * time.Hour
+	downgrades     := 0
+	DunningDowngrade = func (d *Dunning) error { downgrades++; return nil }
+
+	/* Open. */
+	start := time.Now().Add(-time.Hour)
+	testDunningEvent(t, "invoice.payment_failed", "in_1", start)
+	if d := testDunningCase(t); d.status() != "open" || d.Invoice != "in_1" {
+		t.Fatalf("case not opened: %+v", d)
+	}
+
+	/* First reminder, the second is not due yet. */
+	if err := DunningRun(start); err != nil {
+		t.Fatal(err)
+	}
+	if err := DunningRun(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if d := testDunningCase(t); d.Reminders != 1 {
+		t.Fatalf("expected 1 reminder, got %v", d.Reminders)
+	}
+	if data, _ := os.ReadFile(mails); len(data)==0 {
+		t.Fatalf("the reminder was not mailed")
+	}
+
+	/* Downgrade at the end of the grace period. */
+	if err := DunningRun(start.Add(DunningGrace)); err != nil {
+		t.Fatal(err)
+	}
+	if d := testDunningCase(t); d.status() != "downgraded" || downgrades != 1 {
+		t.Fatalf("not downgraded: %+v (%v)", d, downgrades)
+	}
+
+	/* Later retries of the same invoice don't reopen the case. */
+	testDunningEvent(t, "invoice.payment_failed", "in_1", start.Add(DunningGrace))
+	ds, _ := dunningLoad()
+	inv := &stripe.Invoice{ ID: "in_1", Subscription: &stripe.Subscription{ ID: "sub_1" }, Customer: &stripe.Customer{ ID: "cus_1" } }
+	if dunningOpen(ds, inv, time.Now()) {
+		t.Errorf("the poll reopened a downgraded case")
+	}
+	if err := DunningRun(time.Now().Add(DunningGrace)); err != nil {
+		t.Fatal(err)
+	}
+	if d := testDunningCase(t); d.status() != "downgraded" || downgrades != 1 {
+		t.Fatalf("downgraded again: %+v (%v)", d, downgrades)
+	}
+
+	/* A new unpaid invoice opens a new case. */
+	testDunningEvent(t, "invoice.payment_failed", "in_2", time.Now())
+	if d := testDunningCase(t); d.status() != "open" || d.Invoice != "in_2" {
+		t.Fatalf("case not reopened: %+v", d)
+	}
+	testDunningEvent(t, "invoice.paid", "in_2", time.Now())
+	if d := testDunningCase(t); d.status() != "resolved" {
+		t.Fatalf("case not resolved: %+v", d)
+	}
+}
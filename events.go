@@ -0,0 +1,308 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/event"
+	"encoding/json"
+	"path"
+	"bufio"
+	"sync"
+	"time"
+	"sort"
+	"log"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// EventFilter selects events from the event store.
+type EventFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Type     string  // Accepts patterns, "customer.*".
+	Customer string
+}
+
+var eventsMutex sync.Mutex
+var eventsKnown map[string]bool = nil
+
+// EventReceive stores the event in EventsFile and dispatches it when
+// it wasn't already stored. Duplicated deliveries are ignored.
+func EventReceive(e *stripe.Event) (err error) {
+	var stored bool
+	stored, err = EventStore(e)
+	if err != nil || !stored {
+		return
+	}
+	return EventDispatch(e)
+}
+
+// EventStore appends the event to EventsFile if not already there.
+func EventStore(e *stripe.Event) (stored bool, err error) {
+	var data []byte
+	var fp   *os.File
+
+	if len(EventsFile)==0 {
+		return true, nil
+	}
+	unlock, err := eventsLock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if eventsKnown == nil {
+		eventsKnown = map[string]bool{}
+		err = eventsScan(func (o *stripe.Event) bool {
+			eventsKnown[o.ID] = true
+			return true
+		})
+		if err != nil {
+			eventsKnown = nil
+			return
+		}
+	}
+	if eventsKnown[e.ID] {
+		return false, nil
+	}
+	if erased(EventCustomer(e)) {
+		data, err = json.Marshal(eventRedacted(e))
+	} else {
+		data, err = json.Marshal(e)
+	}
+	if err != nil {
+		return
+	}
+	fp, err = os.OpenFile(EventsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	_, err = fp.Write(append(data, '\n'))
+	if err != nil {
+		return
+	}
+	eventsKnown[e.ID] = true
+	return true, nil
+}
+
+// eventsLock locks EventsFile against this and other processes, the
+// webhook server appends to it while user-erase rewrites it.
+func eventsLock() (unlock func (), err error) {
+	var fp *os.File
+	eventsMutex.Lock()
+	fp, err = os.OpenFile(EventsFile + ".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err == nil {
+		if err = syscall.Flock(int(fp.Fd()), syscall.LOCK_EX); err != nil {
+			fp.Close()
+		}
+	}
+	if err != nil {
+		eventsMutex.Unlock()
+		return nil, err
+	}
+	return func () {
+		syscall.Flock(int(fp.Fd()), syscall.LOCK_UN)
+		fp.Close()
+		eventsMutex.Unlock()
+	}, nil
+}
+
+// EventPoll fetches the events newer than the last stored one, or
+// than since when later or nothing is stored, from Stripe and receives
+// them in chronological order. Dispatch failures are logged, the hook
+// failures are kept in the dead-letter directory by HookRun.
+func EventPoll(since time.Time) (n int, err error) {
+	var last   int64
+	var events []*stripe.Event
+	var stored bool
+	var failed int
+
+	err = eventsScan(func (e *stripe.Event) bool {
+		if e.Created > last {
+			last = e.Created
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if !since.IsZero() && since.Unix() > last {
+		last = since.Unix()
+	}
+	if last == 0 {
+		return 0, fmt.Errorf("no stored events, specify since when to poll")
+	}
+	p := &stripe.EventListParams{}
+	p.Filters.AddFilter("limit", "", "100")
+	p.CreatedRange = &stripe.RangeQueryParams{ GreaterThanOrEqual: last }
+	i := event.List(p)
+	for i.Next() {
+		events = append(events, i.Event())
+	}
+	if err = i.Err(); err != nil {
+		return
+	}
+	sort.SliceStable(events, func (i, j int) bool {
+		return events[i].Created < events[j].Created
+	})
+	for _, e := range events {
+		stored, err = EventStore(e)
+		if err != nil {
+			return
+		}
+		if stored {
+			n++
+			if err = EventDispatch(e); err != nil {
+				log.Printf("events-poll: %s: %s", e.ID, err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return n, fmt.Errorf("%d of %d events failed to dispatch", failed, n)
+	}
+	return n, nil
+}
+
+// EventList returns the stored events matching the filter.
+func EventList(f EventFilter) (events []*stripe.Event, err error) {
+	err = eventsScan(func (e *stripe.Event) bool {
+		if f.Match(e) {
+			events = append(events, e)
+		}
+		return true
+	})
+	return
+}
+
+// EventGet returns a stored event.
+func EventGet(id string) (e *stripe.Event, err error) {
+	err = eventsScan(func (o *stripe.Event) bool {
+		if o.ID == id {
+			e = o
+			return false
+		}
+		return true
+	})
+	if err == nil && e == nil {
+		err = fmt.Errorf("event not found: %s", id)
+	}
+	return
+}
+
+// EventReplay dispatches again the stored events matching the filter,
+// returns the number of events dispatched and failed. Failures are
+// logged and don't stop the replay.
+func EventReplay(f EventFilter) (n, failed int, err error) {
+	var events []*stripe.Event
+	events, err = EventList(f)
+	if err != nil {
+		return
+	}
+	for _, e := range events {
+		n++
+		if derr := EventDispatch(e); derr != nil {
+			log.Printf("events-replay: %s: %s", e.ID, derr)
+			failed++
+		}
+	}
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d events failed to dispatch", failed, n)
+	}
+	return
+}
+
+// Match returns true when the event matches the filter.
+func (f EventFilter) Match(e *stripe.Event) bool {
+	created := time.Unix(e.Created, 0)
+	switch {
+	case !f.Since.IsZero() && created.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !created.Before(f.Until):
+		return false
+	case len(f.Customer)>0 && EventCustomer(e) != f.Customer:
+		return false
+	case len(f.Type)>0:
+		m, _ := path.Match(f.Type, e.Type)
+		return m
+	default:
+		return true
+	}
+}
+
+// EventPrint prints a line describing the event.
+func EventPrint(e *stripe.Event) {
+	fmt.Printf(
+		"%-30s %s %-40s %s\n",
+		e.ID,
+		time.Unix(e.Created, 0).Format(time.RFC3339),
+		e.Type,
+		eventString(e, "id"))
+}
+
+// EventRecord returns the event as a Record for Renderer.
+func EventRecord(e *stripe.Event) Record {
+	return Record{
+		{ "id"      , e.ID },
+		{ "created" , time.Unix(e.Created, 0).Format(time.RFC3339) },
+		{ "type"    , e.Type },
+		{ "object"  , eventString(e, "id") },
+		{ "customer", EventCustomer(e) },
+	}
+}
+
+// ParseTime reads a time in any of the following formats: UNIX
+// timestamp, RFC3339, "2006-01-02" or a duration ("24h" means a day ago).
+func ParseTime(s string) (t time.Time, err error) {
+	var d time.Duration
+	var u int64
+	if u, err = strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(u, 0), nil
+	}
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return
+	}
+	if t, err = time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return
+	}
+	if d, err = time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return t, fmt.Errorf("invalid time: %s", s)
+}
+
+func eventsScan(f func (e *stripe.Event) bool) (err error) {
+	var fp      *os.File
+	var scanner *bufio.Scanner
+	var line     string
+
+	if len(EventsFile)==0 {
+		return fmt.Errorf("event store not configured")
+	}
+	fp, err = os.Open(EventsFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	defer fp.Close()
+	scanner = bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line = strings.TrimSpace(scanner.Text())
+		if len(line)==0 {
+			continue
+		}
+		e := &stripe.Event{}
+		err = json.Unmarshal([]byte(line), e)
+		if err != nil {
+			return fmt.Errorf("%s: %s", EventsFile, err)
+		}
+		if !f(e) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,408 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	_ "embed"
+	"encoding/xml"
+	"path/filepath"
+	"os/exec"
+	"os"
+	"strings"
+	"bytes"
+	"time"
+	"sort"
+	"fmt"
+)
+
+// FacturaeParty is the information of the seller, read from the
+// FACTURAE_SELLER_* environment variables.
+type FacturaeParty struct {
+	TaxID    string
+	Name     string
+	Address  string
+	PostCode string
+	Town     string
+	Province string
+	Country  string // ISO 3166-1 alpha-2.
+}
+
+// FacturaeSeller is the issuer of the invoices.
+var FacturaeSeller FacturaeParty = FacturaeParty{ Country: "ES" }
+
+// FacturaeSchema is the XSD used by FacturaeCheck, when empty the
+// bundled schemas/facturae-subset.xsd. Set it to the official
+// Facturaev3_2_2.xsd, with its xmldsig import, to validate signed
+// documents.
+var FacturaeSchema string = ""
+
+//go:embed schemas/facturae-subset.xsd
+var facturaeXSD []byte
+
+type facturae struct {
+	XMLName    xml.Name           `xml:"fe:Facturae"`
+	XmlnsFe    string             `xml:"xmlns:fe,attr"`
+	XmlnsDs    string             `xml:"xmlns:ds,attr"`
+	FileHeader facturaeFileHeader `xml:"FileHeader"`
+	Parties    facturaeParties    `xml:"Parties"`
+	Invoices   []facturaeInvoice  `xml:"Invoices>Invoice"`
+}
+
+type facturaeFileHeader struct {
+	SchemaVersion     string        `xml:"SchemaVersion"`
+	Modality          string        `xml:"Modality"`
+	InvoiceIssuerType string        `xml:"InvoiceIssuerType"`
+	Batch             facturaeBatch `xml:"Batch"`
+}
+
+type facturaeBatch struct {
+	BatchIdentifier        string         `xml:"BatchIdentifier"`
+	InvoicesCount          int            `xml:"InvoicesCount"`
+	TotalInvoicesAmount    facturaeAmount `xml:"TotalInvoicesAmount"`
+	TotalOutstandingAmount facturaeAmount `xml:"TotalOutstandingAmount"`
+	TotalExecutableAmount  facturaeAmount `xml:"TotalExecutableAmount"`
+	InvoiceCurrencyCode    string         `xml:"InvoiceCurrencyCode"`
+}
+
+type facturaeAmount struct {
+	TotalAmount string `xml:"TotalAmount"`
+}
+
+type facturaeParties struct {
+	SellerParty facturaeParty `xml:"SellerParty"`
+	BuyerParty  facturaeParty `xml:"BuyerParty"`
+}
+
+type facturaeParty struct {
+	PersonTypeCode          string                  `xml:"TaxIdentification>PersonTypeCode"`
+	ResidenceTypeCode       string                  `xml:"TaxIdentification>ResidenceTypeCode"`
+	TaxIdentificationNumber string                  `xml:"TaxIdentification>TaxIdentificationNumber"`
+	CorporateName           string                  `xml:"LegalEntity>CorporateName"`
+	AddressInSpain         *facturaeAddressSpain    `xml:"LegalEntity>AddressInSpain,omitempty"`
+	OverseasAddress        *facturaeAddressOverseas `xml:"LegalEntity>OverseasAddress,omitempty"`
+}
+
+type facturaeAddressSpain struct {
+	Address     string `xml:"Address"`
+	PostCode    string `xml:"PostCode"`
+	Town        string `xml:"Town"`
+	Province    string `xml:"Province"`
+	CountryCode string `xml:"CountryCode"`
+}
+
+type facturaeAddressOverseas struct {
+	Address         string `xml:"Address"`
+	PostCodeAndTown string `xml:"PostCodeAndTown"`
+	Province        string `xml:"Province"`
+	CountryCode     string `xml:"CountryCode"`
+}
+
+type facturaeInvoice struct {
+	InvoiceNumber       string                `xml:"InvoiceHeader>InvoiceNumber"`
+	InvoiceSeriesCode   string                `xml:"InvoiceHeader>InvoiceSeriesCode,omitempty"`
+	InvoiceDocumentType string                `xml:"InvoiceHeader>InvoiceDocumentType"`
+	InvoiceClass        string                `xml:"InvoiceHeader>InvoiceClass"`
+	IssueDate           string                `xml:"InvoiceIssueData>IssueDate"`
+	InvoiceCurrencyCode string                `xml:"InvoiceIssueData>InvoiceCurrencyCode"`
+	TaxCurrencyCode     string                `xml:"InvoiceIssueData>TaxCurrencyCode"`
+	LanguageName        string                `xml:"InvoiceIssueData>LanguageName"`
+	TaxesOutputs        []facturaeTax         `xml:"TaxesOutputs>Tax"`
+	Totals              facturaeInvoiceTotals `xml:"InvoiceTotals"`
+	Items               []facturaeInvoiceLine `xml:"Items>InvoiceLine"`
+}
+
+type facturaeTax struct {
+	TaxTypeCode string         `xml:"TaxTypeCode"`
+	TaxRate     string         `xml:"TaxRate"`
+	TaxableBase facturaeAmount `xml:"TaxableBase"`
+	TaxAmount   facturaeAmount `xml:"TaxAmount"`
+}
+
+type facturaeInvoiceTotals struct {
+	TotalGrossAmount            string `xml:"TotalGrossAmount"`
+	TotalGrossAmountBeforeTaxes string `xml:"TotalGrossAmountBeforeTaxes"`
+	TotalTaxOutputs             string `xml:"TotalTaxOutputs"`
+	TotalTaxesWithheld          string `xml:"TotalTaxesWithheld"`
+	InvoiceTotal                string `xml:"InvoiceTotal"`
+	TotalOutstandingAmount      string `xml:"TotalOutstandingAmount"`
+	TotalExecutableAmount       string `xml:"TotalExecutableAmount"`
+}
+
+type facturaeInvoiceLine struct {
+	ItemDescription     string                `xml:"ItemDescription"`
+	Quantity            string                `xml:"Quantity"`
+	UnitOfMeasure       string                `xml:"UnitOfMeasure"`
+	UnitPriceWithoutTax string                `xml:"UnitPriceWithoutTax"`
+	TotalCost           string                `xml:"TotalCost"`
+	Discounts          *facturaeDiscount      `xml:"DiscountsAndRebates>Discount,omitempty"`
+	GrossAmount         string                `xml:"GrossAmount"`
+	TaxesOutputs        []facturaeTax         `xml:"TaxesOutputs>Tax"`
+}
+
+type facturaeDiscount struct {
+	DiscountReason string `xml:"DiscountReason"`
+	DiscountAmount string `xml:"DiscountAmount"`
+}
+
+var facturaeCountries map[string]string = map[string]string{
+	"ES": "ESP", "AT": "AUT", "BE": "BEL", "BG": "BGR", "CY": "CYP",
+	"CZ": "CZE", "DE": "DEU", "DK": "DNK", "EE": "EST", "FI": "FIN",
+	"FR": "FRA", "GR": "GRC", "HR": "HRV", "HU": "HUN", "IE": "IRL",
+	"IT": "ITA", "LT": "LTU", "LU": "LUX", "LV": "LVA", "MT": "MLT",
+	"NL": "NLD", "PL": "POL", "PT": "PRT", "RO": "ROU", "SE": "SWE",
+	"SI": "SVN", "SK": "SVK", "GB": "GBR", "US": "USA", "CH": "CHE",
+	"AD": "AND", "MX": "MEX", "AR": "ARG", "NO": "NOR",
+}
+
+var facturaeEU map[string]bool = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true,
+	"DK": true, "EE": true, "FI": true, "FR": true, "GR": true, "HR": true,
+	"HU": true, "IE": true, "IT": true, "LT": true, "LU": true, "LV": true,
+	"MT": true, "NL": true, "PL": true, "PT": true, "RO": true, "SE": true,
+	"SI": true, "SK": true,
+}
+
+// FacturaeExport converts a finalized Stripe invoice in EUR to a
+// Facturae 3.2.2 document (without signature).
+func FacturaeExport(inv *stripe.Invoice) (doc []byte, err error) {
+	var f       facturae
+	var fi      facturaeInvoice
+	var buyer   facturaeParty
+	var gross   int64
+	var taxes   map[string]*[2]int64 = map[string]*[2]int64{}
+	var taxSum  int64
+	var series  string
+	var number  string
+
+	switch {
+	case len(FacturaeSeller.TaxID)==0 || len(FacturaeSeller.Name)==0:
+		return nil, fmt.Errorf("seller not configured, set FACTURAE_SELLER_*")
+	case len(inv.Number)==0:
+		return nil, fmt.Errorf("%s: the invoice is not finalized", inv.ID)
+	case inv.Currency != stripe.CurrencyEUR:
+		return nil, fmt.Errorf("%s: only EUR invoices are supported", inv.ID)
+	case inv.Lines == nil:
+		return nil, fmt.Errorf("%s: invoice without lines", inv.ID)
+	}
+	buyer, err = facturaeBuyer(inv)
+	if err != nil {
+		return
+	}
+	if inv.Lines.HasMore {
+		inv.Lines.Data, err = InvoiceLines(inv.ID)
+		if err != nil {
+			return
+		}
+	}
+
+	/* Lines. */
+	for _, l := range inv.Lines.Data {
+		var line     facturaeInvoiceLine
+		var discount int64
+		for _, d := range l.DiscountAmounts {
+			discount += d.Amount
+		}
+		quantity := l.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		line.ItemDescription     = l.Description
+		line.Quantity            = fmt.Sprintf("%v.0", quantity)
+		line.UnitOfMeasure       = "01"
+		line.UnitPriceWithoutTax = fmt.Sprintf("%.8f", float64(l.AmountExcludingTax) / float64(quantity) / 100)
+		line.TotalCost           = facturaeCents(l.AmountExcludingTax)
+		line.GrossAmount         = facturaeCents(l.AmountExcludingTax - discount)
+		if discount > 0 {
+			line.Discounts = &facturaeDiscount{ "Descuento", facturaeCents(discount) }
+		}
+		for _, t := range l.TaxAmounts {
+			var rate *stripe.TaxRate
+			if t.TaxRate == nil {
+				continue
+			}
+			rate, err = TaxRateFetch(t.TaxRate.ID)
+			if err != nil {
+				return
+			}
+			r := fmt.Sprintf("%.2f", rate.Percentage)
+			line.TaxesOutputs = append(line.TaxesOutputs, facturaeTax{
+				TaxTypeCode: "01",
+				TaxRate:     r,
+				TaxableBase: facturaeAmount{ facturaeCents(l.AmountExcludingTax - discount) },
+				TaxAmount:   facturaeAmount{ facturaeCents(t.Amount) },
+			})
+			if taxes[r] == nil {
+				taxes[r] = &[2]int64{}
+			}
+			taxes[r][0] += l.AmountExcludingTax - discount
+			taxes[r][1] += t.Amount
+			taxSum      += t.Amount
+		}
+		gross += l.AmountExcludingTax - discount
+		fi.Items = append(fi.Items, line)
+	}
+	if gross + taxSum != inv.Total {
+		return nil, fmt.Errorf("%s: totals mismatch (%v + %v != %v)", inv.ID, gross, taxSum, inv.Total)
+	}
+	rates := []string{}
+	for r := range taxes {
+		rates = append(rates, r)
+	}
+	sort.Strings(rates)
+	for _, r := range rates {
+		fi.TaxesOutputs = append(fi.TaxesOutputs, facturaeTax{
+			TaxTypeCode: "01",
+			TaxRate:     r,
+			TaxableBase: facturaeAmount{ facturaeCents(taxes[r][0]) },
+			TaxAmount:   facturaeAmount{ facturaeCents(taxes[r][1]) },
+		})
+	}
+
+	/* Header and totals. */
+	if i := strings.LastIndex(inv.Number, "-"); i > 0 {
+		series, number = inv.Number[:i], inv.Number[i+1:]
+	} else {
+		number = inv.Number
+	}
+	fi.InvoiceNumber       = number
+	fi.InvoiceSeriesCode   = series
+	fi.InvoiceDocumentType = "FC"
+	fi.InvoiceClass        = "OO"
+	fi.IssueDate           = time.Unix(inv.Created, 0).Format("2006-01-02")
+	fi.InvoiceCurrencyCode = "EUR"
+	fi.TaxCurrencyCode     = "EUR"
+	fi.LanguageName        = "es"
+	fi.Totals = facturaeInvoiceTotals{
+		TotalGrossAmount:            facturaeCents(gross),
+		TotalGrossAmountBeforeTaxes: facturaeCents(gross),
+		TotalTaxOutputs:             facturaeCents(taxSum),
+		TotalTaxesWithheld:          facturaeCents(0),
+		InvoiceTotal:                facturaeCents(inv.Total),
+		TotalOutstandingAmount:      facturaeCents(inv.Total),
+		TotalExecutableAmount:       facturaeCents(inv.Total),
+	}
+
+	f.XmlnsFe = "http://www.facturae.gob.es/formato/Versiones/Facturaev3_2_2.xml"
+	f.XmlnsDs = "http://www.w3.org/2000/09/xmldsig#"
+	f.FileHeader = facturaeFileHeader{
+		SchemaVersion:     "3.2.2",
+		Modality:          "I",
+		InvoiceIssuerType: "EM",
+		Batch: facturaeBatch{
+			BatchIdentifier:        FacturaeSeller.TaxID + inv.Number,
+			InvoicesCount:          1,
+			TotalInvoicesAmount:    facturaeAmount{ facturaeCents(inv.Total) },
+			TotalOutstandingAmount: facturaeAmount{ facturaeCents(inv.Total) },
+			TotalExecutableAmount:  facturaeAmount{ facturaeCents(inv.Total) },
+			InvoiceCurrencyCode:    "EUR",
+		},
+	}
+	f.Parties.SellerParty = facturaeNewParty(FacturaeSeller)
+	f.Parties.BuyerParty  = buyer
+	f.Invoices = []facturaeInvoice{ fi }
+
+	doc, err = xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return
+	}
+	return append([]byte(xml.Header), append(doc, '\n')...), nil
+}
+
+// FacturaeCheck checks a document against FacturaeSchema with
+// xmllint(1). The bundled schema is a hand-written subset of the
+// official one that only checks the structure written by
+// FacturaeExport, it is not a Facturae validation.
+func FacturaeCheck(doc []byte) (err error) {
+	var dir string
+	if dir, err = os.MkdirTemp("", "ustripe-facturae"); err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	schema := FacturaeSchema
+	if len(schema)==0 {
+		schema = filepath.Join(dir, "facturae-subset.xsd")
+		if err = os.WriteFile(schema, facturaeXSD, 0600); err != nil {
+			return
+		}
+	}
+	file := filepath.Join(dir, "facturae.xml")
+	if err = os.WriteFile(file, doc, 0600); err != nil {
+		return
+	}
+	cmd    := exec.Command("xmllint", "--noout", "--schema", schema, file)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil && stderr.Len()>0 {
+		msg := strings.ReplaceAll(strings.TrimSpace(stderr.String()), file + ":", "line ")
+		return fmt.Errorf("schema check failed: %s", msg)
+	}
+	return
+}
+
+func facturaeBuyer(inv *stripe.Invoice) (p facturaeParty, err error) {
+	var party FacturaeParty
+	for _, t := range inv.CustomerTaxIDs {
+		if t.Type != nil && (*t.Type == stripe.TaxIDTypeESCIF || *t.Type == stripe.TaxIDTypeEUVAT) {
+			party.TaxID = t.Value
+			break
+		}
+	}
+	if len(party.TaxID)==0 {
+		return p, fmt.Errorf("%s: the customer has no es_cif/eu_vat tax ID", inv.ID)
+	}
+	party.Name = inv.CustomerName
+	if len(party.Name)==0 {
+		return p, fmt.Errorf("%s: the customer has no name", inv.ID)
+	}
+	if a := inv.CustomerAddress; a != nil {
+		party.Address  = strings.TrimSpace(a.Line1 + " " + a.Line2)
+		party.PostCode = a.PostalCode
+		party.Town     = a.City
+		party.Province = a.State
+		party.Country  = a.Country
+	}
+	if len(party.Address)==0 || len(party.Country)==0 {
+		return p, fmt.Errorf("%s: the customer has no address", inv.ID)
+	}
+	return facturaeNewParty(party), nil
+}
+
+func facturaeNewParty(party FacturaeParty) (p facturaeParty) {
+	country, found := facturaeCountries[strings.ToUpper(party.Country)]
+	if !found {
+		country = strings.ToUpper(party.Country)
+	}
+	p.PersonTypeCode          = "J"
+	p.TaxIdentificationNumber = party.TaxID
+	p.CorporateName           = party.Name
+	if country == "ESP" {
+		p.ResidenceTypeCode = "R"
+		p.AddressInSpain = &facturaeAddressSpain{
+			Address:     party.Address,
+			PostCode:    party.PostCode,
+			Town:        party.Town,
+			Province:    party.Province,
+			CountryCode: country,
+		}
+	} else {
+		p.ResidenceTypeCode = "E"
+		if facturaeEU[strings.ToUpper(party.Country)] {
+			p.ResidenceTypeCode = "U"
+		}
+		p.OverseasAddress = &facturaeAddressOverseas{
+			Address:         party.Address,
+			PostCodeAndTown: strings.TrimSpace(party.PostCode + " " + party.Town),
+			Province:        party.Province,
+			CountryCode:     country,
+		}
+	}
+	return
+}
+
+func facturaeCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,69 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func testFacturaeInvoice() *stripe.Invoice {
+	cif := stripe.TaxIDTypeESCIF
+	return &stripe.Invoice{
+		ID:       "in_1",
+		Number:   "ABCD-0001",
+		Created:  1672531200,
+		Currency: stripe.CurrencyEUR,
+		Total:    12100,
+		CustomerName:    "Cliente SL",
+		CustomerTaxIDs:  []*stripe.InvoiceCustomerTaxID{ { Type: &cif, Value: "B00000000" } },
+		CustomerAddress: &stripe.Address{ Line1: "Kalea 1", PostalCode: "48001", City: "Bilbao", State: "Bizkaia", Country: "ES" },
+		Lines: &stripe.InvoiceLineItemList{ Data: []*stripe.InvoiceLineItem{ {
+			Description:        "Plan pro",
+			Quantity:           2,
+			AmountExcludingTax: 10000,
+			TaxAmounts:         []*stripe.InvoiceTotalTaxAmount{ { Amount: 2100, TaxRate: &stripe.TaxRate{ ID: "txr_iva" } } },
+		} } },
+	}
+}
+
+func TestFacturaeExport(t *testing.T) {
+	defer func(s FacturaeParty) { FacturaeSeller = s }(FacturaeSeller)
+	FacturaeSeller = FacturaeParty{ TaxID: "B11111111", Name: "Vendedor SL", Address: "Kalea 2",
+		PostCode: "20001", Town: "Donostia", Province: "Gipuzkoa", Country: "ES" }
+	cachePut("taxrate", "txr_iva", &stripe.TaxRate{ ID: "txr_iva", Percentage: 21 })
+	defer CacheInvalidate("taxrate", "txr_iva")
+
+	doc, err := FacturaeExport(testFacturaeInvoice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"<InvoiceNumber>0001</InvoiceNumber>",
+		"<InvoiceSeriesCode>ABCD</InvoiceSeriesCode>",
+		"<IssueDate>2023-01-01</IssueDate>",
+		"<TaxRate>21.00</TaxRate>",
+		"<UnitPriceWithoutTax>50.00000000</UnitPriceWithoutTax>",
+		"<InvoiceTotal>121.00</InvoiceTotal>",
+		"<TaxIdentificationNumber>B00000000</TaxIdentificationNumber>",
+	} {
+		if !strings.Contains(string(doc), s) {
+			t.Errorf("missing %s in:\n%s", s, doc)
+		}
+	}
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		t.Skip("xmllint not installed")
+	}
+	if err = FacturaeCheck(doc); err != nil {
+		t.Errorf("check: %v", err)
+	}
+	if err = FacturaeCheck([]byte(strings.Replace(string(doc), "<Modality>I</Modality>", "<Modality>X</Modality>", 1))); err == nil {
+		t.Errorf("invalid document passed the check")
+	}
+
+	inv := testFacturaeInvoice()
+	inv.Total = 100
+	if _, err = FacturaeExport(inv); err == nil {
+		t.Errorf("totals mismatch not detected")
+	}
+}
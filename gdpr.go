@@ -0,0 +1,473 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/customer"
+	"github.com/stripe/stripe-go/v73/taxid"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+// UserData is the data kept about a user, see UserDataExport.
+type UserData struct {
+	ExportedAt    int64                  `json:"exported_at"`
+	Email         string                 `json:"email"`
+	Customer      *stripe.Customer       `json:"customer,omitempty"`
+	TaxIDs        []*stripe.TaxID        `json:"tax_ids,omitempty"`
+	Subscriptions []*stripe.Subscription `json:"subscriptions,omitempty"`
+	Invoices      []*stripe.Invoice      `json:"invoices,omitempty"`
+	Events        []*stripe.Event        `json:"events,omitempty"`
+	Usage         []Usage                `json:"usage,omitempty"`
+	Dunning       []*Dunning             `json:"dunning,omitempty"`
+}
+
+// UserEraseOptions configure UserErase.
+type UserEraseOptions struct {
+	Anonymize bool // Keep the customer with its invoices, without personal data.
+	Dry       bool // Only list the actions, the receipt is not signed.
+}
+
+// ErasureReceipt proves an erasure was done without keeping personal
+// data, only the HMAC-SHA256 of the email keyed with ErasureKey, that
+// can't be matched by hashing known emails without the key. It is
+// signed with ErasureKey, an unsigned receipt marks an erasure in
+// progress.
+type ErasureReceipt struct {
+	EmailHash string   `json:"email_hmac"`
+	Customer  string   `json:"customer,omitempty"`
+	ErasedAt  int64    `json:"erased_at"`
+	Actions   []string `json:"actions"`
+	Signature string   `json:"signature,omitempty"`
+}
+
+// UserEraseHook is run by UserErase after the local data is scrubbed,
+// it can be used to remove the user from mail logs and other systems.
+var UserEraseHook func (email, customerID string) (err error) = nil
+
+var erasureMutex   sync.Mutex
+var erasedKnown    map[string]bool = nil
+var erasedModTime  time.Time
+
+// UserDataExport returns all the data kept about the user in Stripe
+// and in the local event store, usage buffer and dunning file.
+func UserDataExport(email string) (d *UserData, err error) {
+	var found bool
+	d = &UserData{ ExportedAt: time.Now().Unix(), Email: email }
+	if d.Customer, found = UserSearch(email); found {
+		p := &stripe.CustomerParams{}
+		p.AddExpand("tax_ids")
+		if d.Customer, err = customer.Get(d.Customer.ID, p); err != nil {
+			return
+		}
+		if d.Customer.TaxIDs != nil {
+			d.TaxIDs = d.Customer.TaxIDs.Data
+		}
+		if d.Subscriptions, err = UserSubs(d.Customer.ID); err != nil {
+			return
+		}
+		if d.Invoices, err = InvoiceList(email, "", time.Time{}); err != nil {
+			return
+		}
+	}
+	if len(EventsFile)>0 {
+		err = eventsScan(func (e *stripe.Event) bool {
+			if userEvent(e, email, d.customerID()) {
+				d.Events = append(d.Events, e)
+			}
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+	if d.Usage, err = userUsage(email, false); err != nil {
+		return
+	}
+	d.Dunning, err = DunningStatus(email)
+	return
+}
+
+// UserErase cancels the subscriptions of the user, deletes or
+// anonymizes the customer (also in the mirror) and scrubs the event
+// store, cache, dead letters, usage buffer and dunning file. The
+// customer is marked in ErasureFile before touching Stripe, so that
+// the events received meanwhile are stored redacted, and the signed
+// receipt is appended at the end.
+func UserErase(email string, o UserEraseOptions) (r *ErasureReceipt, err error) {
+	var user  *stripe.Customer
+	var found  bool
+	var subs []*stripe.Subscription
+	var n      int
+
+	if len(ErasureKey)==0 && !o.Dry {
+		return nil, fmt.Errorf("set USTRIPE_ERASURE_KEY to sign erasure receipts")
+	}
+	r = &ErasureReceipt{ EmailHash: erasureEmailHash(email), ErasedAt: time.Now().Unix() }
+	done := func (format string, args ...interface{}) {
+		r.Actions = append(r.Actions, fmt.Sprintf(format, args...))
+	}
+
+	/* Stripe. */
+	if user, found = UserSearch(email); found {
+		r.Customer = user.ID
+		if subs, err = UserSubs(user.ID); err != nil {
+			return
+		}
+	}
+	if !o.Dry {
+		if err = erasureAdd(r); err != nil {
+			return
+		}
+	}
+	for _, s := range subs {
+		if s.Status == stripe.SubscriptionStatusCanceled || s.Status == stripe.SubscriptionStatusIncompleteExpired {
+			continue
+		}
+		if !o.Dry {
+			if _, err = SubscriptionCancel(s.ID, false); err != nil {
+				return
+			}
+		}
+		done("subscription %s canceled", s.ID)
+	}
+	if found && o.Anonymize {
+		if !o.Dry {
+			if err = userAnonymize(user, r.EmailHash); err != nil {
+				return
+			}
+		}
+		done("customer %s anonymized", user.ID)
+	} else if found {
+		if !o.Dry {
+			if _, err = UserDel(email); err != nil {
+				return
+			}
+		}
+		done("customer %s deleted", user.ID)
+	}
+
+	/* Local data. */
+	if n, err = userEventsRedact(email, r.Customer, o.Dry); err != nil {
+		return
+	} else if n > 0 {
+		done("%d stored events redacted", n)
+	}
+	if n, err = userDeadLettersRemove(email, r.Customer, o.Dry); err != nil {
+		return
+	} else if n > 0 {
+		done("%d dead letters removed", n)
+	}
+	if !o.Dry {
+		CacheInvalidate("user", email)
+	}
+	if usage, uerr := userUsage(email, !o.Dry); uerr != nil {
+		return r, uerr
+	} else if len(usage)>0 {
+		done("%d buffered usage records removed", len(usage))
+	}
+	if n, err = userDunningRemove(email, r.Customer, o.Dry); err != nil {
+		return
+	} else if n > 0 {
+		done("%d dunning cases removed", n)
+	}
+	if UserEraseHook != nil {
+		if !o.Dry {
+			if err = UserEraseHook(email, r.Customer); err != nil {
+				return
+			}
+		}
+		done("erase hook run")
+	}
+	if o.Dry {
+		return
+	}
+
+	/* Receipt. */
+	r.Signature = r.sign()
+	return r, erasureLog(r)
+}
+
+// Verify checks the receipt was signed with ErasureKey.
+func (r *ErasureReceipt) Verify() bool {
+	return len(ErasureKey)>0 && hmac.Equal([]byte(r.Signature), []byte(r.sign()))
+}
+
+// ErasureReceiptPrintREC prints the receipt in recutils format.
+func ErasureReceiptPrintREC(r *ErasureReceipt) {
+	fmt.Printf("EmailHMAC: %s\n", r.EmailHash)
+	if len(r.Customer)>0 {
+		fmt.Printf("Customer: %s\n", r.Customer)
+	}
+	fmt.Printf("ErasedAt: %s\n", time.Unix(r.ErasedAt, 0).Format(time.RFC3339))
+	for _, a := range r.Actions {
+		fmt.Printf("Action: %s\n", a)
+	}
+	if len(r.Signature)>0 {
+		fmt.Printf("Signature: %s\n", r.Signature)
+	}
+	fmt.Printf("\n")
+}
+
+func (r *ErasureReceipt) sign() string {
+	c := *r
+	c.Signature = ""
+	data, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, []byte(ErasureKey))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func erasureEmailHash(email string) string {
+	mac := hmac.New(sha256.New, []byte(ErasureKey))
+	mac.Write([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *UserData) customerID() string {
+	if d.Customer == nil {
+		return ""
+	}
+	return d.Customer.ID
+}
+
+// userAnonymize removes the personal data and tax IDs of the customer,
+// the email is replaced with one derived from its hash.
+func userAnonymize(user *stripe.Customer, hash string) (err error) {
+	var c *stripe.Customer
+	p := &stripe.CustomerParams{}
+	p.Email       = stripe.String("erased-" + hash[:16] + "@example.com")
+	p.Name        = stripe.String("")
+	p.Phone       = stripe.String("")
+	p.Description = stripe.String("")
+	p.AddExtra("address" , "")
+	p.AddExtra("shipping", "")
+	for k := range user.Metadata {
+		p.AddMetadata(k, "")
+	}
+	p.AddMetadata("erased_at", fmt.Sprint(time.Now().Unix()))
+	for i := taxid.List(&stripe.TaxIDListParams{ Customer: stripe.String(user.ID) }); i.Next(); {
+		if _, err = taxid.Del(i.TaxID().ID, &stripe.TaxIDParams{ Customer: stripe.String(user.ID) }); err != nil {
+			return
+		}
+	}
+	if c, err = customer.Update(user.ID, p); err != nil {
+		return
+	}
+	CacheInvalidate("user", user.Email)
+	mirrorPutCustomer(c)
+	return
+}
+
+func userEvent(e *stripe.Event, email, customerID string) bool {
+	return (len(customerID)>0 && EventCustomer(e) == customerID) ||
+		(len(email)>0 && strings.EqualFold(eventEmail(e), email))
+}
+
+// eventRedacted returns a copy of the event without its data.
+func eventRedacted(e *stripe.Event) *stripe.Event {
+	return &stripe.Event{
+		ID:         e.ID,
+		Object:     e.Object,
+		Type:       e.Type,
+		Created:    e.Created,
+		Livemode:   e.Livemode,
+		APIVersion: e.APIVersion,
+	}
+}
+
+// userEventsRedact rewrites EventsFile without the data of the events
+// of the user, so that they are still known as received. When dry the
+// events are only counted.
+func userEventsRedact(email, customerID string, dry bool) (n int, err error) {
+	var data []byte
+	if len(EventsFile)==0 {
+		return
+	}
+	unlock, err := eventsLock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	err = eventsScan(func (e *stripe.Event) bool {
+		if userEvent(e, email, customerID) {
+			e = eventRedacted(e)
+			n++
+		}
+		line, _ := json.Marshal(e)
+		data = append(append(data, line...), '\n')
+		return true
+	})
+	if err != nil || n == 0 || dry {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(EventsFile), "." + filepath.Base(EventsFile) + ".tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmp, EventsFile)
+	return
+}
+
+func userDeadLettersRemove(email, customerID string, dry bool) (n int, err error) {
+	var files []string
+	if len(DeadLetterDirectory)==0 {
+		return
+	}
+	files, err = filepath.Glob(filepath.Join(DeadLetterDirectory, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		var e stripe.Event
+		data, rerr := os.ReadFile(file)
+		if rerr != nil || json.Unmarshal(data, &e) != nil || !userEvent(&e, email, customerID) {
+			continue
+		}
+		if n++; dry {
+			continue
+		}
+		if err = os.Remove(file); err != nil {
+			return
+		}
+		os.Remove(strings.TrimSuffix(file, ".json") + ".log")
+	}
+	return
+}
+
+// userUsage returns the buffered usage of the user, when remove is
+// set the records are removed from UsageFile.
+func userUsage(email string, remove bool) (usage []Usage, err error) {
+	var data []byte
+	var all  []Usage
+	var keep []byte
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	data, err = os.ReadFile(UsageFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	if all, err = usageParse(data); err != nil {
+		return
+	}
+	for _, u := range all {
+		if strings.EqualFold(u.Email, email) {
+			usage = append(usage, u)
+		} else {
+			line, _ := json.Marshal(u)
+			keep = append(append(keep, line...), '\n')
+		}
+	}
+	if remove && len(usage)>0 {
+		err = os.WriteFile(UsageFile, keep, 0600)
+	}
+	return
+}
+
+func userDunningRemove(email, customerID string, dry bool) (n int, err error) {
+	var ds map[string]*Dunning
+	dunningMutex.Lock()
+	defer dunningMutex.Unlock()
+	if ds, err = dunningLoad(); err != nil {
+		return
+	}
+	for id, d := range ds {
+		if (len(customerID)>0 && d.Customer == customerID) || strings.EqualFold(d.Email, email) {
+			delete(ds, id)
+			n++
+		}
+	}
+	if n > 0 && !dry {
+		err = dunningSave(ds)
+	}
+	return
+}
+
+// erasureAdd appends the unsigned receipt to ErasureFile, so that
+// EventStore, also in the webhook process, redacts the events of the
+// customer while it is erased.
+func erasureAdd(r *ErasureReceipt) (err error) {
+	if len(r.Customer)==0 || len(ErasureFile)==0 {
+		return
+	}
+	if err = erasureLog(&ErasureReceipt{ EmailHash: r.EmailHash, Customer: r.Customer, ErasedAt: r.ErasedAt }); err != nil {
+		return
+	}
+	erasureMutex.Lock()
+	defer erasureMutex.Unlock()
+	erasureLoad()
+	erasedKnown[r.Customer] = true
+	return
+}
+
+// erasureLog appends the receipt to ErasureFile.
+func erasureLog(r *ErasureReceipt) (err error) {
+	var data []byte
+	var fp   *os.File
+	if len(ErasureFile)==0 {
+		return
+	}
+	if data, err = json.Marshal(r); err != nil {
+		return
+	}
+	erasureMutex.Lock()
+	defer erasureMutex.Unlock()
+	fp, err = os.OpenFile(ErasureFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	_, err = fp.Write(append(data, '\n'))
+	return
+}
+
+// erased returns true if the customer was erased, according to the
+// receipts in ErasureFile.
+func erased(customerID string) bool {
+	if len(customerID)==0 || len(ErasureFile)==0 {
+		return false
+	}
+	erasureMutex.Lock()
+	defer erasureMutex.Unlock()
+	erasureLoad()
+	return erasedKnown[customerID]
+}
+
+// erasureLoad reads ErasureFile when it changed, receipts can be
+// written by other processes.
+func erasureLoad() {
+	info, err := os.Stat(ErasureFile)
+	if err != nil {
+		if erasedKnown == nil {
+			erasedKnown = map[string]bool{}
+		}
+		return
+	}
+	if erasedKnown != nil && info.ModTime().Equal(erasedModTime) {
+		return
+	}
+	data, err := os.ReadFile(ErasureFile)
+	if err != nil {
+		return
+	}
+	known := map[string]bool{}
+	for c := range erasedKnown {
+		known[c] = true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var r ErasureReceipt
+		if json.Unmarshal([]byte(line), &r) == nil && len(r.Customer)>0 {
+			known[r.Customer] = true
+		}
+	}
+	erasedKnown, erasedModTime = known, info.ModTime()
+}
@@ -0,0 +1,127 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"os"
+)
+
+func testEvent(id, typ string, object map[string]interface{}) *stripe.Event {
+	raw, _ := json.Marshal(object)
+	return &stripe.Event{ ID: id, Type: typ, Created: 1, Data: &stripe.EventData{ Object: object, Raw: raw } }
+}
+
+func TestUserErasureLocal(t *testing.T) {
+	dir := t.TempDir()
+	defer func(e, u, d, f string) { EventsFile, UsageFile, DunningFile, ErasureFile = e, u, d, f }(EventsFile, UsageFile, DunningFile, ErasureFile)
+	EventsFile  = filepath.Join(dir, "events.jsonl")
+	UsageFile   = filepath.Join(dir, "usage.jsonl")
+	DunningFile = filepath.Join(dir, "dunning.json")
+	ErasureFile = filepath.Join(dir, "erasures.jsonl")
+	eventsKnown, erasedKnown = nil, nil
+
+	EventStore(testEvent("evt_1", "customer.updated", map[string]interface{}{ "object": "customer", "id": "cus_1", "email": "a@b.c" }))
+	EventStore(testEvent("evt_2", "invoice.paid", map[string]interface{}{ "object": "invoice", "customer": "cus_2" }))
+	UsageBuffer("a@b.c", "prod_1", 1, time.Unix(1, 0), "increment")
+	UsageBuffer("d@e.f", "prod_1", 1, time.Unix(1, 0), "increment")
+	dunningSave(map[string]*Dunning{ "sub_1": { Customer: "cus_1", Email: "a@b.c" } })
+
+	/* Dry runs only count. */
+	if n, err := userEventsRedact("a@b.c", "cus_1", true); n != 1 || err != nil {
+		t.Fatalf("events to redact: %d %v", n, err)
+	}
+	if usage, err := userUsage("a@b.c", false); len(usage) != 1 || err != nil {
+		t.Fatalf("usage to remove: %v %v", usage, err)
+	}
+	if n, err := userDunningRemove("a@b.c", "cus_1", true); n != 1 || err != nil {
+		t.Fatalf("dunning to remove: %d %v", n, err)
+	}
+	if data, _ := os.ReadFile(EventsFile); !strings.Contains(string(data), "a@b.c") {
+		t.Fatalf("events redacted on dry run: %s", data)
+	}
+
+	if n, err := userEventsRedact("a@b.c", "cus_1", false); n != 1 || err != nil {
+		t.Fatalf("events redacted: %d %v", n, err)
+	}
+	if usage, err := userUsage("A@b.c", true); len(usage) != 1 || err != nil {
+		t.Fatalf("usage removed: %v %v", usage, err)
+	}
+	if n, err := userDunningRemove("a@b.c", "cus_1", false); n != 1 || err != nil {
+		t.Fatalf("dunning removed: %d %v", n, err)
+	}
+	data, _ := os.ReadFile(EventsFile)
+	if strings.Contains(string(data), "a@b.c") || !strings.Contains(string(data), "evt_1") || !strings.Contains(string(data), "cus_2") {
+		t.Errorf("events not redacted: %s", data)
+	}
+	if data, _ = os.ReadFile(UsageFile); strings.Contains(string(data), "a@b.c") || !strings.Contains(string(data), "d@e.f") {
+		t.Errorf("usage not removed: %s", data)
+	}
+
+	/* Later events of an erased customer are stored redacted. */
+	erasureAdd(&ErasureReceipt{ Customer: "cus_1" })
+	erasedKnown = nil
+	if !erased("cus_1") {
+		t.Fatalf("erasure marker not written to %s", ErasureFile)
+	}
+	EventStore(testEvent("evt_3", "customer.deleted", map[string]interface{}{ "object": "customer", "id": "cus_1", "email": "a@b.c" }))
+	if data, _ = os.ReadFile(EventsFile); strings.Contains(string(data), "a@b.c") || !strings.Contains(string(data), "evt_3") {
+		t.Errorf("new event not redacted: %s", data)
+	}
+}
+
+func TestErasureReceipt(t *testing.T) {
+	defer func(k string) { ErasureKey = k }(ErasureKey)
+	ErasureKey = "key"
+	r := &ErasureReceipt{ EmailHash: "00", Customer: "cus_1", ErasedAt: 1, Actions: []string{ "customer cus_1 deleted" } }
+	r.Signature = r.sign()
+	if !r.Verify() {
+		t.Fatalf("receipt not verified")
+	}
+	r.Actions = nil
+	if r.Verify() {
+		t.Errorf("modified receipt verified")
+	}
+	h := erasureEmailHash("A@b.c")
+	if h != erasureEmailHash("a@b.c") {
+		t.Errorf("email hash depends on the case")
+	}
+	ErasureKey = "other"
+	if h == erasureEmailHash("a@b.c") {
+		t.Errorf("email hash not keyed")
+	}
+}
+
+func TestEventsLock(t *testing.T) {
+	defer func(e string) { EventsFile = e }(EventsFile)
+	EventsFile  = filepath.Join(t.TempDir(), "events.jsonl")
+	eventsKnown = nil
+
+	/* Another process holds the lock. */
+	fp, err := os.OpenFile(EventsFile + ".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if err = syscall.Flock(int(fp.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func () {
+		_, err := EventStore(testEvent("evt_1", "invoice.paid", map[string]interface{}{ "object": "invoice" }))
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatalf("event stored while locked")
+	case <-time.After(200 * time.Millisecond):
+	}
+	syscall.Flock(int(fp.Fd()), syscall.LOCK_UN)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,8 +3,9 @@ module github.com/harkaitz/ustripe
 go 1.18
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/pborman/getopt/v2 v2.1.0 // indirect
-	github.com/stripe/stripe-go/v73 v73.12.0 // indirect
-	golang.org/x/crypto v0.0.0-20221012134737-56aed061732a // indirect
+	github.com/google/uuid v1.3.0
+	github.com/pborman/getopt/v2 v2.1.0
+	github.com/stripe/stripe-go/v73 v73.12.0
 )
+
+require golang.org/x/crypto v0.0.0-20221012134737-56aed061732a // indirect
@@ -0,0 +1,284 @@
+package ustripe
+
+import (
+	"encoding/json"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"bytes"
+	"bufio"
+	"sync"
+	"time"
+	"fmt"
+	"io"
+	"os"
+)
+
+// UserImportOptions configure UserImport.
+type UserImportOptions struct {
+	Update       bool    // Update existing users instead of skipping them.
+	Workers      int     // Users imported in parallel.
+	Rate         float64 // Users per second, 0 for no limit.
+	ProgressFile string  // Imported users are skipped when resuming.
+}
+
+// UserImportResult is the outcome of importing or restoring a user:
+// created, updated, skipped, valid or error.
+type UserImportResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// UserImportRecord is a user to import, the keys are "email",
+// "password" or "password_hash", UserEditKeys and "@KEY" metadata.
+type UserImportRecord struct {
+	Line   int
+	Fields map[string]string
+}
+
+// UserImportRead reads the users from a CSV file with a header or
+// a JSON array/stream of objects. Columns are renamed with the columns
+// map and checked.
+func UserImportRead(r io.Reader, format string, columns map[string]string) (recs []UserImportRecord, err error) {
+	switch format {
+	case "csv":
+		var header, row []string
+		cr := csv.NewReader(r)
+		if header, err = cr.Read(); err != nil {
+			return nil, err
+		}
+		for line := 2; ; line++ {
+			if row, err = cr.Read(); err == io.EOF {
+				err = nil
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			rec := UserImportRecord{ line, map[string]string{} }
+			for i, col := range header {
+				if i < len(row) && len(row[i])>0 {
+					rec.Fields[col] = row[i]
+				}
+			}
+			recs = append(recs, rec)
+		}
+	case "json":
+		var data []byte
+		if data, err = io.ReadAll(bufio.NewReader(r)); err != nil {
+			return
+		}
+		var objs []map[string]interface{}
+		data = bytes.TrimSpace(data)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if len(data)>0 && data[0] == '[' {
+			err = dec.Decode(&objs)
+		} else {
+			for dec.More() {
+				var o map[string]interface{}
+				if err = dec.Decode(&o); err != nil {
+					break
+				}
+				objs = append(objs, o)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n, o := range objs {
+			rec := UserImportRecord{ n+1, map[string]string{} }
+			for k, v := range o {
+				switch v := v.(type) {
+				case nil:
+				case string:      rec.Fields[k] = v
+				case json.Number: rec.Fields[k] = v.String()
+				case bool:        rec.Fields[k] = strconv.FormatBool(v)
+				default:          return nil, fmt.Errorf("record %d: %s: must be a string", n+1, k)
+				}
+			}
+			recs = append(recs, rec)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+	for n, rec := range recs {
+		fields := map[string]string{}
+		for col, val := range rec.Fields {
+			key, found := columns[col]
+			if !found {
+				key = col
+			}
+			if !userImportKey(key) {
+				return nil, fmt.Errorf("line %d: unknown column: %s", rec.Line, col)
+			}
+			fields[key] = val
+		}
+		recs[n].Fields, rec.Fields = fields, fields
+		if len(rec.Fields["email"])==0 {
+			return nil, fmt.Errorf("line %d: missing email", rec.Line)
+		}
+	}
+	return
+}
+
+// UserImport creates the users, existing users are updated or skipped
+// and users in the progress file are skipped. The report function is
+// called for each user. It returns an error if any user failed.
+func UserImport(recs []UserImportRecord, o UserImportOptions, report func (r UserImportResult)) (err error) {
+	var done     map[string]bool
+	var progress *os.File
+	var mutex     sync.Mutex
+	var wg        sync.WaitGroup
+	var failed    int
+	var tick      <-chan time.Time
+
+	if done, err = userImportProgress(o.ProgressFile); err != nil {
+		return
+	}
+	if len(o.ProgressFile)>0 {
+		progress, err = os.OpenFile(o.ProgressFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return
+		}
+		defer progress.Close()
+	}
+	if o.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / o.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+
+	finish := func (r UserImportResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Status == "error" {
+			failed++
+		} else if progress != nil {
+			fmt.Fprintf(progress, "%s\t%s\n", r.Email, r.Status)
+		}
+		report(r)
+	}
+
+	jobs := make(chan UserImportRecord)
+	for w := 0; w < o.Workers; w++ {
+		wg.Add(1)
+		go func () {
+			defer wg.Done()
+			for rec := range jobs {
+				if tick != nil {
+					<-tick
+				}
+				r := UserImportResult{ Line: rec.Line, Email: rec.Fields["email"] }
+				var ierr error
+				r.Status, ierr = userImportOne(rec.Fields, o.Update)
+				if ierr != nil {
+					r.Status, r.Error = "error", ierr.Error()
+				}
+				finish(r)
+			}
+		}()
+	}
+	seen := map[string]bool{}
+	for _, rec := range recs {
+		email := strings.ToLower(rec.Fields["email"])
+		switch {
+		case done[email]:
+			finish(UserImportResult{ Line: rec.Line, Email: rec.Fields["email"], Status: "skipped", Error: "already imported" })
+		case seen[email]:
+			finish(UserImportResult{ Line: rec.Line, Email: rec.Fields["email"], Status: "skipped", Error: "duplicated in file" })
+		default:
+			seen[email] = true
+			jobs <- rec
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users failed", failed, len(recs))
+	}
+	return nil
+}
+
+func userImportOne(fields map[string]string, update bool) (status string, err error) {
+	email := fields["email"]
+	ops   := map[string]string{}
+	edit  := false
+	for k, v := range fields {
+		switch k {
+		case "email", "password", "password_hash":
+		case "language", "verified":
+			ops[k] = v
+		default:
+			ops[k], edit = v, edit || k[0] != '@'
+		}
+	}
+	if _, found := UserSearch(email); found {
+		if !update {
+			return "skipped", nil
+		}
+		if len(ops)>0 {
+			if _, err = UserEdit(email, ops); err != nil {
+				return
+			}
+		}
+		if hash := fields["password_hash"]; len(hash)>0 {
+			_, err = UserChangeHash(email, hash)
+		} else if password := fields["password"]; len(password)>0 {
+			_, err = UserChangePass(email, password)
+		}
+		return "updated", err
+	}
+	switch {
+	case len(fields["password_hash"])>0:
+		_, err = UserAddHash(email, fields["password_hash"], ops)
+	case len(fields["password"])>0:
+		_, err = UserAdd(email, fields["password"], ops)
+	default:
+		err = fmt.Errorf("missing password or password_hash")
+	}
+	if err == nil && edit {
+		_, err = UserEdit(email, ops)
+	}
+	return "created", err
+}
+
+func userImportKey(key string) bool {
+	switch key {
+	case "email", "password", "password_hash":
+		return true
+	}
+	if strings.HasPrefix(key, "@") && len(key)>1 {
+		return true
+	}
+	for _, k := range UserEditKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func userImportProgress(file string) (done map[string]bool, err error) {
+	var data []byte
+	done = map[string]bool{}
+	if len(file)==0 {
+		return
+	}
+	data, err = os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if email, _, found := strings.Cut(line, "\t"); found {
+			done[strings.ToLower(email)] = true
+		}
+	}
+	return
+}
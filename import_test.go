@@ -0,0 +1,40 @@
+package ustripe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserImportRead(t *testing.T) {
+	columns := map[string]string{ "mail": "email", "plan": "@plan" }
+	csv  := "mail,password_hash,city,plan\na@b.c,$6$s$h,Bilbao,pro\nd@e.f,,,\n"
+	json := `{"mail": "a@b.c", "password": "x", "plan": "pro"}` + "\n" + `{"mail": "d@e.f"}`
+	for format, data := range map[string]string{ "csv": csv, "json": json, "json ": "[" + strings.Replace(json, "\n", ",", 1) + "]" } {
+		recs, err := UserImportRead(strings.NewReader(data), strings.TrimSpace(format), columns)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(recs) != 2 || recs[0].Fields["email"] != "a@b.c" || recs[0].Fields["@plan"] != "pro" || recs[1].Fields["email"] != "d@e.f" {
+			t.Errorf("%s: unexpected records: %v", format, recs)
+		}
+		if len(recs[1].Fields) != 1 {
+			t.Errorf("%s: empty values not skipped: %v", format, recs[1].Fields)
+		}
+	}
+	recs, err := UserImportRead(strings.NewReader(`{"email": "a@b.c", "zipcode": "01001", "phone": 1000000, "verified": true}`), "json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := recs[0].Fields; f["zipcode"] != "01001" || f["phone"] != "1000000" || f["verified"] != "true" {
+		t.Errorf("json values not kept: %v", f)
+	}
+	if _, err := UserImportRead(strings.NewReader(`{"email": "a@b.c", "name": {"first": "John"}}`), "json", nil); err == nil {
+		t.Errorf("object value accepted")
+	}
+	if _, err := UserImportRead(strings.NewReader("email,foo\na@b.c,1\n"), "csv", nil); err == nil {
+		t.Errorf("unknown column accepted")
+	}
+	if _, err := UserImportRead(strings.NewReader("name\nJohn\n"), "csv", nil); err == nil {
+		t.Errorf("missing email accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/invoice"
+	"encoding/json"
+	"net/http"
+	"time"
+	"fmt"
+	"io"
+)
+
+// HTTPClient is used to download files (invoice PDFs) from Stripe.
+var HTTPClient *http.Client = http.DefaultClient
+
+// InvoiceList returns the invoices of a customer. Status and since
+// are optional.
+func InvoiceList(email, status string, since time.Time) (invoices []*stripe.Invoice, err error) {
+	id, found := UserID(email)
+	if !found {
+		return nil, fmt.Errorf("user not found")
+	}
+	p := &stripe.InvoiceListParams{}
+	p.Customer = stripe.String(id)
+	p.Filters.AddFilter("limit", "", "100")
+	if len(status)>0 {
+		p.Status = stripe.String(status)
+	}
+	if !since.IsZero() {
+		p.CreatedRange = &stripe.RangeQueryParams{ GreaterThanOrEqual: since.Unix() }
+	}
+	i := invoice.List(p)
+	for i.Next() {
+		invoices = append(invoices, i.Invoice())
+	}
+	return invoices, i.Err()
+}
+
+// InvoiceGet fetches an invoice.
+func InvoiceGet(id string) (inv *stripe.Invoice, err error) {
+	return invoice.Get(id, nil)
+}
+
+// InvoiceLines fetches all the lines of an invoice.
+func InvoiceLines(id string) (lines []*stripe.InvoiceLineItem, err error) {
+	p := &stripe.InvoiceListLinesParams{ Invoice: stripe.String(id) }
+	p.Filters.AddFilter("limit", "", "100")
+	i := invoice.ListLines(p)
+	for i.Next() {
+		lines = append(lines, i.InvoiceLineItem())
+	}
+	return lines, i.Err()
+}
+
+// InvoiceSend mails the invoice to the customer. Stripe only sends
+// invoices collected with send_invoice, the links to the rest are
+// mailed with InvoiceMail.
+func InvoiceSend(id string) (inv *stripe.Invoice, err error) {
+	inv, err = InvoiceGet(id)
+	if err != nil {
+		return
+	}
+	return invoiceSend(inv)
+}
+
+func invoiceSend(inv *stripe.Invoice) (*stripe.Invoice, error) {
+	if inv.CollectionMethod == stripe.InvoiceCollectionMethodSendInvoice {
+		return invoice.SendInvoice(inv.ID, nil)
+	}
+	if len(inv.HostedInvoiceURL)==0 {
+		return nil, fmt.Errorf("%s: the invoice has no link, is it a draft?", inv.ID)
+	}
+	if len(inv.CustomerEmail)==0 {
+		return nil, fmt.Errorf("%s: the customer has no email", inv.ID)
+	}
+	return inv, SendMail(InvoiceMail(inv, inv.CustomerEmail))
+}
+
+// InvoicePDF writes the PDF of a finalized invoice to w.
+func InvoicePDF(id string, w io.Writer) (err error) {
+	var inv *stripe.Invoice
+	inv, err = InvoiceGet(id)
+	if err != nil {
+		return
+	}
+	if len(inv.InvoicePDF)==0 {
+		return fmt.Errorf("%s: the invoice has no PDF, is it a draft?", id)
+	}
+	return Download(inv.InvoicePDF, w)
+}
+
+// Download writes the content of url to w using HTTPClient.
+func Download(url string, w io.Writer) (err error) {
+	var res *http.Response
+	res, err = HTTPClient.Get(url)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return
+}
+
+// InvoiceJSON returns the JSON representation.
+func InvoiceJSON(inv *stripe.Invoice) ([]byte, error) {
+	return json.Marshal(inv)
+}
+
+// InvoicePrint prints a line describing the invoice.
+func InvoicePrint(inv *stripe.Invoice) {
+	fmt.Printf(
+		"%-30s %-15s %s %-13s %s\n",
+		inv.ID,
+		inv.Number,
+		time.Unix(inv.Created, 0).Format("2006-01-02"),
+		inv.Status,
+		NewMoney(inv.Total, inv.Currency))
+}
+
+// InvoiceRecord returns the invoice as a Record for Renderer.
+func InvoiceRecord(inv *stripe.Invoice) Record {
+	return Record{
+		{ "id"      , inv.ID },
+		{ "number"  , inv.Number },
+		{ "created" , time.Unix(inv.Created, 0).Format("2006-01-02") },
+		{ "status"  , string(inv.Status) },
+		{ "currency", string(inv.Currency) },
+		{ "total"   , inv.Total },
+		{ "amount"  , NewMoney(inv.Total, inv.Currency).String() },
+	}
+}
+
+// InvoicePrintREC prints the invoice information to the terminal.
+func InvoicePrintREC(inv *stripe.Invoice) {
+	fmt.Printf("ID: %s\n", inv.ID)
+	if inv.Number != "" {
+		fmt.Printf("Number: %s\n", inv.Number)
+	}
+	fmt.Printf("Status: %s\n", inv.Status)
+	fmt.Printf("Date: %s\n", time.Unix(inv.Created, 0).Format(time.RFC3339))
+	if inv.Customer != nil {
+		fmt.Printf("Customer: %s\n", inv.Customer.ID)
+	}
+	if inv.CustomerEmail != "" {
+		fmt.Printf("Email: %s\n", inv.CustomerEmail)
+	}
+	if inv.CustomerName != "" {
+		fmt.Printf("Name: %s\n", inv.CustomerName)
+	}
+	if inv.Lines != nil {
+		for _, l := range inv.Lines.Data {
+			fmt.Printf("Line: %v x %s %s\n", l.Quantity, l.Description,
+				NewMoney(l.Amount, l.Currency))
+		}
+	}
+	fmt.Printf("Subtotal: %s\n", NewMoney(inv.Subtotal, inv.Currency))
+	fmt.Printf("Tax: %s\n"     , NewMoney(inv.Tax, inv.Currency))
+	fmt.Printf("Total: %s\n"   , NewMoney(inv.Total, inv.Currency))
+	fmt.Printf("Paid: %s\n"    , NewMoney(inv.AmountPaid, inv.Currency))
+	if inv.DueDate != 0 {
+		fmt.Printf("Due: %s\n", time.Unix(inv.DueDate, 0).Format("2006-01-02"))
+	}
+	if inv.HostedInvoiceURL != "" {
+		fmt.Printf("URL: %s\n", inv.HostedInvoiceURL)
+	}
+	if inv.InvoicePDF != "" {
+		fmt.Printf("PDF: %s\n", inv.InvoicePDF)
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,71 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"path/filepath"
+	"net/http"
+	"strings"
+	"bytes"
+	"testing"
+	"os"
+	"io"
+)
+
+type stubTransport map[string]string
+
+func (s stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, found := s[r.URL.String()]
+	res := &http.Response{ StatusCode: http.StatusOK, Status: "200 OK", Request: r }
+	if !found {
+		res.StatusCode, res.Status = http.StatusNotFound, "404 Not Found"
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func TestDownload(t *testing.T) {
+	HTTPClient = &http.Client{ Transport: stubTransport{
+		"https://pay.stripe.com/invoice/x/pdf": "%PDF-1.4",
+	}}
+	defer func () { HTTPClient = http.DefaultClient }()
+
+	out := bytes.Buffer{}
+	if err := Download("https://pay.stripe.com/invoice/x/pdf", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "%PDF-1.4" {
+		t.Fatalf("unexpected content: %q", out.String())
+	}
+	if err := Download("https://pay.stripe.com/invoice/y/pdf", &out); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
+
+func TestInvoiceSendMail(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "mail")
+	defer func (s string) { SendmailCommand = s }(SendmailCommand)
+	SendmailCommand = "cat > " + out
+
+	inv := &stripe.Invoice{
+		ID:               "in_1",
+		Number:           "A-0001",
+		CollectionMethod: stripe.InvoiceCollectionMethodChargeAutomatically,
+		CustomerEmail:    "a@b.c",
+		Currency:         "eur",
+		Total:            1210,
+	}
+	if _, err := invoiceSend(inv); err == nil {
+		t.Fatalf("expected error for invoice without link")
+	}
+	inv.HostedInvoiceURL = "https://invoice.stripe.com/i/x"
+	inv.InvoicePDF       = "https://pay.stripe.com/invoice/x/pdf"
+	if _, err := invoiceSend(inv); err != nil {
+		t.Fatal(err)
+	}
+	mail, _ := os.ReadFile(out)
+	for _, s := range []string{ "To: a@b.c", "A-0001", inv.HostedInvoiceURL, inv.InvoicePDF } {
+		if !strings.Contains(string(mail), s) {
+			t.Errorf("mail lacks %q:\n%s", s, mail)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/balancetransaction"
+	"github.com/stripe/stripe-go/v73/invoice"
+	"encoding/csv"
+	"time"
+	"sort"
+	"fmt"
+	"io"
+)
+
+// LedgerSummary holds the totals of a period and currency.
+type LedgerSummary struct {
+	Period      string
+	Currency    stripe.Currency
+	Gross       int64
+	Fees        int64
+	Refunds     int64
+	Adjustments int64
+	Net         int64
+	Payouts     int64
+	Taxes       map[string]int64 // Tax collected per tax rate.
+}
+
+// LedgerAccounts are the account names used in the journal format.
+var LedgerAccounts map[string]string = map[string]string{
+	"stripe":      "Assets:Stripe",
+	"bank":        "Assets:Bank",
+	"fees":        "Expenses:Stripe:Fees",
+	"sales":       "Income:Sales",
+	"refunds":     "Income:Refunds",
+	"adjustments": "Equity:Adjustments",
+}
+
+// LedgerTransactions returns the balance transactions created in
+// [from, to) in chronological order.
+func LedgerTransactions(from, to time.Time) (txs []*stripe.BalanceTransaction, err error) {
+	p := &stripe.BalanceTransactionListParams{}
+	p.CreatedRange = &stripe.RangeQueryParams{ GreaterThanOrEqual: from.Unix(), LesserThan: to.Unix() }
+	p.Filters.AddFilter("limit", "", "100")
+	i := balancetransaction.List(p)
+	for i.Next() {
+		txs = append(txs, i.BalanceTransaction())
+	}
+	sort.SliceStable(txs, func (a, b int) bool {
+		return txs[a].Created < txs[b].Created
+	})
+	return txs, i.Err()
+}
+
+// LedgerInvoices returns the paid invoices created in [from, to).
+func LedgerInvoices(from, to time.Time) (invs []*stripe.Invoice, err error) {
+	p := &stripe.InvoiceListParams{}
+	p.Status       = stripe.String("paid")
+	p.CreatedRange = &stripe.RangeQueryParams{ GreaterThanOrEqual: from.Unix(), LesserThan: to.Unix() }
+	p.Filters.AddFilter("limit", "", "100")
+	i := invoice.List(p)
+	for i.Next() {
+		invs = append(invs, i.Invoice())
+	}
+	return invs, i.Err()
+}
+
+// LedgerSummarize groups the transactions and the taxes of the paid
+// invoices by period ("month", "quarter" or "all") and currency.
+func LedgerSummarize(txs []*stripe.BalanceTransaction, invs []*stripe.Invoice, group string) (sums []*LedgerSummary, err error) {
+	idx := map[string]*LedgerSummary{}
+	get := func (created int64, currency stripe.Currency) *LedgerSummary {
+		period := ledgerPeriod(time.Unix(created, 0), group)
+		s, found := idx[period + "/" + string(currency)]
+		if !found {
+			s = &LedgerSummary{ Period: period, Currency: currency, Taxes: map[string]int64{} }
+			idx[period + "/" + string(currency)] = s
+			sums = append(sums, s)
+		}
+		return s
+	}
+	for _, tx := range txs {
+		s := get(tx.Created, tx.Currency)
+		s.Fees += tx.Fee
+		switch ledgerKind(tx.Type) {
+		case "sales":   s.Gross       += tx.Amount
+		case "refunds": s.Refunds     += tx.Amount
+		case "bank":    s.Payouts     += tx.Amount
+		case "fees":    s.Fees        -= tx.Amount
+		default:        s.Adjustments += tx.Amount
+		}
+		if ledgerKind(tx.Type) != "bank" {
+			s.Net += tx.Net
+		}
+	}
+	for _, inv := range invs {
+		s := get(inv.Created, inv.Currency)
+		for _, t := range inv.TotalTaxAmounts {
+			var label string
+			label, err = ledgerTaxLabel(t.TaxRate)
+			if err != nil {
+				return
+			}
+			s.Taxes[label] += t.Amount
+		}
+	}
+	sort.SliceStable(sums, func (a, b int) bool {
+		if sums[a].Period != sums[b].Period {
+			return sums[a].Period < sums[b].Period
+		}
+		return sums[a].Currency < sums[b].Currency
+	})
+	return
+}
+
+// LedgerWriteCSV writes the summaries in CSV, one tax column per rate.
+func LedgerWriteCSV(w io.Writer, sums []*LedgerSummary) (err error) {
+	labels := []string{}
+	seen   := map[string]bool{}
+	for _, s := range sums {
+		for l := range s.Taxes {
+			if !seen[l] {
+				labels, seen[l] = append(labels, l), true
+			}
+		}
+	}
+	sort.Strings(labels)
+
+	c := csv.NewWriter(w)
+	header := []string{ "period", "currency", "gross", "fees", "refunds", "adjustments", "net", "payouts" }
+	for _, l := range labels {
+		header = append(header, "tax:" + l)
+	}
+	c.Write(header)
+	for _, s := range sums {
+		m := func (v int64) string { return NewMoney(v, s.Currency).Plain() }
+		row := []string{ s.Period, string(s.Currency), m(s.Gross), m(s.Fees), m(s.Refunds),
+			m(s.Adjustments), m(s.Net), m(s.Payouts) }
+		for _, l := range labels {
+			row = append(row, m(s.Taxes[l]))
+		}
+		c.Write(row)
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// LedgerWriteJournal writes the transactions as double-entry journal
+// entries (ledger(1) format) using LedgerAccounts.
+func LedgerWriteJournal(w io.Writer, txs []*stripe.BalanceTransaction) (err error) {
+	for _, tx := range txs {
+		cur := string(tx.Currency)
+		m := func (v int64) string { return NewMoney(v, tx.Currency).Plain() }
+		desc := tx.Description
+		if len(desc)==0 {
+			desc = string(tx.Type)
+		}
+		_, err = fmt.Fprintf(w, "%s %s %s\n", time.Unix(tx.Created, 0).Format("2006/01/02"), tx.ID, desc)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "    %-30s %15s %s\n", LedgerAccounts["stripe"], m(tx.Net), cur)
+		if tx.Fee != 0 {
+			fmt.Fprintf(w, "    %-30s %15s %s\n", LedgerAccounts["fees"], m(tx.Fee), cur)
+		}
+		fmt.Fprintf(w, "    %-30s %15s %s\n\n", LedgerAccounts[ledgerKind(tx.Type)], m(-tx.Amount), cur)
+	}
+	return nil
+}
+
+func ledgerKind(t stripe.BalanceTransactionType) string {
+	switch t {
+	case stripe.BalanceTransactionTypeCharge, stripe.BalanceTransactionTypePayment:
+		return "sales"
+	case stripe.BalanceTransactionTypeRefund, stripe.BalanceTransactionTypePaymentRefund,
+		stripe.BalanceTransactionTypeRefundFailure, stripe.BalanceTransactionTypePaymentFailureRefund:
+		return "refunds"
+	case stripe.BalanceTransactionTypePayout, stripe.BalanceTransactionTypePayoutCancel,
+		stripe.BalanceTransactionTypePayoutFailure:
+		return "bank"
+	case stripe.BalanceTransactionTypeStripeFee, stripe.BalanceTransactionTypeStripeFxFee,
+		stripe.BalanceTransactionTypeTaxFee:
+		return "fees"
+	default:
+		return "adjustments"
+	}
+}
+
+func ledgerPeriod(t time.Time, group string) string {
+	switch group {
+	case "all":
+		return "all"
+	case "quarter":
+		return fmt.Sprintf("%v-Q%v", t.Year(), (int(t.Month())-1)/3+1)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+func ledgerTaxLabel(t *stripe.TaxRate) (label string, err error) {
+	if t == nil {
+		return "unknown", nil
+	}
+	if len(t.DisplayName)==0 {
+		t, err = TaxRateFetch(t.ID)
+		if err != nil {
+			return
+		}
+	}
+	return fmt.Sprintf("%s %v%% %s", t.DisplayName, t.Percentage, t.Jurisdiction), nil
+}
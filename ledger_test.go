@@ -0,0 +1,91 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"reflect"
+	"strings"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func ledgerFixture() (txs []*stripe.BalanceTransaction, invs []*stripe.Invoice) {
+	jan := time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local).Unix()
+	feb := time.Date(2024, 2, 15, 12, 0, 0, 0, time.Local).Unix()
+	tx := func (id string, created int64, t stripe.BalanceTransactionType, amount, fee int64) *stripe.BalanceTransaction {
+		return &stripe.BalanceTransaction{ ID: id, Created: created, Type: t, Currency: "eur",
+			Amount: amount, Fee: fee, Net: amount - fee }
+	}
+	txs = []*stripe.BalanceTransaction{
+		tx("txn_1", jan, stripe.BalanceTransactionTypeCharge   , 12100, 200),
+		tx("txn_2", jan, stripe.BalanceTransactionTypeStripeFee, -500 , 0  ),
+		tx("txn_3", jan, stripe.BalanceTransactionTypeRefund   , -1000, 0  ),
+		tx("txn_4", feb, stripe.BalanceTransactionTypePayout   , -10000, 0 ),
+		tx("txn_5", feb, stripe.BalanceTransactionTypeAdjustment, 300 , 0  ),
+	}
+	invs = []*stripe.Invoice{{
+		Created:         jan,
+		Currency:        "eur",
+		TotalTaxAmounts: []*stripe.InvoiceTotalTaxAmount{{
+			Amount:  2100,
+			TaxRate: &stripe.TaxRate{ ID: "txr_1", DisplayName: "IVA", Percentage: 21, Jurisdiction: "ES" },
+		}},
+	}}
+	return
+}
+
+func TestLedgerSummarize(t *testing.T) {
+	txs, invs := ledgerFixture()
+	res := []struct {
+		group string
+		sums  []LedgerSummary
+	}{
+		{ "month", []LedgerSummary{
+			{ Period: "2024-01", Gross: 12100, Fees: 700, Refunds: -1000, Net: 10400 },
+			{ Period: "2024-02", Adjustments: 300, Net: 300, Payouts: -10000 },
+		}},
+		{ "all", []LedgerSummary{
+			{ Period: "all", Gross: 12100, Fees: 700, Refunds: -1000, Adjustments: 300, Net: 10700, Payouts: -10000 },
+		}},
+	}
+	for _, r := range res {
+		sums, err := LedgerSummarize(txs, invs, r.group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != len(r.sums) {
+			t.Fatalf("%s: %v summaries", r.group, len(sums))
+		}
+		for i, s := range sums {
+			w, g := r.sums[i], *s
+			w.Currency, g.Taxes = "eur", nil
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("%s: %+v != %+v", r.group, *s, w)
+			}
+		}
+		if sums[0].Taxes["IVA 21% ES"] != 2100 {
+			t.Errorf("%s: taxes: %v", r.group, sums[0].Taxes)
+		}
+	}
+}
+
+func TestLedgerWriteJournal(t *testing.T) {
+	txs, _ := ledgerFixture()
+	out := bytes.Buffer{}
+	if err := LedgerWriteJournal(&out, txs[:2]); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"2024/01/15 txn_1 charge\n",
+		"    Assets:Stripe                           119.00 eur\n",
+		"    Expenses:Stripe:Fees                      2.00 eur\n",
+		"    Income:Sales                           -121.00 eur\n",
+		"2024/01/15 txn_2 stripe_fee\n",
+		"    Assets:Stripe                            -5.00 eur\n",
+		"    Expenses:Stripe:Fees                      5.00 eur\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("journal lacks %q:\n%s", s, out.String())
+		}
+	}
+}
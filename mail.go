@@ -2,11 +2,16 @@ package ustripe
 
 import (
 	"github.com/stripe/stripe-go/v73"
+	"time"
 	"fmt"
 )
 
 var ValidationMail func (c *stripe.Customer, to, url string) (mail string) = DefaultValidationMail
 var ValidationURL  func (ecode, email string)                (url  string) = DefaultValidationURL
+var InvitationMail func (owner, c *stripe.Customer, to, url string) (mail string) = DefaultInvitationMail
+var InvitationURL  func (ecode, email string)                (url  string) = DefaultInvitationURL
+var DunningMail    func (c *stripe.Customer, to, url string, reminder int, deadline time.Time) (mail string) = DefaultDunningMail
+var InvoiceMail    func (inv *stripe.Invoice, to string) (mail string) = DefaultInvoiceMail
 
 func DefaultValidationMail(c *stripe.Customer, to, url string) (s string) {
 	subject     := "Confirm your mail with Lotorius"
@@ -37,3 +42,101 @@ func DefaultValidationMail(c *stripe.Customer, to, url string) (s string) {
 func DefaultValidationURL(ecode, email string) (url string) {
 	return "https://efferox.com/wellcome?mcode=" + ecode + "&email=" + email
 }
+
+func DefaultInvitationURL(ecode, email string) (url string) {
+	return "https://efferox.com/team?mcode=" + ecode + "&email=" + email
+}
+
+func DefaultInvitationMail(owner, c *stripe.Customer, to, url string) (s string) {
+	subject     := "You have been invited to Lotorius"
+	contentType := "text/html; charset=UTF-8"
+	accept      := "Accept invitation"
+	if len(c.Metadata["hash1"])==0 {
+		accept = "Accept and choose a password"
+	}
+	return fmt.Sprintf(""    +
+		"To: %s"               + "\n" +
+		"Subject: %s"          + "\n" +
+		"Content-Type: %s"     + "\n" +
+		""                     + "\n" +
+		"<html>"               + "\n" +
+		"  <body>"             + "\n" +
+		"    <p>"              + "\n" +
+		"      %s has invited you to join the team. To accept the"   + "\n" +
+		"      invitation confirm your mail address by clicking the" + "\n" +
+		"      next button."                                         + "\n" +
+		"    </p>"                                                   + "\n" +
+		"    <p>"                                                    + "\n" +
+		"      <a href=\"%s\">%s</a>"                                + "\n" +
+		"    </p>"                                                   + "\n" +
+		"  </body>"                                                  + "\n" +
+		"</html>"                                                    + "\n",
+		to, subject, contentType, owner.Email, url, accept)
+}
+
+func DefaultInvoiceMail(inv *stripe.Invoice, to string) (s string) {
+	subject     := "Your Lotorius invoice " + inv.Number
+	contentType := "text/html; charset=UTF-8"
+	return fmt.Sprintf(""    +
+		"To: %s"               + "\n" +
+		"Subject: %s"          + "\n" +
+		"Content-Type: %s"     + "\n" +
+		""                     + "\n" +
+		"<html>"               + "\n" +
+		"  <body>"             + "\n" +
+		"    <p>"              + "\n" +
+		"      Your invoice %s of %s is available."                  + "\n" +
+		"    </p>"                                                   + "\n" +
+		"    <p>"                                                    + "\n" +
+		"      <a href=\"%s\">View invoice</a>"                      + "\n" +
+		"      <a href=\"%s\">Download PDF</a>"                      + "\n" +
+		"    </p>"                                                   + "\n" +
+		"  </body>"                                                  + "\n" +
+		"</html>"                                                    + "\n",
+		to, subject, contentType, inv.Number, NewMoney(inv.Total, inv.Currency),
+		inv.HostedInvoiceURL, inv.InvoicePDF)
+}
+
+// DunningTexts are the reminder texts by language: subject, body and
+// button. The body receives the payment deadline.
+var DunningTexts = map[string][3]string{
+	"en": {
+		"Your payment to Lotorius failed",
+		"We could not charge the renewal of your subscription. Please "   +
+		"update your payment details before %s to keep your account.",
+		"Pay invoice",
+	},
+	"es": {
+		"El pago a Lotorius ha fallado",
+		"No hemos podido cobrar la renovación de tu suscripción. Por "    +
+		"favor actualiza tus datos de pago antes del %s para mantener tu " +
+		"cuenta.",
+		"Pagar factura",
+	},
+}
+
+func DefaultDunningMail(c *stripe.Customer, to, url string, reminder int, deadline time.Time) (s string) {
+	texts, found := DunningTexts[UserLanguage(c)]
+	if !found {
+		texts = DunningTexts["en"]
+	}
+	contentType := "text/html; charset=UTF-8"
+	return fmt.Sprintf(""    +
+		"To: %s"               + "\n" +
+		"Subject: %s"          + "\n" +
+		"Content-Type: %s"     + "\n" +
+		""                     + "\n" +
+		"<html>"               + "\n" +
+		"  <body>"             + "\n" +
+		"    <p>"              + "\n" +
+		"      %s"             + "\n" +
+		"    </p>"             + "\n" +
+		"    <p>"              + "\n" +
+		"      <a href=\"%s\">%s</a>"                                + "\n" +
+		"    </p>"                                                   + "\n" +
+		"  </body>"                                                  + "\n" +
+		"</html>"                                                    + "\n",
+		to, texts[0], contentType,
+		fmt.Sprintf(texts[1], deadline.Format("2006-01-02")),
+		url, texts[2])
+}
@@ -0,0 +1,349 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/invoice"
+	"github.com/stripe/stripe-go/v73/subscription"
+	"strconv"
+	"time"
+	"sort"
+	"fmt"
+)
+
+// Metrics are the revenue metrics calculated by MetricsCompute.
+// Amounts are in the currency's minor unit.
+type Metrics struct {
+	MRR     []*MetricsMRR    `json:"mrr"`
+	Months  []*MetricsMonth  `json:"months"`
+	Cohorts []*MetricsCohort `json:"cohorts"`
+}
+
+// MetricsMRR is the current monthly recurring revenue of a product.
+type MetricsMRR struct {
+	Product  string `json:"product"`
+	Currency string `json:"currency"`
+	MRR      int64  `json:"mrr"`
+	ARR      int64  `json:"arr"`
+}
+
+// MetricsMonth is the MRR movement of a month, from paid invoices.
+type MetricsMonth struct {
+	Month            string  `json:"month"`
+	Currency         string  `json:"currency"`
+	MRR              int64   `json:"mrr"`
+	New              int64   `json:"new"`
+	Expansion        int64   `json:"expansion"`
+	Contraction      int64   `json:"contraction"`
+	Churned          int64   `json:"churned"`
+	Customers        int     `json:"customers"`
+	ChurnedCustomers int     `json:"churned_customers"`
+	ChurnRate        float64 `json:"churn_rate"`
+}
+
+// MetricsCohort groups customers by the month they verified the email.
+type MetricsCohort struct {
+	Cohort    string  `json:"cohort"`
+	Signups   int     `json:"signups"`
+	Active    int     `json:"active"`
+	Retention float64 `json:"retention"`
+}
+
+// MetricsCompute walks the subscriptions, the paid invoices since the
+// given time and the customers to calculate the revenue metrics.
+func MetricsCompute(since time.Time) (m *Metrics, err error) {
+	var subs   []*stripe.Subscription
+	var invs   []*stripe.Invoice
+	var users  []*stripe.Customer
+
+	ps := &stripe.SubscriptionListParams{}
+	ps.Filters.AddFilter("limit" , "", "100")
+	ps.Filters.AddFilter("status", "", "all")
+	is := subscription.List(ps)
+	for is.Next() {
+		subs = append(subs, is.Subscription())
+	}
+	if err = is.Err(); err != nil {
+		return
+	}
+
+	pi := &stripe.InvoiceListParams{}
+	pi.Status       = stripe.String("paid")
+	pi.CreatedRange = &stripe.RangeQueryParams{ GreaterThanOrEqual: since.Unix() }
+	pi.Filters.AddFilter("limit", "", "100")
+	ii := invoice.List(pi)
+	for ii.Next() {
+		inv := ii.Invoice()
+		if inv.Lines != nil && inv.Lines.HasMore {
+			if inv.Lines.Data, err = InvoiceLines(inv.ID); err != nil {
+				return
+			}
+		}
+		invs = append(invs, inv)
+	}
+	if err = ii.Err(); err != nil {
+		return
+	}
+
+	iu := UserIter()
+	for iu.Next() {
+		users = append(users, iu.Customer())
+	}
+	if err = iu.Err(); err != nil {
+		return
+	}
+
+	m = &Metrics{
+		MRR:     MetricsMRRs(subs),
+		Months:  MetricsMovements(invs),
+		Cohorts: MetricsCohorts(users, subs),
+	}
+	return
+}
+
+// MetricsMRRs calculates the current MRR per product and currency of
+// the active and past_due subscriptions. Metered prices are ignored.
+func MetricsMRRs(subs []*stripe.Subscription) (r []*MetricsMRR) {
+	idx := map[string]*MetricsMRR{}
+	for _, sub := range subs {
+		if sub.Status != stripe.SubscriptionStatusActive && sub.Status != stripe.SubscriptionStatusPastDue {
+			continue
+		}
+		for _, item := range sub.Items.Data {
+			p := item.Price
+			if p == nil || p.Recurring == nil || p.Product == nil {
+				continue
+			}
+			if p.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+				continue
+			}
+			key := p.Product.ID + "/" + string(p.Currency)
+			if idx[key] == nil {
+				idx[key] = &MetricsMRR{ Product: p.Product.ID, Currency: string(p.Currency) }
+				r = append(r, idx[key])
+			}
+			idx[key].MRR += metricsMonthly(p.UnitAmount * item.Quantity, p.Recurring)
+		}
+	}
+	for _, i := range r {
+		i.ARR = i.MRR * 12
+	}
+	sort.Slice(r, func (a, b int) bool {
+		return r[a].Product + r[a].Currency < r[b].Product + r[b].Currency
+	})
+	return
+}
+
+// MetricsMovements calculates the MRR of each customer and month from
+// the subscription lines of paid invoices, and classifies the changes
+// between consecutive months as new, expansion, contraction or churn.
+// The amount of a line is spread over the months of its period, so
+// yearly and quarterly plans count in every month they cover.
+func MetricsMovements(invs []*stripe.Invoice) (months []*MetricsMonth) {
+	var first, last time.Time
+	mrr := map[string]map[string]int64{} // currency -> "month/customer" -> mrr.
+	customers := map[string]map[string]bool{}
+
+	for _, inv := range invs {
+		if inv.Lines == nil || inv.Customer == nil {
+			continue
+		}
+		for _, l := range inv.Lines.Data {
+			if l.Price == nil || l.Price.Recurring == nil || l.Period == nil {
+				continue
+			}
+			cur := string(l.Currency)
+			if mrr[cur] == nil {
+				mrr[cur], customers[cur] = map[string]int64{}, map[string]bool{}
+			}
+			customers[cur][inv.Customer.ID] = true
+			start := time.Unix(l.Period.Start, 0).UTC()
+			n     := metricsMonths(start, time.Unix(l.Period.End, 0).UTC())
+			base, rem := l.Amount / n, l.Amount % n
+			for i := int64(0); i < n; i++ {
+				t := time.Date(start.Year(), start.Month() + time.Month(i), 1, 0, 0, 0, 0, time.UTC)
+				if first.IsZero() || t.Before(first) {
+					first = t
+				}
+				if t.After(last) {
+					last = t
+				}
+				amount := base
+				switch {
+				case i < rem:  amount++
+				case i < -rem: amount--
+				}
+				mrr[cur][t.Format("2006-01") + "/" + inv.Customer.ID] += amount
+			}
+		}
+	}
+	if first.IsZero() {
+		return
+	}
+	currencies := []string{}
+	for cur := range mrr {
+		currencies = append(currencies, cur)
+	}
+	sort.Strings(currencies)
+
+	for t := first; !t.After(last); t = t.AddDate(0, 1, 0) {
+		month := t.Format("2006-01")
+		prev  := t.AddDate(0, -1, 0).Format("2006-01")
+		for _, cur := range currencies {
+			mm := &MetricsMonth{ Month: month, Currency: cur }
+			active := 0
+			for c := range customers[cur] {
+				now, before := mrr[cur][month + "/" + c], mrr[cur][prev + "/" + c]
+				mm.MRR += now
+				if now > 0 {
+					mm.Customers++
+				}
+				if before > 0 {
+					active++
+				}
+				switch {
+				case before == 0 && now > 0:  mm.New         += now
+				case before > 0 && now == 0:  mm.Churned     += before; mm.ChurnedCustomers++
+				case now > before:            mm.Expansion   += now - before
+				case now < before:            mm.Contraction += before - now
+				}
+			}
+			if active > 0 {
+				mm.ChurnRate = float64(mm.ChurnedCustomers) / float64(active)
+			}
+			months = append(months, mm)
+		}
+	}
+	return
+}
+
+// MetricsCohorts groups the verified customers by the month of
+// verification ("verified_at" metadata, creation date when missing)
+// and counts how many have an active subscription.
+func MetricsCohorts(users []*stripe.Customer, subs []*stripe.Subscription) (r []*MetricsCohort) {
+	paying := map[string]bool{}
+	for _, s := range subs {
+		if s.Customer != nil && s.Status == stripe.SubscriptionStatusActive {
+			paying[s.Customer.ID] = true
+		}
+	}
+	idx := map[string]*MetricsCohort{}
+	for _, u := range users {
+		if !UserVerified(u) {
+			continue
+		}
+		ts := u.Created
+		if v, err := strconv.ParseInt(u.Metadata["verified_at"], 10, 64); err == nil {
+			ts = v
+		}
+		cohort := time.Unix(ts, 0).UTC().Format("2006-01")
+		if idx[cohort] == nil {
+			idx[cohort] = &MetricsCohort{ Cohort: cohort }
+			r = append(r, idx[cohort])
+		}
+		idx[cohort].Signups++
+		if paying[u.ID] {
+			idx[cohort].Active++
+		}
+	}
+	for _, c := range r {
+		c.Retention = float64(c.Active) / float64(c.Signups)
+	}
+	sort.Slice(r, func (a, b int) bool { return r[a].Cohort < r[b].Cohort })
+	return
+}
+
+// MetricsPrint prints the metrics as tables.
+func MetricsPrint(m *Metrics) {
+	fmt.Printf("%-20s %-4s %15s %15s\n", "PRODUCT", "CUR", "MRR", "ARR")
+	for _, r := range m.MRR {
+		fmt.Printf("%-20s %-4s %15s %15s\n", r.Product, r.Currency,
+			Money{ float64(r.MRR), r.Currency }.Plain(), Money{ float64(r.ARR), r.Currency }.Plain())
+	}
+	fmt.Printf("\n%-7s %-4s %12s %12s %12s %12s %12s %6s %6s\n",
+		"MONTH", "CUR", "MRR", "NEW", "EXPANSION", "CONTRACTION", "CHURNED", "CUST", "CHURN")
+	for _, r := range m.Months {
+		p := func (v int64) string { return Money{ float64(v), r.Currency }.Plain() }
+		fmt.Printf("%-7s %-4s %12s %12s %12s %12s %12s %6v %5.1f%%\n",
+			r.Month, r.Currency, p(r.MRR), p(r.New), p(r.Expansion), p(r.Contraction),
+			p(r.Churned), r.Customers, r.ChurnRate * 100)
+	}
+	fmt.Printf("\n%-7s %8s %8s %9s\n", "COHORT", "SIGNUPS", "ACTIVE", "RETENTION")
+	for _, r := range m.Cohorts {
+		fmt.Printf("%-7s %8v %8v %8.1f%%\n", r.Cohort, r.Signups, r.Active, r.Retention * 100)
+	}
+}
+
+// MetricsRecords returns a table of MetricsPrint as Records for
+// Renderer, view is "mrr", "months" or "cohorts".
+func MetricsRecords(m *Metrics, view string) (recs []Record, err error) {
+	switch view {
+	case "mrr":
+		for _, r := range m.MRR {
+			recs = append(recs, Record{
+				{ "product" , r.Product },
+				{ "currency", r.Currency },
+				{ "mrr"     , r.MRR },
+				{ "arr"     , r.ARR },
+			})
+		}
+	case "months":
+		for _, r := range m.Months {
+			recs = append(recs, Record{
+				{ "month"            , r.Month },
+				{ "currency"         , r.Currency },
+				{ "mrr"              , r.MRR },
+				{ "new"              , r.New },
+				{ "expansion"        , r.Expansion },
+				{ "contraction"      , r.Contraction },
+				{ "churned"          , r.Churned },
+				{ "customers"        , r.Customers },
+				{ "churned_customers", r.ChurnedCustomers },
+				{ "churn_rate"       , r.ChurnRate },
+			})
+		}
+	case "cohorts":
+		for _, r := range m.Cohorts {
+			recs = append(recs, Record{
+				{ "cohort"   , r.Cohort },
+				{ "signups"  , r.Signups },
+				{ "active"   , r.Active },
+				{ "retention", r.Retention },
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown metrics view: %s", view)
+	}
+	return
+}
+
+// metricsMonthly converts a recurring amount to a monthly amount,
+// rounded to the minor unit.
+func metricsMonthly(amount int64, r *stripe.PriceRecurring) int64 {
+	var mul, div int64 = 1, r.IntervalCount
+	if div == 0 {
+		div = 1
+	}
+	switch r.Interval {
+	case stripe.PriceRecurringIntervalYear: div *= 12
+	case stripe.PriceRecurringIntervalWeek: mul, div = 52, div * 12
+	case stripe.PriceRecurringIntervalDay:  mul, div = 365, div * 12
+	}
+	amount *= mul
+	if amount < 0 {
+		return -((-amount + div/2) / div)
+	}
+	return (amount + div/2) / div
+}
+
+// metricsMonths returns the number of whole months between start and
+// end, at least 1. Shorter periods are counted in the start month.
+func metricsMonths(start, end time.Time) int64 {
+	n := int64(end.Year() - start.Year()) * 12 + int64(end.Month() - start.Month())
+	if end.Day() < start.Day() {
+		n--
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,139 @@
+package ustripe
+
+import (
+	"testing"
+	"time"
+	"github.com/stripe/stripe-go/v73"
+)
+
+func TestMetricsMonthly(t *testing.T) {
+	res := []struct {
+		amount   int64
+		interval stripe.PriceRecurringInterval
+		count    int64
+		monthly  int64
+	}{
+		{ 1000 , stripe.PriceRecurringIntervalMonth, 1, 1000  },
+		{ 3000 , stripe.PriceRecurringIntervalMonth, 3, 1000  },
+		{ 12000, stripe.PriceRecurringIntervalYear , 1, 1000  },
+		{ 1000 , stripe.PriceRecurringIntervalYear , 1, 83    },
+		{ 1000 , stripe.PriceRecurringIntervalWeek , 1, 4333  },
+		{ 100  , stripe.PriceRecurringIntervalDay  , 0, 3042  },
+		{ -1000, stripe.PriceRecurringIntervalYear , 1, -83   },
+	}
+	for _, r := range res {
+		rec := &stripe.PriceRecurring{ Interval: r.interval, IntervalCount: r.count }
+		if m := metricsMonthly(r.amount, rec); m != r.monthly {
+			t.Errorf("%v/%v%s: %v != %v", r.amount, r.count, r.interval, m, r.monthly)
+		}
+	}
+}
+
+func TestMetricsMRRs(t *testing.T) {
+	price := func (product string, amount int64, interval stripe.PriceRecurringInterval) *stripe.Price {
+		return &stripe.Price{
+			Product:    &stripe.Product{ ID: product },
+			Currency:   "eur",
+			UnitAmount: amount,
+			Recurring:  &stripe.PriceRecurring{ Interval: interval, IntervalCount: 1 },
+		}
+	}
+	sub := func (status stripe.SubscriptionStatus, items ...*stripe.SubscriptionItem) *stripe.Subscription {
+		return &stripe.Subscription{ Status: status, Items: &stripe.SubscriptionItemList{ Data: items } }
+	}
+	subs := []*stripe.Subscription{
+		sub(stripe.SubscriptionStatusActive  , &stripe.SubscriptionItem{ Price: price("prod_a", 1000 , "month"), Quantity: 2 }),
+		sub(stripe.SubscriptionStatusPastDue , &stripe.SubscriptionItem{ Price: price("prod_a", 12000, "year" ), Quantity: 1 }),
+		sub(stripe.SubscriptionStatusCanceled, &stripe.SubscriptionItem{ Price: price("prod_a", 5000 , "month"), Quantity: 1 }),
+		sub(stripe.SubscriptionStatusActive  , &stripe.SubscriptionItem{ Price: price("prod_b", 500  , "month"), Quantity: 1 }),
+	}
+	r := MetricsMRRs(subs)
+	if len(r) != 2 || r[0].Product != "prod_a" || r[0].MRR != 3000 || r[0].ARR != 36000 || r[1].MRR != 500 {
+		t.Fatalf("unexpected MRR: %+v %+v", r[0], r[1])
+	}
+}
+
+func TestMetricsMovements(t *testing.T) {
+	day := func (s string) int64 {
+		t, _ := time.Parse("2006-01-02", s)
+		return t.Unix()
+	}
+	inv := func (customer string, amount int64, interval stripe.PriceRecurringInterval, start, end string) *stripe.Invoice {
+		return &stripe.Invoice{
+			Customer: &stripe.Customer{ ID: customer },
+			Lines:    &stripe.InvoiceLineItemList{ Data: []*stripe.InvoiceLineItem{{
+				Amount:   amount,
+				Currency: "eur",
+				Price:    &stripe.Price{ Recurring: &stripe.PriceRecurring{ Interval: interval, IntervalCount: 1 } },
+				Period:   &stripe.Period{ Start: day(start), End: day(end) },
+			}}},
+		}
+	}
+	res := []struct {
+		name  string
+		invs  []*stripe.Invoice
+		month string
+		want  MetricsMonth
+	}{
+		{ "yearly plan between renewals",
+			[]*stripe.Invoice{ inv("cus_a", 12000, "year", "2024-01-15", "2025-01-15") },
+			"2024-06", MetricsMonth{ MRR: 1000, Customers: 1 } },
+		{ "yearly plan first month",
+			[]*stripe.Invoice{ inv("cus_a", 12000, "year", "2024-01-15", "2025-01-15") },
+			"2024-01", MetricsMonth{ MRR: 1000, New: 1000, Customers: 1 } },
+		{ "yearly plan renewal",
+			[]*stripe.Invoice{
+				inv("cus_a", 12000, "year", "2024-01-15", "2025-01-15"),
+				inv("cus_a", 24000, "year", "2025-01-15", "2026-01-15"),
+			},
+			"2025-01", MetricsMonth{ MRR: 2000, Expansion: 1000, Customers: 1 } },
+		{ "quarterly plan churned",
+			[]*stripe.Invoice{
+				inv("cus_a", 3000, "month", "2024-01-01", "2024-04-01"),
+				inv("cus_b", 1000, "month", "2024-01-01", "2024-02-01"),
+				inv("cus_b", 1000, "month", "2024-02-01", "2024-03-01"),
+				inv("cus_b", 1000, "month", "2024-03-01", "2024-04-01"),
+				inv("cus_c", 1000, "month", "2024-03-01", "2024-04-01"),
+				inv("cus_c", 1000, "month", "2024-04-01", "2024-05-01"),
+			},
+			"2024-04", MetricsMonth{ MRR: 1000, Churned: 2000, Customers: 1, ChurnedCustomers: 2, ChurnRate: 2.0/3 } },
+		{ "monthly plan anchored mid-month",
+			[]*stripe.Invoice{
+				inv("cus_a", 1000, "month", "2024-01-15", "2024-02-15"),
+				inv("cus_a", 1000, "month", "2024-02-15", "2024-03-15"),
+			},
+			"2024-02", MetricsMonth{ MRR: 1000, Customers: 1 } },
+		{ "remainder spread",
+			[]*stripe.Invoice{ inv("cus_a", 1000, "month", "2024-01-01", "2024-04-01") },
+			"2024-01", MetricsMonth{ MRR: 334, New: 334, Customers: 1 } },
+	}
+	for _, r := range res {
+		var got *MetricsMonth
+		for _, m := range MetricsMovements(r.invs) {
+			if m.Month == r.month {
+				got = m
+			}
+		}
+		r.want.Month, r.want.Currency = r.month, "eur"
+		if got == nil || *got != r.want {
+			t.Errorf("%s: %+v != %+v", r.name, got, r.want)
+		}
+	}
+}
+
+func TestMetricsRecords(t *testing.T) {
+	m := &Metrics{ MRR: []*MetricsMRR{ { Product: "prod_1", Currency: "eur", MRR: 1000, ARR: 12000 } } }
+	recs, err := MetricsRecords(m, "mrr")
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("mrr: %v %v", recs, err)
+	}
+	if v, _ := recs[0].Get("arr"); v != int64(12000) {
+		t.Errorf("arr: %v", v)
+	}
+	if recs, err = MetricsRecords(m, "cohorts"); err != nil || len(recs) != 0 {
+		t.Errorf("cohorts: %v %v", recs, err)
+	}
+	if _, err = MetricsRecords(m, "churn"); err == nil {
+		t.Errorf("unknown view accepted")
+	}
+}
@@ -0,0 +1,370 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/customer"
+	"github.com/stripe/stripe-go/v73/subscription"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+// Mirror is a local copy of the customers, products and subscriptions
+// kept in MirrorFile. It is filled by MirrorSync and kept up to date
+// with webhook events.
+type Mirror struct {
+	SyncedAt      int64                           `json:"synced_at"`
+	UpdatedAt     int64                           `json:"updated_at"`
+	Customers     map[string]*stripe.Customer     `json:"customers"`
+	Products      map[string]*stripe.Product      `json:"products"`
+	Subscriptions map[string]*stripe.Subscription `json:"subscriptions"`
+}
+
+var mirrorMutex   sync.Mutex
+var mirrorCache  *Mirror
+var mirrorModTime time.Time
+
+// MirrorSync downloads all customers, products and subscriptions.
+func MirrorSync() (err error) {
+	m := mirrorNew()
+
+	pc := &stripe.CustomerListParams{}
+	pc.Filters.AddFilter("limit"   , "", "100")
+	pc.Filters.AddFilter("expand[]", "", "data.subscriptions")
+	pc.Filters.AddFilter("expand[]", "", "data.tax_ids")
+	ic := customer.List(pc)
+	for ic.Next() {
+		m.Customers[ic.Customer().ID] = ic.Customer()
+	}
+	if err = ic.Err(); err != nil {
+		return
+	}
+
+	ip := ProductList()
+	for ip.Next() {
+		m.Products[ip.Product().ID] = ip.Product()
+	}
+	if err = ip.Err(); err != nil {
+		return
+	}
+
+	ps := &stripe.SubscriptionListParams{}
+	ps.Filters.AddFilter("limit" , "", "100")
+	ps.Filters.AddFilter("status", "", "all")
+	is := subscription.List(ps)
+	for is.Next() {
+		m.Subscriptions[is.Subscription().ID] = is.Subscription()
+	}
+	if err = is.Err(); err != nil {
+		return
+	}
+
+	m.SyncedAt  = time.Now().Unix()
+	m.UpdatedAt = m.SyncedAt
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	return mirrorSave(m)
+}
+
+// MirrorEvent updates the mirror with the event's object.
+func MirrorEvent(e *stripe.Event) (err error) {
+	var m *Mirror
+	if len(MirrorFile)==0 || e.Data == nil {
+		return nil
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	m, err = mirrorLoad()
+	if err != nil || m == nil {
+		return
+	}
+	deleted := strings.HasSuffix(e.Type, ".deleted")
+	switch {
+	case strings.HasPrefix(e.Type, "customer.subscription."):
+		s := &stripe.Subscription{}
+		if err = json.Unmarshal(e.Data.Raw, s); err != nil {
+			return
+		}
+		m.Subscriptions[s.ID] = s
+		if c := m.Customers[EventCustomer(e)]; c != nil {
+			c.Subscriptions = mirrorSubscriptionList(m, c.ID)
+		}
+	case strings.HasPrefix(e.Type, "customer.tax_id."):
+		t := &stripe.TaxID{}
+		if err = json.Unmarshal(e.Data.Raw, t); err != nil {
+			return
+		}
+		if c := m.Customers[EventCustomer(e)]; c != nil {
+			c.TaxIDs = mirrorTaxIDList(c.TaxIDs, t, deleted)
+		}
+	case strings.HasPrefix(e.Type, "customer.") && eventString(e, "object") == "customer":
+		c := &stripe.Customer{}
+		if err = json.Unmarshal(e.Data.Raw, c); err != nil {
+			return
+		}
+		if deleted {
+			delete(m.Customers, c.ID)
+		} else {
+			if o := m.Customers[c.ID]; o != nil {
+				c.TaxIDs = o.TaxIDs
+			}
+			c.Subscriptions = mirrorSubscriptionList(m, c.ID)
+			m.Customers[c.ID] = c
+		}
+	case strings.HasPrefix(e.Type, "product."):
+		p := &stripe.Product{}
+		if err = json.Unmarshal(e.Data.Raw, p); err != nil {
+			return
+		}
+		if deleted {
+			delete(m.Products, p.ID)
+		} else {
+			if o := m.Products[p.ID]; o != nil && p.DefaultPrice != nil && o.DefaultPrice != nil && o.DefaultPrice.ID == p.DefaultPrice.ID {
+				p.DefaultPrice = o.DefaultPrice
+			}
+			m.Products[p.ID] = p
+		}
+	case strings.HasPrefix(e.Type, "price."):
+		p := &stripe.Price{}
+		if err = json.Unmarshal(e.Data.Raw, p); err != nil {
+			return
+		}
+		for _, prod := range m.Products {
+			if prod.DefaultPrice != nil && prod.DefaultPrice.ID == p.ID {
+				prod.DefaultPrice = p
+			}
+		}
+	default:
+		return nil
+	}
+	m.UpdatedAt = time.Now().Unix()
+	return mirrorSave(m)
+}
+
+// MirrorStatus prints information about the mirror.
+func MirrorStatus() (err error) {
+	var m *Mirror
+	mirrorMutex.Lock()
+	m, err = mirrorLoad()
+	mirrorMutex.Unlock()
+	switch {
+	case err != nil:
+		return
+	case m == nil:
+		return fmt.Errorf("mirror not synced")
+	}
+	fmt.Printf("File: %s\n"         , MirrorFile)
+	fmt.Printf("Synced: %s\n"       , time.Unix(m.SyncedAt, 0).Format(time.RFC3339))
+	fmt.Printf("Updated: %s\n"      , time.Unix(m.UpdatedAt, 0).Format(time.RFC3339))
+	fmt.Printf("Fresh: %v\n"        , m.fresh())
+	fmt.Printf("Customers: %v\n"    , len(m.Customers))
+	fmt.Printf("Products: %v\n"     , len(m.Products))
+	fmt.Printf("Subscriptions: %v\n", len(m.Subscriptions))
+	return nil
+}
+
+// mirrorPutCustomer updates a customer after a local modification,
+// the cached copy is dropped.
+func mirrorPutCustomer(c *stripe.Customer) {
+	var m *Mirror
+	CacheInvalidate("user", c.Email)
+	if len(MirrorFile)==0 {
+		return
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	if m, _ = mirrorLoad(); m != nil {
+		if o := m.Customers[c.ID]; o != nil && c.TaxIDs == nil {
+			c.TaxIDs = o.TaxIDs
+		}
+		if c.Subscriptions == nil {
+			c.Subscriptions = mirrorSubscriptionList(m, c.ID)
+		} else {
+			for _, s := range c.Subscriptions.Data {
+				m.Subscriptions[s.ID] = s
+			}
+		}
+		m.Customers[c.ID] = c
+		mirrorSave(m)
+	}
+}
+
+// mirrorPutSubscription updates a subscription after a local modification.
+func mirrorPutSubscription(s *stripe.Subscription) {
+	var m *Mirror
+	if len(MirrorFile)==0 {
+		return
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	if m, _ = mirrorLoad(); m != nil {
+		m.Subscriptions[s.ID] = s
+		if s.Customer != nil {
+			if c := m.Customers[s.Customer.ID]; c != nil {
+				c.Subscriptions = mirrorSubscriptionList(m, c.ID)
+			}
+		}
+		mirrorSave(m)
+	}
+}
+
+// mirrorDelCustomer removes a customer and its subscriptions, returns
+// true if it was in the mirror.
+func mirrorDelCustomer(id string) (found bool) {
+	var m *Mirror
+	if len(MirrorFile)==0 || len(id)==0 {
+		return
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	if m, _ = mirrorLoad(); m != nil {
+		_, found = m.Customers[id]
+		delete(m.Customers, id)
+		for _, s := range m.userSubs(id, "") {
+			delete(m.Subscriptions, s.ID)
+			found = true
+		}
+		if found {
+			mirrorSave(m)
+		}
+	}
+	return
+}
+
+// MirrorEmails returns the emails of the customers in the mirror, nil
+// when it is not enabled or not fresh.
+func MirrorEmails() (emails []string) {
+	m := mirrorFresh()
+	if m == nil {
+		return nil
+	}
+	for _, c := range m.Customers {
+		if len(c.Email)>0 {
+			emails = append(emails, c.Email)
+		}
+	}
+	return
+}
+
+// mirrorFresh returns the mirror when it is enabled and the last
+// MirrorSync is within MirrorMaxAge, nil otherwise. Webhook events
+// don't refresh it, a missed event is only fixed by a new sync.
+// Lookups that miss in a fresh mirror still ask Stripe.
+func mirrorFresh() (m *Mirror) {
+	if len(MirrorFile)==0 {
+		return nil
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	m, _ = mirrorLoad()
+	if m == nil || !m.fresh() {
+		return nil
+	}
+	return
+}
+
+func (m *Mirror) fresh() bool {
+	return MirrorMaxAge <= 0 || time.Since(time.Unix(m.SyncedAt, 0)) <= MirrorMaxAge
+}
+
+func (m *Mirror) userSearch(email string) (c *stripe.Customer, found bool) {
+	for _, c = range m.Customers {
+		if c.Email == email {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Mirror) userSubs(userID string, status stripe.SubscriptionStatus) (subs []*stripe.Subscription) {
+	for _, s := range m.Subscriptions {
+		if s.Customer != nil && s.Customer.ID == userID && (len(status)==0 || s.Status == status) {
+			subs = append(subs, s)
+		}
+	}
+	return
+}
+
+func mirrorNew() (m *Mirror) {
+	return &Mirror{
+		Customers:     map[string]*stripe.Customer{},
+		Products:      map[string]*stripe.Product{},
+		Subscriptions: map[string]*stripe.Subscription{},
+	}
+}
+
+func mirrorLoad() (m *Mirror, err error) {
+	var info os.FileInfo
+	var data []byte
+	info, err = os.Stat(MirrorFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	if mirrorCache != nil && info.ModTime().Equal(mirrorModTime) {
+		return mirrorCache, nil
+	}
+	data, err = os.ReadFile(MirrorFile)
+	if err != nil {
+		return
+	}
+	m = mirrorNew()
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", MirrorFile, err)
+	}
+	mirrorCache, mirrorModTime = m, info.ModTime()
+	return
+}
+
+func mirrorSave(m *Mirror) (err error) {
+	var data []byte
+	var info os.FileInfo
+	data, err = json.Marshal(m)
+	if err != nil {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(MirrorFile), "." + filepath.Base(MirrorFile) + ".tmp")
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, MirrorFile)
+	if err != nil {
+		return
+	}
+	if info, err = os.Stat(MirrorFile); err == nil {
+		mirrorCache, mirrorModTime = m, info.ModTime()
+	}
+	return
+}
+
+func mirrorSubscriptionList(m *Mirror, userID string) (l *stripe.SubscriptionList) {
+	l = &stripe.SubscriptionList{}
+	for _, s := range m.userSubs(userID, "") {
+		if s.Status != stripe.SubscriptionStatusCanceled {
+			l.Data = append(l.Data, s)
+		}
+	}
+	return
+}
+
+func mirrorTaxIDList(l *stripe.TaxIDList, t *stripe.TaxID, deleted bool) (r *stripe.TaxIDList) {
+	r = &stripe.TaxIDList{}
+	if l != nil {
+		for _, o := range l.Data {
+			if o.ID != t.ID {
+				r.Data = append(r.Data, o)
+			}
+		}
+	}
+	if !deleted {
+		r.Data = append(r.Data, t)
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testMirror(t *testing.T, m *Mirror) {
+	old, age := MirrorFile, MirrorMaxAge
+	t.Cleanup(func () { MirrorFile, MirrorMaxAge, mirrorCache = old, age, nil })
+	MirrorFile   = filepath.Join(t.TempDir(), "mirror.json")
+	MirrorMaxAge = time.Hour
+	mirrorCache  = nil
+	if err := mirrorSave(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testMirrorEvent(t *testing.T, data string) {
+	e := &stripe.Event{}
+	if err := json.Unmarshal([]byte(data), e); err != nil {
+		t.Fatal(err)
+	}
+	if err := MirrorEvent(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirrorFresh(t *testing.T) {
+	defer func(d time.Duration) { MirrorMaxAge = d }(MirrorMaxAge)
+	now := time.Now()
+	tests := []struct {
+		maxAge  time.Duration
+		synced  time.Time
+		updated time.Time
+		fresh   bool
+	}{
+		{ time.Hour, now.Add(-time.Minute)   , now.Add(-time.Minute), true  },
+		{ time.Hour, now.Add(-2 * time.Hour) , now                  , false },
+		{ time.Hour, now.Add(-2 * time.Hour) , now.Add(-2*time.Hour), false },
+		{ 0        , now.Add(-48 * time.Hour), now.Add(-48*time.Hour), true  },
+	}
+	for i, tt := range tests {
+		MirrorMaxAge = tt.maxAge
+		m := &Mirror{ SyncedAt: tt.synced.Unix(), UpdatedAt: tt.updated.Unix() }
+		if m.fresh() != tt.fresh {
+			t.Errorf("%d: fresh=%v, want %v", i, m.fresh(), tt.fresh)
+		}
+	}
+}
+
+func TestMirrorEvent(t *testing.T) {
+	m := mirrorNew()
+	m.SyncedAt = time.Now().Unix()
+	m.Customers["cus_1"] = &stripe.Customer{ ID: "cus_1", Email: "a@example.com" }
+	testMirror(t, m)
+
+	testMirrorEvent(t, `{"type": "customer.subscription.created", "data": {"object": {"object": "subscription", "id": "sub_1", "customer": "cus_1", "status": "active"}}}`)
+	testMirrorEvent(t, `{"type": "customer.tax_id.created", "data": {"object": {"object": "tax_id", "id": "txi_1", "customer": "cus_1", "value": "ES12345678Z"}}}`)
+	testMirrorEvent(t, `{"type": "customer.updated", "data": {"object": {"object": "customer", "id": "cus_1", "email": "b@example.com"}}}`)
+	m = mirrorFresh()
+	if m == nil {
+		t.Fatal("mirror not fresh after events")
+	}
+	c, found := m.userSearch("b@example.com")
+	switch {
+	case !found:
+		t.Fatal("customer update not mirrored")
+	case c.Subscriptions == nil || len(c.Subscriptions.Data) != 1 || c.Subscriptions.Data[0].ID != "sub_1":
+		t.Errorf("subscriptions not kept: %+v", c.Subscriptions)
+	case c.TaxIDs == nil || len(c.TaxIDs.Data) != 1 || c.TaxIDs.Data[0].ID != "txi_1":
+		t.Errorf("tax ids not kept: %+v", c.TaxIDs)
+	}
+
+	testMirrorEvent(t, `{"type": "customer.subscription.deleted", "data": {"object": {"object": "subscription", "id": "sub_1", "customer": "cus_1", "status": "canceled"}}}`)
+	if c, _ = mirrorFresh().userSearch("b@example.com"); len(c.Subscriptions.Data) != 0 {
+		t.Errorf("canceled subscription still listed in the customer")
+	}
+	testMirrorEvent(t, `{"type": "customer.deleted", "data": {"object": {"object": "customer", "id": "cus_1"}}}`)
+	if _, found = mirrorFresh().userSearch("b@example.com"); found {
+		t.Errorf("deleted customer still mirrored")
+	}
+}
+
+func TestMirrorEventsKeepItStale(t *testing.T) {
+	m := mirrorNew()
+	m.SyncedAt = time.Now().Add(-2 * time.Hour).Unix()
+	testMirror(t, m)
+	testMirrorEvent(t, `{"type": "customer.created", "data": {"object": {"object": "customer", "id": "cus_1", "email": "a@example.com"}}}`)
+	if mirrorFresh() != nil {
+		t.Errorf("an event made a stale mirror fresh")
+	}
+}
+
+func TestMirrorPutCustomer(t *testing.T) {
+	m := mirrorNew()
+	m.SyncedAt = time.Now().Unix()
+	testMirror(t, m)
+
+	/* A customer fetched from Stripe brings its subscriptions. */
+	mirrorPutCustomer(&stripe.Customer{
+		ID:            "cus_1",
+		Email:         "a@example.com",
+		Subscriptions: &stripe.SubscriptionList{ Data: []*stripe.Subscription{
+			{ ID: "sub_1", Status: "active", Customer: &stripe.Customer{ ID: "cus_1" } },
+		}},
+	})
+	if c, found := UserSearch("a@example.com"); !found || c.ID != "cus_1" {
+		t.Fatalf("customer not served from the mirror")
+	}
+	subs, err := UserSubs("cus_1")
+	if err != nil || len(subs) != 1 || subs[0].ID != "sub_1" {
+		t.Fatalf("subscriptions not mirrored: %v %v", subs, err)
+	}
+
+	if !mirrorDelCustomer("cus_1") {
+		t.Fatalf("customer not found for deletion")
+	}
+	m = mirrorFresh()
+	if len(m.Customers) != 0 || len(m.Subscriptions) != 0 {
+		t.Errorf("customer left behind: %v %v", m.Customers, m.Subscriptions)
+	}
+}
@@ -0,0 +1,216 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"strconv"
+	"strings"
+	"math"
+	"fmt"
+)
+
+// Money is an amount in the minor unit (cents) of a currency. It is a
+// float to hold Stripe's decimal amounts (unit_amount_decimal).
+type Money struct {
+	Minor    float64 `json:"minor"`
+	Currency string  `json:"currency"`
+}
+
+// MoneyLanguage is the language used to format amounts.
+var MoneyLanguage string = "auto"
+
+type moneyLocale struct {
+	decimal, group string
+	symbolAfter    bool
+}
+
+var moneyDecimals map[string]int = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "jpy": 0, "kmf": 0,
+	"krw": 0, "mga": 0, "pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0,
+	"vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "jod": 3, "kwd": 3, "omr": 3, "tnd": 3,
+}
+
+var moneySymbols map[string]string = map[string]string{
+	"eur": "€", "usd": "$", "gbp": "£", "jpy": "¥", "krw": "₩",
+	"inr": "₹", "brl": "R$", "cad": "CA$", "aud": "A$", "chf": "CHF",
+	"mxn": "MX$", "cny": "CN¥", "pln": "zł", "sek": "kr", "nok": "kr",
+	"dkk": "kr",
+}
+
+var moneyLocales map[string]moneyLocale = map[string]moneyLocale{
+	"en": { ".", ",", false }, "ja": { ".", ",", false }, "zh": { ".", ",", false },
+	"ko": { ".", ",", false }, "th": { ".", ",", false }, "ms": { ".", ",", false },
+	"es": { ",", ".", true  }, "de": { ",", ".", true  }, "it": { ",", ".", true  },
+	"pt": { ",", ".", true  }, "nl": { ",", ".", true  }, "da": { ",", ".", true  },
+	"id": { ",", ".", true  }, "tr": { ",", ".", true  }, "ro": { ",", ".", true  },
+	"el": { ",", ".", true  }, "hr": { ",", ".", true  }, "sl": { ",", ".", true  },
+	"fr": { ",", " ", true  }, "fi": { ",", " ", true  }, "sv": { ",", " ", true  },
+	"nb": { ",", " ", true  }, "pl": { ",", " ", true  }, "cs": { ",", " ", true  },
+	"sk": { ",", " ", true  }, "ru": { ",", " ", true  }, "bg": { ",", " ", true  },
+	"hu": { ",", " ", true  }, "lt": { ",", " ", true  }, "lv": { ",", " ", true  },
+	"et": { ",", " ", true  },
+}
+
+// NewMoney creates a money amount from Stripe minor units.
+func NewMoney(minor int64, currency stripe.Currency) Money {
+	return Money{ Minor: float64(minor), Currency: string(currency) }
+}
+
+// CurrencyDecimals returns the number of decimals of the currency.
+func CurrencyDecimals(currency string) int {
+	if d, found := moneyDecimals[strings.ToLower(currency)]; found {
+		return d
+	}
+	return 2
+}
+
+// Major returns the amount in the currency's major unit.
+func (m Money) Major() float64 {
+	return m.Minor / math.Pow10(CurrencyDecimals(m.Currency))
+}
+
+// String formats the amount with MoneyLanguage.
+func (m Money) String() string {
+	return m.Format(MoneyLanguage)
+}
+
+// ParseMoney reads an amount in major units ("12.5", "12,50") and
+// returns it in the currency's minor unit.
+func ParseMoney(s string, currency string) (minor int64, err error) {
+	var neg bool
+	decimals := CurrencyDecimals(currency)
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", ".")
+	if strings.HasPrefix(s, "-") {
+		neg, s = true, s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if len(fracPart) > decimals {
+		return 0, fmt.Errorf("%s: too many decimals for %s", s, strings.ToUpper(currency))
+	}
+	for len(fracPart) < decimals {
+		fracPart += "0"
+	}
+	if len(intPart)==0 {
+		intPart = "0"
+	}
+	minor, err = strconv.ParseInt(intPart + fracPart, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %s", s)
+	}
+	if neg {
+		minor = -minor
+	}
+	return
+}
+
+// Plain formats the amount for machines, "-1234.50".
+func (m Money) Plain() string {
+	return strconv.FormatFloat(m.Major(), 'f', CurrencyDecimals(m.Currency), 64)
+}
+
+// Format formats the amount for a language as returned by Language().
+func (m Money) Format(lang string) (s string) {
+	var loc      moneyLocale
+	var found    bool
+	var intPart  string
+	var fracPart string
+	var neg      bool
+
+	lang, _, _ = strings.Cut(lang, "-")
+	if loc, found = moneyLocales[lang]; !found {
+		loc = moneyLocales["en"]
+	}
+
+	/* Convert to string keeping the extra precision of decimal
+	 * amounts ("0.005 EUR"). */
+	decimals := CurrencyDecimals(m.Currency)
+	number   := strconv.FormatFloat(math.Abs(m.Major()), 'f', -1, 64)
+	neg       = m.Minor < 0
+	intPart, fracPart, _ = strings.Cut(number, ".")
+	for len(fracPart) < decimals {
+		fracPart += "0"
+	}
+
+	/* Group thousands. */
+	for i := len(intPart) - 3; i > 0; i -= 3 {
+		intPart = intPart[:i] + loc.group + intPart[i:]
+	}
+	s = intPart
+	if len(fracPart)>0 {
+		s += loc.decimal + fracPart
+	}
+
+	symbol, found := moneySymbols[strings.ToLower(m.Currency)]
+	switch {
+	case !found:
+		symbol = strings.ToUpper(m.Currency)
+		if loc.symbolAfter {
+			s = s + " " + symbol
+		} else {
+			s = symbol + " " + s
+		}
+	case loc.symbolAfter:
+		s = s + " " + symbol
+	default:
+		s = symbol + s
+	}
+	if neg {
+		s = "-" + s
+	}
+	return
+}
+
+// PriceFormat returns a human readable representation of the price,
+// including tiers, quantity transformations and recurring interval.
+func PriceFormat(p *stripe.Price) (s string) {
+	switch {
+	case p.CustomUnitAmount != nil:
+		s = "custom"
+		if p.CustomUnitAmount.Minimum > 0 {
+			s += " min " + NewMoney(p.CustomUnitAmount.Minimum, p.Currency).String()
+		}
+		if p.CustomUnitAmount.Maximum > 0 {
+			s += " max " + NewMoney(p.CustomUnitAmount.Maximum, p.Currency).String()
+		}
+	case p.BillingScheme == stripe.PriceBillingSchemeTiered:
+		s = "tiered " + string(p.TiersMode)
+		for _, t := range p.Tiers {
+			s += fmt.Sprintf(" [%s %s", priceUpTo(t.UpTo),
+				Money{ Minor: priceDecimal(t.UnitAmount, t.UnitAmountDecimal), Currency: string(p.Currency) })
+			if t.FlatAmount > 0 || t.FlatAmountDecimal > 0 {
+				s += " +" + Money{ Minor: priceDecimal(t.FlatAmount, t.FlatAmountDecimal), Currency: string(p.Currency) }.String()
+			}
+			s += "]"
+		}
+	default:
+		s = Money{ Minor: priceDecimal(p.UnitAmount, p.UnitAmountDecimal), Currency: string(p.Currency) }.String()
+	}
+	if p.TransformQuantity != nil && p.TransformQuantity.DivideBy > 1 {
+		s += fmt.Sprintf(" per %v units", p.TransformQuantity.DivideBy)
+	}
+	if r := p.Recurring; r != nil {
+		if r.IntervalCount > 1 {
+			s += fmt.Sprintf("/%v %ss", r.IntervalCount, r.Interval)
+		} else {
+			s += "/" + string(r.Interval)
+		}
+		if r.UsageType == stripe.PriceRecurringUsageTypeMetered {
+			s += " metered"
+		}
+	}
+	return
+}
+
+func priceDecimal(amount int64, decimal float64) float64 {
+	if decimal != 0 {
+		return decimal
+	}
+	return float64(amount)
+}
+
+func priceUpTo(upTo int64) string {
+	if upTo == 0 {
+		return "inf"
+	}
+	return fmt.Sprintf("≤%v", upTo)
+}
@@ -0,0 +1,50 @@
+package ustripe
+
+import (
+	"testing"
+)
+
+func TestMoneyFormat(t *testing.T) {
+	res := []struct {
+		m    Money
+		lang string
+		s    string
+	}{
+		{ Money{ 123456, "eur" }, "en", "€1,234.56"    },
+		{ Money{ 123456, "eur" }, "es", "1.234,56 €"   },
+		{ Money{ 123456, "eur" }, "fr", "1 234,56 €"   },
+		{ Money{ 5, "usd"      }, "en", "$0.05"        },
+		{ Money{ 0.5, "usd"    }, "en", "$0.005"       },
+		{ Money{ -1999, "usd"  }, "en", "-$19.99"      },
+		{ Money{ 1500, "jpy"   }, "en", "¥1,500"       },
+		{ Money{ 1234, "kwd"   }, "en", "KWD 1.234"    },
+		{ Money{ 100, "xyz"    }, "es", "1,00 XYZ"     },
+	}
+	for _, r := range res {
+		if s := r.m.Format(r.lang); s != r.s {
+			t.Fatalf("%v %s: %q != %q", r.m, r.lang, s, r.s)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	res := []struct {
+		s        string
+		currency string
+		minor    int64
+	}{
+		{ "12.5" , "eur", 1250 },
+		{ "12,50", "eur", 1250 },
+		{ "-3"   , "usd", -300 },
+		{ "1500" , "jpy", 1500 },
+		{ "1.234", "kwd", 1234 },
+	}
+	for _, r := range res {
+		if minor, err := ParseMoney(r.s, r.currency); err != nil || minor != r.minor {
+			t.Fatalf("ParseMoney(%q, %q) = %v, %v", r.s, r.currency, minor, err)
+		}
+	}
+	if _, err := ParseMoney("1.5", "jpy"); err == nil {
+		t.Fatalf("expected error for decimals in JPY")
+	}
+}
@@ -0,0 +1,234 @@
+package ustripe
+
+import (
+	"encoding/json"
+	"encoding/csv"
+	"strings"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// OutputFormats are the formats supported by Renderer.
+var OutputFormats = []string{ "rec", "json", "jsonl", "csv", "table", "tsv" }
+
+// Field is a named value of a Record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Record is an ordered list of fields, the unit written by Renderer.
+type Record []Field
+
+// Renderer writes records in one of the OutputFormats. Formats that
+// need all records (json, table) are written on Flush.
+type Renderer struct {
+	Format  string
+	Fields  []string
+	W       io.Writer
+	header  []string
+	rows    []Record
+	csv    *csv.Writer
+}
+
+// NewRenderer creates a renderer, fields selects and orders the fields
+// to write, all when empty.
+func NewRenderer(w io.Writer, format string, fields []string) (r *Renderer, err error) {
+	for _, f := range OutputFormats {
+		if f == format {
+			r = &Renderer{ Format: format, Fields: fields, W: w }
+			if format == "csv" {
+				r.csv = csv.NewWriter(w)
+			}
+			return
+		}
+	}
+	return nil, fmt.Errorf("unsupported output format: %s", format)
+}
+
+// Get returns the value of the field.
+func (rec Record) Get(key string) (v interface{}, found bool) {
+	for _, f := range rec {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Write writes (or buffers) a record.
+func (r *Renderer) Write(rec Record) (err error) {
+	rec = r.selectFields(rec)
+	if r.header == nil {
+		for _, f := range rec {
+			r.header = append(r.header, f.Key)
+		}
+	}
+	switch r.Format {
+	case "rec":
+		for _, f := range rec {
+			v := strings.ReplaceAll(outputString(f.Value), "\n", "\n+ ")
+			_, err = fmt.Fprintf(r.W, "%s: %s\n", outputRecKey(f.Key), v)
+			if err != nil {
+				return
+			}
+		}
+		_, err = fmt.Fprintf(r.W, "\n")
+	case "jsonl":
+		var data []byte
+		data, err = outputJSON(rec)
+		if err == nil {
+			_, err = fmt.Fprintf(r.W, "%s\n", data)
+		}
+	case "csv":
+		if len(r.rows) == 0 {
+			r.rows = append(r.rows, nil)
+			if err = r.csv.Write(r.header); err != nil {
+				return
+			}
+		}
+		err = r.csv.Write(r.strings(rec))
+	case "tsv":
+		if len(r.rows) == 0 {
+			r.rows = append(r.rows, nil)
+			if _, err = fmt.Fprintf(r.W, "%s\n", strings.Join(r.header, "\t")); err != nil {
+				return
+			}
+		}
+		_, err = fmt.Fprintf(r.W, "%s\n", strings.Join(r.strings(rec), "\t"))
+	default:
+		r.rows = append(r.rows, rec)
+	}
+	return
+}
+
+// Flush writes the buffered records.
+func (r *Renderer) Flush() (err error) {
+	switch r.Format {
+	case "csv":
+		r.csv.Flush()
+		return r.csv.Error()
+	case "json":
+		var data []byte
+		buf := bytes.Buffer{}
+		buf.WriteString("[")
+		for n, rec := range r.rows {
+			if data, err = outputJSON(rec); err != nil {
+				return
+			}
+			if n > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n  ")
+			buf.Write(data)
+		}
+		buf.WriteString("\n]\n")
+		_, err = r.W.Write(buf.Bytes())
+	case "table":
+		widths := make([]int, len(r.header))
+		cells  := [][]string{}
+		head   := []string{}
+		for _, h := range r.header {
+			head = append(head, strings.ToUpper(h))
+		}
+		cells = append(cells, head)
+		for _, rec := range r.rows {
+			cells = append(cells, r.strings(rec))
+		}
+		for _, row := range cells {
+			for i, c := range row {
+				if len(c) > widths[i] {
+					widths[i] = len(c)
+				}
+			}
+		}
+		for _, row := range cells {
+			line := ""
+			for i, c := range row {
+				if i < len(row)-1 {
+					line += fmt.Sprintf("%-*s ", widths[i], c)
+				} else {
+					line += c
+				}
+			}
+			if _, err = fmt.Fprintf(r.W, "%s\n", line); err != nil {
+				return
+			}
+		}
+	}
+	r.rows = nil
+	return
+}
+
+func (r *Renderer) selectFields(rec Record) (o Record) {
+	if len(r.Fields) == 0 {
+		return rec
+	}
+	for _, key := range r.Fields {
+		v, _ := rec.Get(key)
+		o = append(o, Field{ key, v })
+	}
+	return
+}
+
+func (r *Renderer) strings(rec Record) (s []string) {
+	for _, key := range r.header {
+		v, _ := rec.Get(key)
+		c := outputString(v)
+		if r.Format != "csv" {
+			c = strings.NewReplacer("\t", " ", "\n", " ").Replace(c)
+		}
+		s = append(s, c)
+	}
+	return
+}
+
+// outputRecKey returns the recutils name of a field, "tax_ids" is
+// written "TaxIDs" like in the REC printers.
+func outputRecKey(key string) (name string) {
+	for _, w := range strings.Split(key, "_") {
+		switch w {
+		case "id", "url", "mrr", "arr":
+			name += strings.ToUpper(w)
+		case "ids":
+			name += "IDs"
+		default:
+			if len(w)>0 {
+				name += strings.ToUpper(w[:1]) + w[1:]
+			}
+		}
+	}
+	return
+}
+
+func outputString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:      return ""
+	case string:   return t
+	case []string: return strings.Join(t, ",")
+	default:       return fmt.Sprint(t)
+	}
+}
+
+func outputJSON(rec Record) (data []byte, err error) {
+	var k, v []byte
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	for n, f := range rec {
+		if k, err = json.Marshal(f.Key); err != nil {
+			return
+		}
+		if v, err = json.Marshal(f.Value); err != nil {
+			return
+		}
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
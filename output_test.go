@@ -0,0 +1,50 @@
+package ustripe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenderer(t *testing.T) {
+	recs := []Record{
+		{ { "id", "cus_1" }, { "email", "a@b.c" }, { "seats", 2 } },
+		{ { "id", "cus_22" }, { "email", "x\ty\nz" }, { "seats", 10 } },
+	}
+	for _, c := range []struct {
+		format string
+		fields []string
+		out    string
+	}{
+		{ "rec"  , nil, "ID: cus_1\nEmail: a@b.c\nSeats: 2\n\nID: cus_22\nEmail: x\ty\n+ z\nSeats: 10\n\n" },
+		{ "jsonl", nil, "{\"id\":\"cus_1\",\"email\":\"a@b.c\",\"seats\":2}\n{\"id\":\"cus_22\",\"email\":\"x\\ty\\nz\",\"seats\":10}\n" },
+		{ "json" , []string{ "seats" }, "[\n  {\"seats\":2},\n  {\"seats\":10}\n]\n" },
+		{ "csv"  , []string{ "seats", "id" }, "seats,id\n2,cus_1\n10,cus_22\n" },
+		{ "tsv"  , []string{ "email" }, "email\na@b.c\nx y z\n" },
+		{ "table", []string{ "id", "seats" }, "ID     SEATS\ncus_1  2\ncus_22 10\n" },
+	} {
+		buf := bytes.Buffer{}
+		r, err := NewRenderer(&buf, c.format, c.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range recs {
+			if err = r.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = r.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.out {
+			t.Errorf("%s: got %q, want %q", c.format, buf.String(), c.out)
+		}
+	}
+	for key, name := range map[string]string{ "tax_ids": "TaxIDs", "invoice_url": "InvoiceURL", "grace_end": "GraceEnd", "Line": "Line" } {
+		if n := outputRecKey(key); n != name {
+			t.Errorf("%s: got %s, want %s", key, n, name)
+		}
+	}
+	if _, err := NewRenderer(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Errorf("xml: expected error")
+	}
+}
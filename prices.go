@@ -0,0 +1,144 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/price"
+	"strconv"
+	"strings"
+	"fmt"
+)
+
+// PriceList returns the active prices of a product.
+func PriceList(prodID string) (prices []*stripe.Price, err error) {
+	if v, found := cacheGet("prices", prodID); found {
+		return v.([]*stripe.Price), nil
+	}
+	p := &stripe.PriceListParams{}
+	p.Active  = stripe.Bool(true)
+	p.Product = stripe.String(prodID)
+	p.Filters.AddFilter("limit", "", "100")
+	i := price.List(p)
+	for i.Next() {
+		prices = append(prices, i.Price())
+	}
+	if err = i.Err(); err != nil {
+		return
+	}
+	cachePut("prices", prodID, prices)
+	return
+}
+
+// PriceLookup searches an active price by lookup key, misses are
+// also cached.
+func PriceLookup(key string) (pr *stripe.Price, found bool, err error) {
+	if v, f := cacheGet("lookup", key); f {
+		pr = v.(*stripe.Price)
+		return pr, pr != nil, nil
+	}
+	p := &stripe.PriceListParams{}
+	p.Active     = stripe.Bool(true)
+	p.LookupKeys = []*string{ stripe.String(key) }
+	i := price.List(p)
+	if i.Next() {
+		pr = i.Price()
+	} else if err = i.Err(); err != nil {
+		return
+	}
+	cachePut("lookup", key, pr)
+	return pr, pr != nil, nil
+}
+
+// PriceSelect returns the product's price with the given interval
+// ("month", "year", "3 month", ...), the default price when empty.
+func PriceSelect(prodID, interval string) (priceID string, err error) {
+	var prices []*stripe.Price
+	if len(interval)==0 {
+		return Product2Price(prodID)
+	}
+	prices, err = PriceList(prodID)
+	if err != nil {
+		return
+	}
+	for _, p := range prices {
+		if PriceMatchesInterval(p, interval) {
+			return p.ID, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no price with interval %s", prodID, interval)
+}
+
+// PriceResolve returns the price ID of a price ID, lookup key or
+// product. For products the interval selects the price.
+func PriceResolve(key, interval string) (priceID string, err error) {
+	var p     *stripe.Price
+	var found  bool
+	if strings.HasPrefix(key, "price_") {
+		return key, nil
+	}
+	if v, f := cacheGet("resolve", key + " " + interval); f {
+		return v.(string), nil
+	}
+	p, found, err = PriceLookup(key)
+	switch {
+	case err != nil:
+		return
+	case !found:
+		priceID, err = PriceSelect(key, interval)
+	case len(interval)>0 && !PriceMatchesInterval(p, interval):
+		return "", fmt.Errorf("%s: the price is not for interval %s", key, interval)
+	default:
+		priceID = p.ID
+	}
+	if err == nil {
+		cachePut("resolve", key + " " + interval, priceID)
+	}
+	return
+}
+
+// PriceMatchesInterval checks the recurring interval of the price,
+// intervals are in "[COUNT ]UNIT" format.
+func PriceMatchesInterval(p *stripe.Price, interval string) bool {
+	var count int64 = 1
+	countS, unit, found := strings.Cut(strings.TrimSpace(interval), " ")
+	if found {
+		c, err := strconv.ParseInt(countS, 10, 64)
+		if err != nil {
+			return false
+		}
+		count = c
+	} else {
+		unit = countS
+	}
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "s"), "ly")
+	if unit == "dai" {
+		unit = "day"
+	}
+	return p.Recurring != nil && string(p.Recurring.Interval) == unit && p.Recurring.IntervalCount == count
+}
+
+// PricePrint prints the price to the terminal.
+func PricePrint(p *stripe.Price, isDefault bool) {
+	var def string
+	if isDefault {
+		def = "*"
+	}
+	fmt.Printf("%-30s %-1s key=%-20s i=%s\n", p.ID, def, p.LookupKey, PriceFormat(p))
+}
+
+// PriceRecord returns the price as a Record for Renderer.
+func PriceRecord(p *stripe.Price, isDefault bool) Record {
+	var interval string
+	if p.Recurring != nil {
+		interval = string(p.Recurring.Interval)
+	}
+	return Record{
+		{ "id"        , p.ID },
+		{ "product"   , p.Product.ID },
+		{ "default"   , isDefault },
+		{ "lookup_key", p.LookupKey },
+		{ "currency"  , string(p.Currency) },
+		{ "amount"    , p.UnitAmount },
+		{ "interval"  , interval },
+		{ "info"      , PriceFormat(p) },
+	}
+}
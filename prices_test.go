@@ -0,0 +1,67 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"testing"
+)
+
+func TestPriceMatchesInterval(t *testing.T) {
+	price := func (interval stripe.PriceRecurringInterval, count int64) *stripe.Price {
+		return &stripe.Price{ Recurring: &stripe.PriceRecurring{ Interval: interval, IntervalCount: count } }
+	}
+	res := []struct {
+		p        *stripe.Price
+		interval string
+		matches  bool
+	}{
+		{ price("month", 1), "month"   , true  },
+		{ price("month", 1), "monthly" , true  },
+		{ price("month", 1), "1 month" , true  },
+		{ price("month", 3), "3 months", true  },
+		{ price("month", 3), "month"   , false },
+		{ price("year" , 1), "yearly"  , true  },
+		{ price("day"  , 1), "daily"   , true  },
+		{ price("week" , 2), "2 weeks" , true  },
+		{ price("week" , 1), "x week"  , false },
+		{ price("year" , 1), "month"   , false },
+		{ &stripe.Price{}  , "month"   , false },
+	}
+	for _, r := range res {
+		if m := PriceMatchesInterval(r.p, r.interval); m != r.matches {
+			t.Errorf("%v %+v: %v", r.interval, r.p.Recurring, m)
+		}
+	}
+}
+
+func TestPriceResolveCached(t *testing.T) {
+	defer CacheInvalidate("", "")
+	cachePut("lookup", "pro_monthly", &stripe.Price{ ID: "price_pro_m",
+		Recurring: &stripe.PriceRecurring{ Interval: "month", IntervalCount: 1 } })
+	cachePut("lookup", "prod_basic", (*stripe.Price)(nil))
+	cachePut("prices", "prod_basic", []*stripe.Price{
+		{ ID: "price_basic_m", Recurring: &stripe.PriceRecurring{ Interval: "month", IntervalCount: 1 } },
+		{ ID: "price_basic_y", Recurring: &stripe.PriceRecurring{ Interval: "year" , IntervalCount: 1 } },
+	})
+	res := []struct {
+		key, interval, id string
+	}{
+		{ "price_x"    , ""     , "price_x"       },
+		{ "pro_monthly", ""     , "price_pro_m"   },
+		{ "pro_monthly", "month", "price_pro_m"   },
+		{ "prod_basic" , "year" , "price_basic_y" },
+	}
+	for _, r := range res {
+		if id, err := PriceResolve(r.key, r.interval); err != nil || id != r.id {
+			t.Errorf("%s %s: %q %v", r.key, r.interval, id, err)
+		}
+	}
+	if _, err := PriceResolve("pro_monthly", "year"); err == nil {
+		t.Errorf("expected interval mismatch error")
+	}
+	if _, found, err := PriceLookup("prod_basic"); found || err != nil {
+		t.Errorf("cached miss not used: %v %v", found, err)
+	}
+	if v, found := cacheGet("resolve", "prod_basic year"); !found || v.(string) != "price_basic_y" {
+		t.Errorf("resolution not cached: %v", v)
+	}
+}
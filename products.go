@@ -25,21 +25,50 @@ func ProductPrint(p *stripe.Product, withPriceID, withPriceInfo bool) {
 		fmt.Printf(" p=%-30s", price.ID)
 	}
 	if price != nil && withPriceInfo {
-		fmt.Printf(" i=%v%s",
-			price.UnitAmount/100,
-			price.Currency)
-		if price.Recurring != nil {
-			fmt.Printf(",%v,%s",
-				price.Recurring.IntervalCount,
-				price.Recurring.Interval)
-		}
+		fmt.Printf(" i=%s", PriceFormat(price))
 	}
 	fmt.Printf(" n=%s\n", p.Name)
 }
 
+// ProductRecord returns the product as a Record for Renderer.
+func ProductRecord(p *stripe.Product) Record {
+	var priceID, priceInfo string
+	if p.DefaultPrice != nil {
+		priceID, priceInfo = p.DefaultPrice.ID, PriceFormat(p.DefaultPrice)
+	}
+	return Record{
+		{ "id"    , p.ID },
+		{ "name"  , p.Name },
+		{ "active", p.Active },
+		{ "price" , priceID },
+		{ "info"  , priceInfo },
+	}
+}
+
+// CheckoutRecord returns the checkout session as a Record for Renderer.
+func CheckoutRecord(ses *stripe.CheckoutSession) Record {
+	return Record{
+		{ "id" , ses.ID },
+		{ "url", ses.URL },
+	}
+}
+
 // ProductFetch .
 func ProductFetch(prodID string) (p *stripe.Product, err error) {
-	return product.Get(prodID, nil)
+	if v, found := cacheGet("product", prodID); found {
+		return v.(*stripe.Product), nil
+	}
+	if m := mirrorFresh(); m != nil {
+		if p = m.Products[prodID]; p != nil {
+			return p, nil
+		}
+	}
+	p, err = product.Get(prodID, nil)
+	if err != nil {
+		return
+	}
+	cachePut("product", prodID, p)
+	return
 }
 
 // Product2Price .
@@ -49,6 +78,10 @@ func Product2Price(prodID string) (priceID string, err error) {
 	if err != nil {
 		return
 	}
+	if prod.DefaultPrice == nil {
+		err = fmt.Errorf("%s: product without default price", prodID)
+		return
+	}
 	priceID = prod.DefaultPrice.ID
 	return
 }
@@ -62,9 +95,11 @@ func SubscriptionNew(m map[string]string) (ses *stripe.CheckoutSession, err erro
 	var quantity                 int
 	var quantityS, tax           string
 	var priceID                  string
+	var price                   *stripe.Price
 	var customerID               string = m["customer"]
 	var email                    string = m["email"]
 	var reference                string = m["reference"]
+	var interval                 string = m["interval"]
 
 	items = []*stripe.CheckoutSessionLineItemParams {}
 
@@ -101,7 +136,7 @@ func SubscriptionNew(m map[string]string) (ses *stripe.CheckoutSession, err erro
 			continue
 		}
 
-		priceID, err = Product2Price(key[1:])
+		priceID, err = PriceResolve(key[1:], interval)
 		if err != nil {
 			return
 		}
@@ -113,16 +148,24 @@ func SubscriptionNew(m map[string]string) (ses *stripe.CheckoutSession, err erro
 				return
 			}
 		}
-		quantity, err = strconv.Atoi(quantityS)
+		item := &stripe.CheckoutSessionLineItemParams{
+			Price:    stripe.String(priceID),
+			TaxRates: []*string{stripe.String(tax)},
+		}
+
+		/* Metered prices don't accept a quantity. */
+		price, err = PriceFetch(priceID)
 		if err != nil {
 			return
 		}
-		
-		items = append(items, &stripe.CheckoutSessionLineItemParams{
-			Price:    stripe.String(priceID),
-			Quantity: stripe.Int64(int64(quantity)),
-			TaxRates: []*string{stripe.String(tax)},
-		})
+		if price.Recurring == nil || price.Recurring.UsageType != stripe.PriceRecurringUsageTypeMetered {
+			quantity, err = strconv.Atoi(quantityS)
+			if err != nil {
+				return
+			}
+			item.Quantity = stripe.Int64(int64(quantity))
+		}
+		items = append(items, item)
 	}
 
 	if len(items) == 0 {
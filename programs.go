@@ -22,6 +22,44 @@ func PasswordHash(password string) (hash string, err error) {
 	return
 }
 
+// PasswordHashKnown returns true for the crypt(3) formats supported
+// by PasswordVerify: MD5 ($1$), SHA-256 ($5$) and SHA-512 ($6$).
+func PasswordHashKnown(hash string) bool {
+	_, _, ok := passwordHashParse(hash)
+	return ok
+}
+
+// PasswordVerify checks the password against a hash created by
+// PasswordHash or imported in one of the known formats.
+func PasswordVerify(password, hash string) (ok bool, err error) {
+	alg, salt, known := passwordHashParse(hash)
+	if !known {
+		return false, fmt.Errorf("unsupported password hash format")
+	}
+	cmd := exec.Command("openssl", "passwd", "-" + alg, "-salt", salt, "-stdin")
+	out := bytes.Buffer{}
+	cmd.Stdin = strings.NewReader(password)
+	cmd.Stdout = &out
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	calc, _, _ := strings.Cut(out.String(), "\n")
+	return calc == hash, nil
+}
+
+var passwordHashRegexp = regexp.MustCompile(`^\$([156])\$([^$]{1,16})\$[./0-9A-Za-z]+$`)
+
+// passwordHashParse returns the algorithm and salt of the hash, MD5
+// salts have up to 8 characters.
+func passwordHashParse(hash string) (alg, salt string, ok bool) {
+	m := passwordHashRegexp.FindStringSubmatch(hash)
+	if m == nil || (m[1] == "1" && len(m[2]) > 8) {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
 // PasswordCheck checks the password is usable.
 func PasswordCheck(password string) (err error) {
 	var cmd *exec.Cmd
@@ -0,0 +1,51 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"testing"
+	"time"
+)
+
+func TestPasswordVerify(t *testing.T) {
+	hash, err := PasswordHash("secret")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, h := range []string{
+		hash,
+		"$5$abc$qsg6EHbUzHQzF1POlD7zUwBINSELQxypPaeDcZe6vH0",
+		"$6$abc$IdWKNKTJEb8LxY7CGg8YBXlvtfZzFw7Mp/r6niK9YB2mdvgY..TKjv1T..8RadRt2qvUHYRLr/TsVArtr91iR1",
+	} {
+		if ok, err := PasswordVerify("secret", h); err != nil || !ok {
+			t.Errorf("%s: secret not accepted (%v)", h, err)
+		}
+		if ok, _ := PasswordVerify("Secret", h); ok {
+			t.Errorf("%s: Secret accepted", h)
+		}
+	}
+	if !PasswordHashKnown("$1$saltsalt$qjQvCtuaE4sSaZEBDhz0T/") || PasswordHashKnown("$1$saltsalt9$qjQvCtuaE4sSaZEBDhz0T/") {
+		t.Errorf("MD5 salts have up to 8 characters")
+	}
+	if PasswordHashKnown("$2y$10$abcdefghijklmnopqrstuv") {
+		t.Errorf("bcrypt hashes are not supported")
+	}
+}
+
+func TestUserLoginMasterHash(t *testing.T) {
+	hash, err := PasswordHash("secret")
+	if err != nil {
+		t.Skip(err)
+	}
+	m := mirrorNew()
+	m.SyncedAt = time.Now().Unix()
+	m.Customers["cus_1"] = &stripe.Customer{ ID: "cus_1", Email: "a@b.c", Metadata: map[string]string{ "hash1": hash } }
+	testMirror(t, m)
+	defer func(h string) { MasterPasswordHash = h }(MasterPasswordHash)
+	MasterPasswordHash = "$2y$10$malformed"
+	if _, err = UserLogin("a@b.c", "secret"); err != nil {
+		t.Errorf("malformed master hash broke the login: %v", err)
+	}
+	if _, err = UserLogin("a@b.c", "other"); err == nil {
+		t.Errorf("wrong password accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/client"
+	"fmt"
+)
+
+// PromoteKey is the metadata key used to match products and tax rates
+// between test and live mode, when missing products are matched by ID
+// and tax rates by their attributes. Prices are matched by amount,
+// currency and interval.
+const PromoteKey = "lookup_key"
+
+// Promote compares the catalog and tax rates of the test and live
+// accounts and, unless dry, creates in live mode what is missing.
+// Nothing is updated or archived in live mode, products that differ
+// are returned with the "!" action. The created products don't get
+// the CatalogManagedKey tag, so catalog-apply doesn't archive them.
+func Promote(dry bool) (changes []CatalogChange, err error) {
+	var test, live   *client.API
+	var cat          *Catalog
+	var liveProds    []*stripe.Product
+	var livePrices   []*stripe.Price
+	var catChanges   []CatalogChange
+	var catDiffer    []CatalogChange
+	var taxChanges   []CatalogChange
+	var taxCreate    []*stripe.TaxRate
+
+	if len(TestSecretKey)==0 || len(LiveSecretKey)==0 {
+		return nil, fmt.Errorf("both STRIPE_TEST_SECRET_KEY and STRIPE_SECRET_KEY are needed")
+	}
+	test = client.New(TestSecretKey, nil)
+	live = client.New(LiveSecretKey, nil)
+
+	/* Products and prices. */
+	cat, err = CatalogFetch(test)
+	if err != nil {
+		return
+	}
+	liveProds, livePrices, err = catalogState(live)
+	if err != nil {
+		return
+	}
+	byKey := map[string]string{}
+	for _, p := range liveProds {
+		if k := p.Metadata[PromoteKey]; len(k)>0 {
+			byKey[k] = p.ID
+		}
+	}
+	for _, cp := range cat.Products {
+		cp.unmanaged = true
+		if id, found := byKey[cp.Metadata[PromoteKey]]; found && len(cp.Metadata[PromoteKey])>0 {
+			cp.ID = id
+		}
+	}
+	catChanges, catDiffer = promoteChanges(CatalogPlan(cat, liveProds, livePrices))
+
+	/* Tax rates. */
+	taxChanges, taxCreate, err = promoteTaxRates(test, live)
+	if err != nil {
+		return
+	}
+	changes = append(append(catChanges, catDiffer...), taxChanges...)
+	if dry {
+		return
+	}
+
+	err = catalogExecuteAll(live, catChanges)
+	if err != nil {
+		return
+	}
+	for _, t := range taxCreate {
+		params := &stripe.TaxRateParams{
+			Active:       stripe.Bool(true),
+			Country:      stripe.String(t.Country),
+			Description:  stripe.String(t.Description),
+			DisplayName:  stripe.String(t.DisplayName),
+			Inclusive:    stripe.Bool(t.Inclusive),
+			Jurisdiction: stripe.String(t.Jurisdiction),
+			Percentage:   stripe.Float64(t.Percentage),
+			State:        stripe.String(t.State),
+		}
+		if len(t.TaxType)>0 {
+			params.TaxType = stripe.String(string(t.TaxType))
+		}
+		for k, v := range t.Metadata {
+			params.AddMetadata(k, v)
+		}
+		_, err = live.TaxRates.New(params)
+		if err != nil {
+			return changes, fmt.Errorf("tax rate %s: %s", t.ID, err)
+		}
+	}
+	return
+}
+
+// promoteChanges returns the creations of the plan along with the
+// default prices of the created products, and the updates with the
+// "!" action.
+func promoteChanges(plan []CatalogChange) (apply, differ []CatalogChange) {
+	created := map[string]bool{}
+	for _, ch := range plan {
+		if ch.Action == "+" && ch.Kind == "product" {
+			created[ch.Product] = true
+		}
+	}
+	for _, ch := range plan {
+		switch {
+		case ch.Action == "+":
+			apply = append(apply, ch)
+		case ch.Kind == "default" && created[ch.Product]:
+			apply = append(apply, ch)
+		case ch.Action == "~":
+			ch.Action = "!"
+			differ = append(differ, ch)
+		}
+	}
+	return
+}
+
+func promoteTaxRates(test, live *client.API) (changes []CatalogChange, create []*stripe.TaxRate, err error) {
+	var testTaxes, liveTaxes []*stripe.TaxRate
+	if testTaxes, err = promoteTaxList(test); err != nil {
+		return
+	}
+	if liveTaxes, err = promoteTaxList(live); err != nil {
+		return
+	}
+	for _, t := range testTaxes {
+		found := false
+		for _, l := range liveTaxes {
+			if promoteTaxKey(t) == promoteTaxKey(l) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			create = append(create, t)
+			changes = append(changes, CatalogChange{ Action: "+", Kind: "taxrate", Product: "-",
+				Detail: fmt.Sprintf("%s %v%% %s", t.DisplayName, t.Percentage, t.Jurisdiction) })
+		}
+	}
+	return
+}
+
+func promoteTaxList(api *client.API) (taxes []*stripe.TaxRate, err error) {
+	p := &stripe.TaxRateListParams{ Active: stripe.Bool(true) }
+	p.Filters.AddFilter("limit", "", "100")
+	i := api.TaxRates.List(p)
+	for i.Next() {
+		taxes = append(taxes, i.TaxRate())
+	}
+	return taxes, i.Err()
+}
+
+func promoteTaxKey(t *stripe.TaxRate) string {
+	if k := t.Metadata[PromoteKey]; len(k)>0 {
+		return k
+	}
+	return fmt.Sprintf("%s|%v|%s|%s|%s|%v", t.DisplayName, t.Percentage, t.Jurisdiction,
+		t.Country, t.State, t.Inclusive)
+}
@@ -0,0 +1,170 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/charge"
+	"github.com/stripe/stripe-go/v73/creditnote"
+	"github.com/stripe/stripe-go/v73/invoice"
+	"github.com/stripe/stripe-go/v73/refund"
+	"time"
+	"fmt"
+)
+
+// RefundCharge refunds a charge of the customer. The amount is in
+// major units of the charge's currency, empty refunds the remaining
+// amount. Reason is "duplicate", "fraudulent" or "requested_by_customer".
+func RefundCharge(email, chargeID string, amount string, reason string) (r *stripe.Refund, err error) {
+	var ch    *stripe.Charge
+	var minor  int64
+	ch, err = charge.Get(chargeID, nil)
+	if err != nil {
+		return
+	}
+	if err = refundCheckCustomer(email, ch.Customer); err != nil {
+		return
+	}
+	params := &stripe.RefundParams{ Charge: stripe.String(ch.ID) }
+	if len(amount)>0 {
+		if minor, err = refundAmount(amount, ch.Currency); err != nil {
+			return
+		}
+		params.Amount = stripe.Int64(minor)
+	}
+	if len(reason)>0 {
+		params.Reason = stripe.String(reason)
+	}
+	return refund.New(params)
+}
+
+// RefundInvoice refunds the charge of a paid invoice of the customer.
+// When invoiceID is empty the last paid invoice is refunded.
+func RefundInvoice(email, invoiceID string, amount string, reason string) (r *stripe.Refund, err error) {
+	var inv *stripe.Invoice
+	inv, err = refundInvoice(email, invoiceID)
+	if err != nil {
+		return
+	}
+	if inv.Charge == nil {
+		return nil, fmt.Errorf("%s: the invoice has no charge", inv.ID)
+	}
+	return RefundCharge(email, inv.Charge.ID, amount, reason)
+}
+
+// CreditNoteNew issues a credit note for an invoice of the customer
+// (the last paid when empty). The amount is in major units of the
+// invoice's currency, empty credits the whole invoice. For paid
+// invoices the amount is refunded when refund is true, otherwise
+// credited to the customer balance.
+func CreditNoteNew(email, invoiceID string, amount string, reason, memo string, refund bool) (cn *stripe.CreditNote, err error) {
+	var inv   *stripe.Invoice
+	var minor  int64
+	inv, err = refundInvoice(email, invoiceID)
+	if err != nil {
+		return
+	}
+	minor = inv.Total
+	if len(amount)>0 {
+		if minor, err = refundAmount(amount, inv.Currency); err != nil {
+			return
+		}
+	}
+	params := &stripe.CreditNoteParams{
+		Invoice: stripe.String(inv.ID),
+		Amount:  stripe.Int64(minor),
+	}
+	if inv.Status == stripe.InvoiceStatusPaid {
+		if refund {
+			params.RefundAmount = stripe.Int64(minor)
+		} else {
+			params.CreditAmount = stripe.Int64(minor)
+		}
+	}
+	if len(reason)>0 {
+		params.Reason = stripe.String(reason)
+	}
+	if len(memo)>0 {
+		params.Memo = stripe.String(memo)
+	}
+	return creditnote.New(params)
+}
+
+// RefundPrintREC prints the refund information to the terminal.
+func RefundPrintREC(r *stripe.Refund) {
+	fmt.Printf("ID: %s\n", r.ID)
+	fmt.Printf("Status: %s\n", r.Status)
+	fmt.Printf("Amount: %s\n", NewMoney(r.Amount, r.Currency))
+	if r.Charge != nil {
+		fmt.Printf("Charge: %s\n", r.Charge.ID)
+	}
+	if r.Reason != "" {
+		fmt.Printf("Reason: %s\n", r.Reason)
+	}
+	fmt.Printf("Date: %s\n", time.Unix(r.Created, 0).Format(time.RFC3339))
+	fmt.Printf("\n")
+}
+
+// CreditNotePrintREC prints the credit note information to the terminal.
+func CreditNotePrintREC(cn *stripe.CreditNote) {
+	fmt.Printf("ID: %s\n", cn.ID)
+	fmt.Printf("Number: %s\n", cn.Number)
+	fmt.Printf("Status: %s\n", cn.Status)
+	if cn.Invoice != nil {
+		fmt.Printf("Invoice: %s\n", cn.Invoice.ID)
+	}
+	fmt.Printf("Total: %s\n", NewMoney(cn.Total, cn.Currency))
+	if cn.Refund != nil {
+		fmt.Printf("Refund: %s\n", cn.Refund.ID)
+	}
+	if cn.Reason != "" {
+		fmt.Printf("Reason: %s\n", cn.Reason)
+	}
+	if cn.Memo != "" {
+		fmt.Printf("Memo: %s\n", cn.Memo)
+	}
+	if cn.PDF != "" {
+		fmt.Printf("PDF: %s\n", cn.PDF)
+	}
+	fmt.Printf("\n")
+}
+
+// refundAmount parses a refund amount, it must be positive.
+func refundAmount(amount string, currency stripe.Currency) (minor int64, err error) {
+	if minor, err = ParseMoney(amount, string(currency)); err != nil {
+		return
+	}
+	if minor <= 0 {
+		return 0, fmt.Errorf("the amount must be positive: %s", amount)
+	}
+	return
+}
+
+func refundInvoice(email, invoiceID string) (inv *stripe.Invoice, err error) {
+	var invs []*stripe.Invoice
+	if len(invoiceID)>0 {
+		inv, err = invoice.Get(invoiceID, nil)
+		if err != nil {
+			return
+		}
+		return inv, refundCheckCustomer(email, inv.Customer)
+	}
+	invs, err = InvoiceList(email, "paid", time.Time{})
+	if err != nil {
+		return
+	}
+	if len(invs)==0 {
+		return nil, fmt.Errorf("the user has no paid invoices")
+	}
+	return invs[0], nil
+}
+
+func refundCheckCustomer(email string, c *stripe.Customer) (err error) {
+	id, found := UserID(email)
+	switch {
+	case !found:
+		return fmt.Errorf("user not found")
+	case c == nil || c.ID != id:
+		return fmt.Errorf("the payment doesn't belong to %s", email)
+	default:
+		return nil
+	}
+}
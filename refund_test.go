@@ -0,0 +1,19 @@
+package ustripe
+
+import (
+	"testing"
+)
+
+func TestRefundAmount(t *testing.T) {
+	if minor, err := refundAmount("12,50", "eur"); err != nil || minor != 1250 {
+		t.Errorf("refundAmount(12,50) = %v, %v", minor, err)
+	}
+	if minor, err := refundAmount("500", "jpy"); err != nil || minor != 500 {
+		t.Errorf("refundAmount(500 jpy) = %v, %v", minor, err)
+	}
+	for _, s := range []string{ "0", "-5", "0.00", "x" } {
+		if _, err := refundAmount(s, "eur"); err == nil {
+			t.Errorf("refundAmount(%q) accepted", s)
+		}
+	}
+}
@@ -4,25 +4,53 @@ import (
 	"github.com/stripe/stripe-go/v73"
 	"os"
 	"fmt"
+	"log"
 	"strings"
+	"time"
 )
 
 var ReleaseMode         bool
+var TestSecretKey       string = ""
+var LiveSecretKey       string = ""
 var TaxRate             string = ""
 var SendmailCommand     string = "msmtp -t"
 var MasterPasswordHash  string = ""
+var WebhookSecret       string = ""
+var WebhookPath         string = "/webhook"
+var HooksDirectory      string = "hooks"
+var DeadLetterDirectory string = "hooks-failed"
+var HookTimeout         time.Duration = 30 * time.Second
+var HookRetries         int           = 3
+var HookRetryDelay      time.Duration = 5 * time.Second
+var EventsFile          string = "events.jsonl"
+var MirrorFile          string = ""
+var MirrorMaxAge        time.Duration = 24 * time.Hour
+var CacheTTL            time.Duration = 10 * time.Minute
+var UsageFile           string = "usage.jsonl"
+var DunningFile         string = "dunning.json"
+var BackupPassword      string = ""
+var ErasureKey          string = ""
+var ErasureFile         string = "erasures.jsonl"
+var DunningSchedule     []time.Duration = []time.Duration{ 0, 72 * time.Hour, 168 * time.Hour }
+var DunningGrace        time.Duration = 14 * 24 * time.Hour
+var PastDueGrace        time.Duration = 0
+var TeamProduct         string = ""
 
 func init() {
-	var envKey, envTax string
+	var envKey, envTax, envHook string
 	ReleaseMode = len(os.Getenv("RELEASE_MODE"))>0
 	if (ReleaseMode) {
-		envKey = "STRIPE_SECRET_KEY"
-		envTax = "STRIPE_DEFAULT_TAXID"
+		envKey  = "STRIPE_SECRET_KEY"
+		envTax  = "STRIPE_DEFAULT_TAXID"
+		envHook = "STRIPE_WEBHOOK_SECRET"
 	} else {
-		envKey = "STRIPE_TEST_SECRET_KEY"
-		envTax = "STRIPE_TEST_DEFAULT_TAXID"
+		envKey  = "STRIPE_TEST_SECRET_KEY"
+		envTax  = "STRIPE_TEST_DEFAULT_TAXID"
+		envHook = "STRIPE_TEST_WEBHOOK_SECRET"
 	}
 
+	TestSecretKey = os.Getenv("STRIPE_TEST_SECRET_KEY")
+	LiveSecretKey = os.Getenv("STRIPE_SECRET_KEY")
 	stripe.Key = os.Getenv(envKey)
 	if len(stripe.Key)==0 {
 		panic("Please set " + envKey)
@@ -35,6 +63,79 @@ func init() {
 	}
 
 	MasterPasswordHash = os.Getenv("STRIPE_MASTER_PASSWORD_HASH1")
+	if len(MasterPasswordHash)>1 {
+		if !PasswordHashKnown(MasterPasswordHash) {
+			log.Printf("STRIPE_MASTER_PASSWORD_HASH1 ignored: unsupported password hash format")
+		}
+	}
+	for _, env := range []string{ "LC_ALL", "LC_MONETARY", "LANG" } {
+		if s = os.Getenv(env); len(s)>0 {
+			MoneyLanguage = Language(s)
+			break
+		}
+	}
+	WebhookSecret      = os.Getenv(envHook)
+
+	if s = os.Getenv("USTRIPE_HOOKS_DIR"); len(s)>0 {
+		HooksDirectory = s
+	}
+	if s = os.Getenv("USTRIPE_HOOKS_FAILED_DIR"); len(s)>0 {
+		DeadLetterDirectory = s
+	}
+	if s = os.Getenv("USTRIPE_EVENTS_FILE"); len(s)>0 {
+		EventsFile = s
+	}
+	if d, err := time.ParseDuration(os.Getenv("USTRIPE_HOOKS_TIMEOUT")); err == nil {
+		HookTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("USTRIPE_CACHE_TTL")); err == nil {
+		CacheTTL = d
+	}
+	if s = os.Getenv("USTRIPE_USAGE_FILE"); len(s)>0 {
+		UsageFile = s
+	}
+	if s = os.Getenv("USTRIPE_FACTURAE_XSD"); len(s)>0 {
+		FacturaeSchema = s
+	}
+	FacturaeSeller.TaxID    = os.Getenv("FACTURAE_SELLER_TAXID")
+	FacturaeSeller.Name     = os.Getenv("FACTURAE_SELLER_NAME")
+	FacturaeSeller.Address  = os.Getenv("FACTURAE_SELLER_ADDRESS")
+	FacturaeSeller.PostCode = os.Getenv("FACTURAE_SELLER_POSTCODE")
+	FacturaeSeller.Town     = os.Getenv("FACTURAE_SELLER_TOWN")
+	FacturaeSeller.Province = os.Getenv("FACTURAE_SELLER_PROVINCE")
+	if s = os.Getenv("USTRIPE_DUNNING_FILE"); len(s)>0 {
+		DunningFile = s
+	}
+	if s = os.Getenv("USTRIPE_DUNNING_SCHEDULE"); len(s)>0 {
+		DunningSchedule = nil
+		for _, f := range strings.Split(s, ",") {
+			if d, err := time.ParseDuration(strings.TrimSpace(f)); err == nil {
+				DunningSchedule = append(DunningSchedule, d)
+			}
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("USTRIPE_DUNNING_GRACE")); err == nil {
+		DunningGrace = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("USTRIPE_PAST_DUE_GRACE")); err == nil {
+		PastDueGrace = d
+	}
+	if s = os.Getenv("USTRIPE_ACCESS_STATUSES"); len(s)>0 {
+		AccessStatuses = map[stripe.SubscriptionStatus]bool{}
+		for _, f := range strings.Split(s, ",") {
+			AccessStatuses[stripe.SubscriptionStatus(strings.TrimSpace(f))] = true
+		}
+	}
+	BackupPassword = os.Getenv("USTRIPE_BACKUP_PASSWORD")
+	ErasureKey     = os.Getenv("USTRIPE_ERASURE_KEY")
+	if s = os.Getenv("USTRIPE_ERASURE_FILE"); len(s)>0 {
+		ErasureFile = s
+	}
+	TeamProduct = os.Getenv("USTRIPE_TEAM_PRODUCT")
+	MirrorFile  = os.Getenv("USTRIPE_MIRROR_FILE")
+	if d, err := time.ParseDuration(os.Getenv("USTRIPE_MIRROR_MAX_AGE")); err == nil {
+		MirrorMaxAge = d
+	}
 }
 
 func Language(f string) (t string) {
@@ -0,0 +1,170 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/subscription"
+	"time"
+	"fmt"
+)
+
+// SubscriptionInfo summarizes the state of a subscription.
+type SubscriptionInfo struct {
+	ID                string             `json:"id"`
+	Customer          string             `json:"customer"`
+	Status            string             `json:"status"`
+	CurrentPeriodEnd  int64              `json:"current_period_end"`
+	CancelAtPeriodEnd bool               `json:"cancel_at_period_end"`
+	TrialEnd          int64              `json:"trial_end,omitempty"`
+	Access            bool               `json:"access"`
+	Items             []SubscriptionItem `json:"items"`
+}
+
+// SubscriptionItem is a product/price of a subscription.
+type SubscriptionItem struct {
+	Product  string `json:"product"`
+	Price    string `json:"price"`
+	Quantity int64  `json:"quantity"`
+}
+
+// AccessStatuses are the subscription statuses that grant access.
+// A "past_due" subscription grants access during PastDueGrace after
+// the period end or while it is in the dunning grace period.
+var AccessStatuses = map[stripe.SubscriptionStatus]bool{
+	stripe.SubscriptionStatusActive:   true,
+	stripe.SubscriptionStatusTrialing: true,
+}
+
+// UserSubs retrieves all subscriptions of the user, in any status.
+func UserSubs(userID string) (subs []*stripe.Subscription, err error) {
+	m := mirrorFresh()
+	if m != nil && m.Customers[userID] != nil {
+		return m.userSubs(userID, ""), nil
+	}
+	params := &stripe.SubscriptionListParams{}
+	params.Filters.AddFilter("limit"   , "", "100")
+	params.Filters.AddFilter("customer", "", userID)
+	params.Filters.AddFilter("status"  , "", "all")
+	i := subscription.List(params)
+	for i.Next() {
+		subs = append(subs, i.Subscription())
+		if m != nil {
+			mirrorPutSubscription(i.Subscription())
+		}
+	}
+	err = i.Err()
+	return
+}
+
+// UserSubsInfo returns the state of all subscriptions of the user.
+func UserSubsInfo(userID string) (infos []*SubscriptionInfo, err error) {
+	subs, err := UserSubs(userID)
+	if err != nil {
+		return
+	}
+	for _, s := range subs {
+		infos = append(infos, NewSubscriptionInfo(s))
+	}
+	return
+}
+
+// SubscriptionAccess returns true if the subscription grants access
+// to its products.
+func SubscriptionAccess(s *stripe.Subscription) bool {
+	if AccessStatuses[s.Status] {
+		return true
+	}
+	if s.Status == stripe.SubscriptionStatusPastDue {
+		if PastDueGrace > 0 && time.Now().Before(time.Unix(s.CurrentPeriodEnd, 0).Add(PastDueGrace)) {
+			return true
+		}
+		return DunningGraced(s.ID)
+	}
+	return false
+}
+
+// SubscriptionCancel cancels the subscription now or at the end of
+// the current period.
+func SubscriptionCancel(id string, atPeriodEnd bool) (s *stripe.Subscription, err error) {
+	if atPeriodEnd {
+		p := &stripe.SubscriptionParams{ CancelAtPeriodEnd: stripe.Bool(true) }
+		s, err = subscription.Update(id, p)
+	} else {
+		s, err = subscription.Cancel(id, nil)
+	}
+	if err == nil {
+		mirrorPutSubscription(s)
+	}
+	return
+}
+
+// NewSubscriptionInfo summarizes a subscription.
+func NewSubscriptionInfo(s *stripe.Subscription) (i *SubscriptionInfo) {
+	i = &SubscriptionInfo{
+		ID:                s.ID,
+		Status:            string(s.Status),
+		CurrentPeriodEnd:  s.CurrentPeriodEnd,
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		TrialEnd:          s.TrialEnd,
+		Access:            SubscriptionAccess(s),
+	}
+	if s.Customer != nil {
+		i.Customer = s.Customer.ID
+	}
+	if s.Items != nil {
+		for _, item := range s.Items.Data {
+			if item.Price == nil || item.Price.Product == nil {
+				continue
+			}
+			i.Items = append(i.Items, SubscriptionItem{ item.Price.Product.ID, item.Price.ID, item.Quantity })
+		}
+	}
+	return
+}
+
+// Expires returns when the access ends or renews.
+func (i *SubscriptionInfo) Expires() time.Time {
+	if i.Status == string(stripe.SubscriptionStatusTrialing) && i.TrialEnd != 0 {
+		return time.Unix(i.TrialEnd, 0)
+	}
+	return time.Unix(i.CurrentPeriodEnd, 0)
+}
+
+// SubscriptionInfoRecord returns the subscription as a Record for Renderer.
+func SubscriptionInfoRecord(i *SubscriptionInfo) Record {
+	var prods []string
+	for _, item := range i.Items {
+		prods = append(prods, item.Product)
+	}
+	r := Record{
+		{ "id"                  , i.ID },
+		{ "customer"            , i.Customer },
+		{ "status"              , i.Status },
+		{ "access"              , i.Access },
+		{ "products"            , prods },
+		{ "current_period_end"  , time.Unix(i.CurrentPeriodEnd, 0).Format(time.RFC3339) },
+		{ "expires"             , i.Expires().Format(time.RFC3339) },
+		{ "cancel_at_period_end", i.CancelAtPeriodEnd },
+		{ "trial_end"           , "" },
+	}
+	if i.TrialEnd != 0 {
+		r[len(r)-1].Value = time.Unix(i.TrialEnd, 0).Format(time.RFC3339)
+	}
+	return r
+}
+
+// SubscriptionInfoPrintREC prints the subscription in recutils format.
+func SubscriptionInfoPrintREC(i *SubscriptionInfo) {
+	fmt.Printf("ID: %s\n", i.ID)
+	fmt.Printf("Status: %s\n", i.Status)
+	fmt.Printf("Access: %v\n", i.Access)
+	fmt.Printf("CurrentPeriodEnd: %s\n", time.Unix(i.CurrentPeriodEnd, 0).Format(time.RFC3339))
+	fmt.Printf("Expires: %s\n", i.Expires().Format(time.RFC3339))
+	fmt.Printf("CancelAtPeriodEnd: %v\n", i.CancelAtPeriodEnd)
+	if i.TrialEnd != 0 {
+		fmt.Printf("TrialEnd: %s\n", time.Unix(i.TrialEnd, 0).Format(time.RFC3339))
+	}
+	for _, item := range i.Items {
+		fmt.Printf("Item: %s %s %v\n", item.Product, item.Price, item.Quantity)
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,40 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionAccess(t *testing.T) {
+	defer func (g time.Duration, f string) { PastDueGrace, DunningFile = g, f }(PastDueGrace, DunningFile)
+	PastDueGrace = 3 * 24 * time.Hour
+	DunningFile  = filepath.Join(t.TempDir(), "dunning.json")
+	now := time.Now()
+	dunningSave(map[string]*Dunning{
+		"sub_dunning"   : { Subscription: "sub_dunning", Started: now.Add(-time.Hour).Unix() },
+		"sub_downgraded": { Subscription: "sub_downgraded", Started: now.Add(-time.Hour).Unix(), Downgraded: now.Unix() },
+	})
+	tests := []struct {
+		id        string
+		status    stripe.SubscriptionStatus
+		periodEnd time.Time
+		access    bool
+	}{
+		{ "sub_1"         , stripe.SubscriptionStatusActive  , now.Add(24 * time.Hour)     , true  },
+		{ "sub_1"         , stripe.SubscriptionStatusTrialing, now.Add(24 * time.Hour)     , true  },
+		{ "sub_1"         , stripe.SubscriptionStatusPastDue , now.Add(-24 * time.Hour)    , true  },
+		{ "sub_1"         , stripe.SubscriptionStatusPastDue , now.Add(-4 * 24 * time.Hour), false },
+		{ "sub_dunning"   , stripe.SubscriptionStatusPastDue , now.Add(-4 * 24 * time.Hour), true  },
+		{ "sub_downgraded", stripe.SubscriptionStatusPastDue , now.Add(-4 * 24 * time.Hour), false },
+		{ "sub_1"         , stripe.SubscriptionStatusUnpaid  , now.Add(24 * time.Hour)     , false },
+		{ "sub_1"         , stripe.SubscriptionStatusCanceled, now.Add(24 * time.Hour)     , false },
+	}
+	for _, tt := range tests {
+		s := &stripe.Subscription{ ID: tt.id, Status: tt.status, CurrentPeriodEnd: tt.periodEnd.Unix() }
+		if a := SubscriptionAccess(s); a != tt.access {
+			t.Errorf("%s %s ended %s: access=%v, want %v", tt.id, tt.status, tt.periodEnd.Format(time.RFC3339), a, tt.access)
+		}
+	}
+}
@@ -29,3 +29,15 @@ func TaxPrint(t *stripe.TaxRate) {
 		t.Percentage,
 		active)
 }
+
+// TaxRateRecord returns the tax rate as a Record for Renderer.
+func TaxRateRecord(t *stripe.TaxRate) Record {
+	return Record{
+		{ "id"          , t.ID },
+		{ "jurisdiction", t.Jurisdiction },
+		{ "name"        , t.DisplayName },
+		{ "percentage"  , t.Percentage },
+		{ "inclusive"   , t.Inclusive },
+		{ "active"      , t.Active },
+	}
+}
@@ -0,0 +1,249 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/customer"
+	"github.com/google/uuid"
+	"strings"
+	"strconv"
+	"time"
+	"fmt"
+)
+
+// TeamKey is the metadata key linking a member to the customer
+// (owner) paying for the team. The owner takes one seat.
+const TeamKey = "team"
+
+// TeamInviteKey is the metadata key of a pending invitation, it holds
+// the owner until the member accepts it with TeamAccept.
+const TeamInviteKey = "team_invite"
+
+// TeamEcodeKey is the metadata key of the invitation code, it is kept
+// apart from the "ecode" of the email validation.
+const TeamEcodeKey = "team_ecode"
+
+// TeamSeats returns the number of seats bought by the owner, the
+// quantity of TeamProduct in its paid subscriptions.
+func TeamSeats(ownerID string) (seats int64, err error) {
+	var subs []*stripe.Subscription
+	if subs, err = UserPaidSubsErr(ownerID); err != nil {
+		return
+	}
+	return teamSeats(subs, TeamProduct), nil
+}
+
+func teamSeats(subs []*stripe.Subscription, product string) (seats int64) {
+	if len(product)==0 {
+		return 0
+	}
+	for _, sub := range subs {
+		for _, item := range sub.Items.Data {
+			if item.Price != nil && item.Price.Product != nil && item.Price.Product.ID == product {
+				seats += item.Quantity
+			}
+		}
+	}
+	return
+}
+
+// teamSeatFree returns true when a new member fits in the seats, the
+// owner and the pending invitations take one seat each.
+func teamSeatFree(seats int64, members, invites int) bool {
+	return int64(members + invites) + 1 < seats
+}
+
+// TeamMembers returns the customers linked to the owner.
+func TeamMembers(ownerID string) (members []*stripe.Customer) {
+	return teamCustomers(TeamKey, ownerID)
+}
+
+// TeamInvites returns the customers invited by the owner that didn't
+// accept the invitation yet.
+func TeamInvites(ownerID string) (invites []*stripe.Customer) {
+	return teamCustomers(TeamInviteKey, ownerID)
+}
+
+func teamCustomers(key, ownerID string) (r []*stripe.Customer) {
+	m := mirrorFresh()
+	if m != nil {
+		for _, c := range m.Customers {
+			if c.Metadata[key] == ownerID {
+				r = append(r, c)
+			}
+		}
+		if len(r)>0 {
+			return
+		}
+	}
+	/* Nothing in the mirror may be a miss, ask Stripe. */
+	for i := UserIter(); i.Next(); {
+		if c := i.Customer(); c.Metadata[key] == ownerID {
+			r = append(r, c)
+			if m != nil && m.Customers[c.ID] == nil {
+				mirrorPutCustomer(c)
+			}
+		}
+	}
+	return
+}
+
+// TeamInvite stores a pending invitation in the member, creating the
+// customer when it doesn't exist, and mails the InvitationURL. The
+// member joins the team with TeamAccept.
+func TeamInvite(ownerEmail, memberEmail string) (member *stripe.Customer, err error) {
+	var owner   *stripe.Customer
+	var found    bool
+	var params  *stripe.CustomerParams
+	var seats    int64
+	var ecode    string
+
+	owner, found = UserSearch(ownerEmail)
+	if !found {
+		return nil, fmt.Errorf("team owner not found")
+	}
+	if len(owner.Metadata[TeamKey])>0 {
+		return nil, fmt.Errorf("the owner is member of another team")
+	}
+
+	/* Check the member. */
+	member, found = UserSearch(memberEmail)
+	switch {
+	case found && member.ID == owner.ID:
+		return nil, fmt.Errorf("the owner can't be invited")
+	case found && member.Metadata[TeamKey] == owner.ID:
+		return nil, fmt.Errorf("the user is already member of the team")
+	case found && len(member.Metadata[TeamKey])>0:
+		return nil, fmt.Errorf("the user is member of another team")
+	case found && len(member.Metadata[TeamInviteKey])>0 && member.Metadata[TeamInviteKey] != owner.ID:
+		return nil, fmt.Errorf("the user is invited to another team")
+	}
+
+	/* Check seats, invitations sent again don't take another. */
+	if !found || member.Metadata[TeamInviteKey] != owner.ID {
+		members, invites := TeamMembers(owner.ID), TeamInvites(owner.ID)
+		if seats, err = TeamSeats(owner.ID); err != nil {
+			return nil, err
+		} else if !teamSeatFree(seats, len(members), len(invites)) {
+			return nil, fmt.Errorf("no seats available (%v seats, %v members, %v invited)",
+				seats, len(members), len(invites))
+		}
+	}
+
+	/* Create the member or store the invitation. */
+	ecode  = uuid.New().String()
+	params = &stripe.CustomerParams{}
+	params.AddMetadata(TeamInviteKey, owner.ID)
+	params.AddMetadata(TeamEcodeKey, ecode)
+	if found {
+		member, err = customer.Update(member.ID, params)
+	} else {
+		params.Email = stripe.String(memberEmail)
+		params.AddMetadata("status", "unverified")
+		if len(owner.PreferredLocales)>0 {
+			params.PreferredLocales = []*string{ stripe.String(owner.PreferredLocales[0]) }
+		}
+		member, err = customer.New(params)
+	}
+	if err != nil {
+		return
+	}
+	mirrorPutCustomer(member)
+
+	err = SendMail(InvitationMail(owner, member, memberEmail, InvitationURL(ecode, memberEmail)))
+	return
+}
+
+// TeamAccept should be run when clicking the invitation link, it links
+// the member to the team and verifies its email. Members without
+// password, created by TeamInvite, must choose one.
+func TeamAccept(memberEmail, ecode, password string) (member *stripe.Customer, err error) {
+	var found   bool
+	var hash    string
+	var ownerID string
+	member, found = UserSearch(memberEmail)
+	switch {
+	case !found:
+		return nil, fmt.Errorf("user not found")
+	case len(member.Metadata[TeamInviteKey])==0:
+		return nil, fmt.Errorf("no pending invitation")
+	case len(member.Metadata[TeamEcodeKey])==0 || member.Metadata[TeamEcodeKey] != ecode:
+		return nil, fmt.Errorf("invalid invitation code")
+	}
+	password = strings.Trim(password, " \t\r\n")
+	if len(password)>0 {
+		if err = PasswordCheck(password); err != nil {
+			return
+		}
+		if hash, err = PasswordHash(password); err != nil {
+			return
+		}
+	} else if len(member.Metadata["hash1"])==0 {
+		return nil, fmt.Errorf("please choose a password")
+	}
+	ownerID = member.Metadata[TeamInviteKey]
+	if _, err = customer.Get(ownerID, nil); err != nil {
+		return nil, fmt.Errorf("team owner not found: %s", err)
+	}
+	params := &stripe.CustomerParams{}
+	params.AddMetadata(TeamKey, ownerID)
+	params.AddMetadata(TeamInviteKey, "")
+	params.AddMetadata(TeamEcodeKey, "")
+	params.AddMetadata("status", "verified")
+	if _, found := member.Metadata["verified_at"]; !found {
+		params.AddMetadata("verified_at", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	if len(hash)>0 {
+		params.AddMetadata("hash1", hash)
+	}
+	if member, err = customer.Update(member.ID, params); err != nil {
+		return
+	}
+	mirrorPutCustomer(member)
+	return
+}
+
+// TeamRemove unlinks a member from the owner's team, or cancels its
+// pending invitation.
+func TeamRemove(ownerEmail, memberEmail string) (err error) {
+	var owner, member *stripe.Customer
+	var found          bool
+	if owner, found = UserSearch(ownerEmail); !found {
+		return fmt.Errorf("team owner not found")
+	}
+	if member, found = UserSearch(memberEmail); !found {
+		return fmt.Errorf("member not found")
+	}
+	params := &stripe.CustomerParams{}
+	switch owner.ID {
+	case member.Metadata[TeamKey]:       params.AddMetadata(TeamKey, "")
+	case member.Metadata[TeamInviteKey]: params.AddMetadata(TeamInviteKey, ""); params.AddMetadata(TeamEcodeKey, "")
+	default:                             return fmt.Errorf("the user is not member of the team")
+	}
+	member, err = customer.Update(member.ID, params)
+	if err != nil {
+		return
+	}
+	mirrorPutCustomer(member)
+	return nil
+}
+
+// UserProducts returns the products the user is entitled to, its own
+// subscriptions plus the ones of its team.
+func UserProducts(u *stripe.Customer) (prods map[string]string, err error) {
+	var subs []*stripe.Subscription
+	if subs, err = UserPaidSubsErr(u.ID); err != nil {
+		return
+	}
+	prods = Subscription2Product(subs)
+	if ownerID := u.Metadata[TeamKey]; len(ownerID)>0 {
+		if subs, err = UserPaidSubsErr(ownerID); err != nil {
+			return
+		}
+		for prod, price := range Subscription2Product(subs) {
+			if _, found := prods[prod]; !found {
+				prods[prod] = price
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"testing"
+)
+
+func TestTeamSeats(t *testing.T) {
+	item := func (product string, quantity int64) *stripe.SubscriptionItem {
+		return &stripe.SubscriptionItem{ Quantity: quantity, Price: &stripe.Price{ Product: &stripe.Product{ ID: product } } }
+	}
+	sub := func (items ...*stripe.SubscriptionItem) *stripe.Subscription {
+		return &stripe.Subscription{ Items: &stripe.SubscriptionItemList{ Data: items } }
+	}
+	res := []struct {
+		subs    []*stripe.Subscription
+		product string
+		seats   int64
+	}{
+		{ []*stripe.Subscription{ sub(item("prod_seat", 5)) }, "prod_seat", 5 },
+		{ []*stripe.Subscription{ sub(item("prod_seat", 3), item("prod_storage", 100)) }, "prod_seat", 3 },
+		{ []*stripe.Subscription{ sub(item("prod_seat", 3)), sub(item("prod_seat", 2)) }, "prod_seat", 5 },
+		{ []*stripe.Subscription{ sub(item("prod_storage", 100)) }, "prod_seat", 0 },
+		{ []*stripe.Subscription{ sub(item("prod_seat", 5)) }, "", 0 },
+		{ nil, "prod_seat", 0 },
+	}
+	for i, r := range res {
+		if seats := teamSeats(r.subs, r.product); seats != r.seats {
+			t.Errorf("%d: %v seats, expected %v", i, seats, r.seats)
+		}
+	}
+}
+
+func TestTeamSeatFree(t *testing.T) {
+	res := []struct {
+		seats            int64
+		members, invites int
+		free             bool
+	}{
+		{ 0, 0, 0, false },
+		{ 1, 0, 0, false }, // The owner takes the only seat.
+		{ 2, 0, 0, true  },
+		{ 3, 1, 0, true  },
+		{ 3, 1, 1, false }, // Pending invitations take a seat.
+		{ 5, 2, 1, true  },
+	}
+	for _, r := range res {
+		if free := teamSeatFree(r.seats, r.members, r.invites); free != r.free {
+			t.Errorf("%v seats, %v members, %v invites: %v", r.seats, r.members, r.invites, free)
+		}
+	}
+}
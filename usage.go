@@ -0,0 +1,324 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/usagerecord"
+	"github.com/stripe/stripe-go/v73/usagerecordsummary"
+	"github.com/google/uuid"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/hex"
+	"strings"
+	"bufio"
+	"bytes"
+	"sync"
+	"time"
+	"sort"
+	"fmt"
+	"os"
+)
+
+// Usage is a usage record kept in UsageFile until flushed. The key,
+// unique per report, is the idempotency key sent to Stripe. Records
+// kept after a failed flush are marked for retry and sent again with
+// the same key.
+type Usage struct {
+	Email     string `json:"email"`
+	Product   string `json:"product"`
+	Quantity  int64  `json:"quantity"`
+	Timestamp int64  `json:"timestamp"`
+	Action    string `json:"action"`
+	Key       string `json:"key,omitempty"`
+	Retry     bool   `json:"retry,omitempty"`
+}
+
+var usageMutex sync.Mutex
+
+// UsageReport reports usage of a metered product (product ID, price
+// ID or lookup key) to Stripe. Action is "increment" or "set". Each
+// call gets a random idempotency key, so equal reports in the same
+// second are all counted; calling it again after an error may count
+// the usage twice, use UsageBuffer for safe retries.
+func UsageReport(email, product string, quantity int64, timestamp time.Time, action string) (rec *stripe.UsageRecord, err error) {
+	return usageReport(Usage{
+		Email:     email,
+		Product:   product,
+		Quantity:  quantity,
+		Timestamp: timestamp.Unix(),
+		Action:    action,
+		Key:       uuid.New().String(),
+	})
+}
+
+// UsageBuffer saves usage in UsageFile, it is sent to Stripe
+// aggregated by UsageFlush.
+func UsageBuffer(email, product string, quantity int64, timestamp time.Time, action string) (err error) {
+	var data []byte
+	var fp   *os.File
+	u := Usage{ email, product, quantity, timestamp.Unix(), action, uuid.New().String(), false }
+	if err = u.check(); err != nil {
+		return
+	}
+	data, err = json.Marshal(u)
+	if err != nil {
+		return
+	}
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	fp, err = os.OpenFile(UsageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	_, err = fp.Write(append(data, '\n'))
+	return
+}
+
+// UsageFlush sends the buffered usage aggregated by customer, product,
+// action and billing period. Failed records are kept in the buffer.
+func UsageFlush() (n int, err error) {
+	var pending []Usage
+	var failed  []Usage
+	var data    []byte
+
+	flushing := UsageFile + ".flushing"
+
+	/* Move the buffer aside, an interrupted flush is resent with the
+	 * same idempotency keys. */
+	usageMutex.Lock()
+	if _, serr := os.Stat(flushing); os.IsNotExist(serr) {
+		err = os.Rename(UsageFile, flushing)
+		if os.IsNotExist(err) {
+			usageMutex.Unlock()
+			return 0, nil
+		}
+	}
+	usageMutex.Unlock()
+	if err != nil {
+		return
+	}
+	data, err = os.ReadFile(flushing)
+	if err != nil {
+		return
+	}
+	pending, err = usageParse(data)
+	if err != nil {
+		return
+	}
+
+	for _, u := range UsageAggregate(pending, usagePeriods()) {
+		_, rerr := usageReport(u)
+		if rerr != nil {
+			err = rerr
+			u.Retry = true
+			failed = append(failed, u)
+			continue
+		}
+		n++
+	}
+
+	/* Keep failed records. */
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	for _, u := range failed {
+		line, _ := json.Marshal(u)
+		fp, ferr := os.OpenFile(UsageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if ferr != nil {
+			return n, ferr
+		}
+		fp.Write(append(line, '\n'))
+		fp.Close()
+	}
+	os.Remove(flushing)
+	return
+}
+
+// UsageAggregate sums "increment" records and keeps the last "set"
+// record of each customer, product and billing period, as returned by
+// the period function (the period start). The key of a sum is derived
+// from the keys of its records, records marked for retry are kept
+// apart.
+func UsageAggregate(records []Usage, period func (u Usage) int64) (r []Usage) {
+	idx  := map[string]int{}
+	keys := map[int][]string{}
+	for _, u := range records {
+		if u.Retry {
+			r = append(r, u)
+			continue
+		}
+		group := fmt.Sprintf("%s\x00%s\x00%s\x00%d", u.Email, u.Product, u.Action, period(u))
+		i, found := idx[group]
+		switch {
+		case !found:
+			idx[group] = len(r)
+			keys[len(r)] = []string{ u.usageKey() }
+			r = append(r, u)
+		case u.Action == stripe.UsageRecordActionSet:
+			if u.Timestamp >= r[i].Timestamp {
+				r[i] = u
+			}
+		default:
+			r[i].Quantity += u.Quantity
+			if u.Timestamp > r[i].Timestamp {
+				r[i].Timestamp = u.Timestamp
+			}
+			keys[i] = append(keys[i], u.usageKey())
+		}
+	}
+	for i, k := range keys {
+		if len(k) > 1 {
+			sort.Strings(k)
+			sum := sha256.Sum256([]byte(strings.Join(k, "|")))
+			r[i].Key = "sum-" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return
+}
+
+// UsageSummary returns the usage summaries of a customer's product.
+func UsageSummary(email, product string) (sums []*stripe.UsageRecordSummary, err error) {
+	var item *stripe.SubscriptionItem
+	item, err = usageItem(email, product)
+	if err != nil {
+		return
+	}
+	p := &stripe.UsageRecordSummaryListParams{ SubscriptionItem: stripe.String(item.ID) }
+	p.Filters.AddFilter("limit", "", "100")
+	i := usagerecordsummary.List(p)
+	for i.Next() {
+		sums = append(sums, i.UsageRecordSummary())
+	}
+	return sums, i.Err()
+}
+
+// UsageSummaryPrint prints a usage summary to the terminal.
+func UsageSummaryPrint(s *stripe.UsageRecordSummary) {
+	var start, end string
+	if s.Period != nil {
+		start = time.Unix(s.Period.Start, 0).Format("2006-01-02")
+		end   = time.Unix(s.Period.End, 0).Format("2006-01-02")
+	}
+	fmt.Printf("%-10s %-10s total=%-10v invoice=%s\n", start, end, s.TotalUsage, s.Invoice)
+}
+
+// UsageSummaryRecord returns the usage summary as a Record for Renderer.
+func UsageSummaryRecord(s *stripe.UsageRecordSummary) Record {
+	var start, end string
+	if s.Period != nil {
+		start = time.Unix(s.Period.Start, 0).Format("2006-01-02")
+		end   = time.Unix(s.Period.End, 0).Format("2006-01-02")
+	}
+	return Record{
+		{ "start"  , start },
+		{ "end"    , end },
+		{ "total"  , s.TotalUsage },
+		{ "invoice", s.Invoice },
+	}
+}
+
+func usageReport(u Usage) (rec *stripe.UsageRecord, err error) {
+	var item *stripe.SubscriptionItem
+	if err = u.check(); err != nil {
+		return
+	}
+	item, err = usageItem(u.Email, u.Product)
+	if err != nil {
+		return
+	}
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(item.ID),
+		Quantity:         stripe.Int64(u.Quantity),
+		Timestamp:        stripe.Int64(u.Timestamp),
+		Action:           stripe.String(u.Action),
+	}
+	params.SetIdempotencyKey("ustripe-usage-" + u.usageKey())
+	return usagerecord.New(params)
+}
+
+// usageKey returns the key of the record, records buffered before keys
+// were introduced get one derived from their content.
+func (u Usage) usageKey() string {
+	if len(u.Key)>0 {
+		return u.Key
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%v|%v|%s",
+		u.Email, u.Product, u.Quantity, u.Timestamp, u.Action)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// usagePeriods returns a period function for UsageAggregate, the start
+// of the subscription's current period for records in it. Records of
+// other periods are not aggregated.
+func usagePeriods() func (u Usage) int64 {
+	subs := map[string]*stripe.Subscription{}
+	return func (u Usage) int64 {
+		key := u.Email + "\x00" + u.Product
+		sub, found := subs[key]
+		if !found {
+			sub, _, _ = usageSubItem(u.Email, u.Product)
+			subs[key] = sub
+		}
+		if sub == nil || u.Timestamp < sub.CurrentPeriodStart || u.Timestamp >= sub.CurrentPeriodEnd {
+			return u.Timestamp
+		}
+		return sub.CurrentPeriodStart
+	}
+}
+
+func usageItem(email, product string) (item *stripe.SubscriptionItem, err error) {
+	_, item, err = usageSubItem(email, product)
+	return
+}
+
+func usageSubItem(email, product string) (sub *stripe.Subscription, item *stripe.SubscriptionItem, err error) {
+	user, found := UserSearch(email)
+	if !found {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+	subs, err := UserPaidSubsErr(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sub = range subs {
+		for _, i := range sub.Items.Data {
+			p := i.Price
+			switch {
+			case p == nil:
+			case p.ID == product, p.LookupKey == product, p.Product != nil && p.Product.ID == product:
+				return sub, i, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%s: no active subscription to %s", email, product)
+}
+
+func usageParse(data []byte) (records []Usage, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if len(scanner.Bytes())==0 {
+			continue
+		}
+		u := Usage{}
+		if err = json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("%s: %s", UsageFile, err)
+		}
+		records = append(records, u)
+	}
+	sort.SliceStable(records, func (i, j int) bool {
+		return records[i].Timestamp < records[j].Timestamp
+	})
+	return records, scanner.Err()
+}
+
+func (u Usage) check() error {
+	switch {
+	case len(u.Email)==0:
+		return fmt.Errorf("missing email")
+	case len(u.Product)==0:
+		return fmt.Errorf("missing product")
+	case u.Action != stripe.UsageRecordActionIncrement && u.Action != stripe.UsageRecordActionSet:
+		return fmt.Errorf("invalid action: %s", u.Action)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package ustripe
+
+import (
+	"path/filepath"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUsageBuffer(t *testing.T) {
+	defer func(f string) { UsageFile = f }(UsageFile)
+	UsageFile = filepath.Join(t.TempDir(), "usage.jsonl")
+	for i := 0; i < 2; i++ {
+		if err := UsageBuffer("a@b.c", "prod_1", 5, time.Unix(100, 0), "increment"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UsageBuffer("a@b.c", "prod_1", 5, time.Unix(100, 0), "decrement"); err == nil {
+		t.Errorf("invalid action buffered")
+	}
+	data, _ := os.ReadFile(UsageFile)
+	records, err := usageParse(data)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records: %v %v", records, err)
+	}
+	if len(records[0].Key)==0 || records[0].Key == records[1].Key {
+		t.Errorf("equal reports share the key: %q %q", records[0].Key, records[1].Key)
+	}
+}
+
+func TestUsageAggregate(t *testing.T) {
+	monthly := func (u Usage) int64 {
+		if u.Timestamp >= 1000 {
+			return 1000
+		}
+		return 0
+	}
+	records := []Usage{
+		{ "a@b.c", "p", 5, 100, "increment", "k1", false },
+		{ "a@b.c", "p", 5, 100, "increment", "k2", false },
+		{ "a@b.c", "p", 3, 900, "increment", "k3", false },
+		{ "a@b.c", "p", 7, 1100, "increment", "k4", false },
+		{ "a@b.c", "p", 1, 200, "increment", "k5", true },
+		{ "a@b.c", "q", 4, 100, "set", "k6", false },
+		{ "a@b.c", "q", 9, 300, "set", "k7", false },
+		{ "d@e.f", "p", 2, 100, "increment", "k8", false },
+	}
+	r := UsageAggregate(records, monthly)
+	if len(r) != 5 {
+		t.Fatalf("unexpected aggregation: %v", r)
+	}
+	if r[0].Quantity != 13 || r[0].Timestamp != 900 {
+		t.Errorf("first period: %+v", r[0])
+	}
+	if r[1].Quantity != 7 || r[1].Timestamp != 1100 || r[1].Key != "k4" {
+		t.Errorf("second period: %+v", r[1])
+	}
+	if r[2].Key != "k5" || r[2].Quantity != 1 {
+		t.Errorf("retry merged: %+v", r[2])
+	}
+	if r[3].Quantity != 9 || r[3].Key != "k7" {
+		t.Errorf("set: %+v", r[3])
+	}
+	again := UsageAggregate([]Usage{ records[2], records[1], records[0] }, monthly)
+	if again[0].Key != r[0].Key {
+		t.Errorf("sum key not stable: %s != %s", again[0].Key, r[0].Key)
+	}
+}
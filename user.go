@@ -2,12 +2,13 @@ package ustripe
 import (
 	"github.com/stripe/stripe-go/v73"
 	"github.com/stripe/stripe-go/v73/customer"
-	"github.com/stripe/stripe-go/v73/subscription"
 	"github.com/stripe/stripe-go/v73/taxid"
 	"github.com/google/uuid"
 	"fmt"
 	"encoding/json"
 	"strings"
+	"strconv"
+	"time"
 )
 
 // UserIter returns an iterator for all users.
@@ -17,6 +18,33 @@ func UserIter() (i *customer.Iter) {
 	return customer.List(p)
 }
 
+// UserFind returns up to limit users whose email or name contains
+// the query.
+func UserFind(query string, limit int) (users []*stripe.Customer, err error) {
+	if m := mirrorFresh(); m != nil {
+		q := strings.ToLower(query)
+		for _, c := range m.Customers {
+			if len(users) >= limit {
+				break
+			}
+			if strings.Contains(strings.ToLower(c.Email), q) || strings.Contains(strings.ToLower(c.Name), q) {
+				users = append(users, c)
+			}
+		}
+		return
+	}
+	q := strconv.Quote(query)
+	p := &stripe.CustomerSearchParams{}
+	p.Query = "email~" + q + " OR name~" + q
+	p.Limit = stripe.Int64(int64(limit))
+	i := customer.Search(p)
+	for i.Next() && len(users) < limit {
+		users = append(users, i.Customer())
+	}
+	err = i.Err()
+	return
+}
+
 // UserVerified returns true if the user is verified.
 func UserVerified(u *stripe.Customer) bool {
 	v, hasV := u.Metadata["status"]
@@ -55,6 +83,26 @@ func UserPrint(u *stripe.Customer) {
 		UserLanguage(u))
 }
 
+// UserRecord returns the user as a Record for Renderer.
+func UserRecord(u *stripe.Customer) Record {
+	taxIDs := []string{}
+	if u.TaxIDs != nil {
+		for _, t := range u.TaxIDs.Data {
+			taxIDs = append(taxIDs, t.Value)
+		}
+	}
+	return Record{
+		{ "id"      , u.ID },
+		{ "email"   , u.Email },
+		{ "name"    , u.Name },
+		{ "verified", UserVerified(u) },
+		{ "language", UserLanguage(u) },
+		{ "team"    , u.Metadata[TeamKey] },
+		{ "tax_ids" , taxIDs },
+		{ "created" , u.Created },
+	}
+}
+
 // UserJSON returns the JSON representation.
 func UserJSON(u *stripe.Customer) ([]byte, error) {
 	return json.Marshal(u)
@@ -112,13 +160,26 @@ func UserPrintREC(u *stripe.Customer) {
 	fmt.Printf("\n")
 }
 
-// UserPaidSubs retrieves all active subscriptions of the user.
+// UserPaidSubs retrieves the subscriptions of the user that grant
+// access according to SubscriptionAccess.
 func UserPaidSubs(userID string) (subs []*stripe.Subscription) {
-	params := &stripe.SubscriptionListParams{}
-	params.Filters.AddFilter("limit"   , "", "100")
-	params.Filters.AddFilter("customer", "", userID)
-	params.Filters.AddFilter("status"  , "", "active")
-	return subscription.List(params).SubscriptionList().Data
+	subs, _ = UserPaidSubsErr(userID)
+	return
+}
+
+// UserPaidSubsErr is UserPaidSubs returning the error getting the
+// subscriptions.
+func UserPaidSubsErr(userID string) (subs []*stripe.Subscription, err error) {
+	var all []*stripe.Subscription
+	if all, err = UserSubs(userID); err != nil {
+		return
+	}
+	for _, s := range all {
+		if SubscriptionAccess(s) {
+			subs = append(subs, s)
+		}
+	}
+	return
 }
 
 // Subscription2Product lists the products in subscriptions.
@@ -137,6 +198,28 @@ func Subscription2Product(subs []*stripe.Subscription) (prods map[string]string)
 
 // UserSearch returns the user from mail.
 func UserSearch(email string) (c *stripe.Customer, found bool) {
+	m := mirrorFresh()
+	if m != nil {
+		if c, found = m.userSearch(email); found {
+			return
+		}
+	}
+	if CacheUsers {
+		if v, hit := cacheGet("user", email); hit {
+			return v.(*stripe.Customer), true
+		}
+	}
+	c, found = userFetch(email)
+	switch {
+	case found && m != nil:
+		mirrorPutCustomer(c)
+	case found && CacheUsers:
+		cachePut("user", email, c)
+	}
+	return
+}
+
+func userFetch(email string) (c *stripe.Customer, found bool) {
 	p := &stripe.CustomerListParams{}
 	p.Filters.AddFilter("limit"   , "", "1")
 	p.Filters.AddFilter("email"   , "", email)
@@ -178,7 +261,6 @@ func UserAdd(email, password string, ops map[string]string) (c *stripe.Customer,
 	var user               *stripe.Customer
 	var hash, lang, status  string
 	var userFound           bool
-	var params             *stripe.CustomerParams
 	
 	/* Fail if the user exists. */
 	user, userFound = UserSearch(email)
@@ -201,6 +283,26 @@ func UserAdd(email, password string, ops map[string]string) (c *stripe.Customer,
 	hash, err = PasswordHash(password)
 	if err != nil { return }
 
+	return userNew(email, hash, lang, status, ops)
+}
+
+// UserAddHash adds a new user with an already hashed password, in
+// one of the formats accepted by PasswordVerify.
+func UserAddHash(email, hash string, ops map[string]string) (c *stripe.Customer, err error) {
+	if _, found := UserSearch(email); found {
+		return nil, fmt.Errorf("the user already exists")
+	}
+	if !PasswordHashKnown(hash) {
+		return nil, fmt.Errorf("unsupported password hash format")
+	}
+	lang  , _ := UserParamsLanguage(ops)
+	status, _ := UserParamsVerified(ops)
+	return userNew(email, hash, lang, status, ops)
+}
+
+func userNew(email, hash, lang, status string, ops map[string]string) (c *stripe.Customer, err error) {
+	var params *stripe.CustomerParams
+
 	/* Prepare customers. */
 	params = &stripe.CustomerParams{}
 	params.Email = stripe.String(email)
@@ -219,7 +321,18 @@ func UserAdd(email, password string, ops map[string]string) (c *stripe.Customer,
 	}
 	
 	/* Create customer. */
-	return customer.New(params)
+	c, err = customer.New(params)
+	if err == nil {
+		mirrorPutCustomer(c)
+	}
+	return
+}
+
+// UserEditKeys are the keys accepted by UserEdit, besides "@KEY"
+// metadata.
+var UserEditKeys = []string{
+	"language", "verified", "name", "phone", "description", "cif",
+	"addr1", "addr2", "city", "zipcode", "state", "country",
 }
 
 // UserEdit changes the user information in stripe.
@@ -307,17 +420,28 @@ func UserEdit(email string, ops map[string]string) (c *stripe.Customer, err erro
 	}
 
 	/* Fetch all data. */
-	user, found = UserSearch(user.Email)
+	user, found = userFetch(user.Email)
 	if !found {
 		err = fmt.Errorf("can't fetch user after modification")
 		return
 	}
+	mirrorPutCustomer(user)
 
 	return user, nil
 }
 
 // UserID get identity.
 func UserID(email string) (id string, found bool) {
+	if m := mirrorFresh(); m != nil {
+		if c, f := m.userSearch(email); f {
+			return c.ID, true
+		}
+		if c, f := userFetch(email); f {
+			mirrorPutCustomer(c)
+			return c.ID, true
+		}
+		return "", false
+	}
 	p := &stripe.CustomerListParams{}
 	p.Filters.AddFilter("email", "", email)
 	i := customer.List(p)
@@ -338,6 +462,11 @@ func UserDel(email string) (deleted bool, err error) {
 		return true, nil
 	}
 	c, err = customer.Del(id, nil)
+	CacheInvalidate("user", email)
+	if err != nil {
+		return false, err
+	}
+	mirrorDelCustomer(id)
 	return c.Deleted, nil
 }
 
@@ -357,6 +486,7 @@ func UserSendValidationMail(userID string) (err error) {
 	if err != nil {
 		return err
 	}
+	mirrorPutCustomer(u)
 	url = ValidationURL(ecode, u.Email)
 	mail = ValidationMail(u, u.Email, url)
 	return SendMail(mail)
@@ -387,30 +517,43 @@ func UserValidate(email, ecode string) (userId string, err error) {
 	params = &stripe.CustomerParams{}
 	params.AddMetadata("ecode", uuid.New().String())
 	params.AddMetadata("status", "verified")
+	if _, found := user.Metadata["verified_at"]; !found {
+		params.AddMetadata("verified_at", strconv.FormatInt(time.Now().Unix(), 10))
+	}
 
-	_, err = customer.Update(user.ID, params)
-	return user.ID, err
+	user, err = customer.Update(user.ID, params)
+	if err != nil {
+		return "", err
+	}
+	mirrorPutCustomer(user)
+	return user.ID, nil
 }
 
 // UserLogin searches the user by email and verifies the password.
 func UserLogin(email, password string) (user *stripe.Customer, err error) {
 	var found         bool
-	var hash1, hash2  string
+	var hash          string
+	var ok            bool
 	user, found = UserSearch(email)
 	if !found {
 		return nil, fmt.Errorf("user not found (1)")
 	}
-	hash1, err = PasswordHash(strings.Trim(password, " \t\r\n"))
-	if err != nil {
-		return nil, err
-	}
-	hash2, found = user.Metadata["hash1"]
+	password = strings.Trim(password, " \t\r\n")
+	hash, found = user.Metadata["hash1"]
 	if !found {
 		return nil, fmt.Errorf("user not found (2)")
 	}
-	if len(MasterPasswordHash)>1 && hash1 == MasterPasswordHash {
-		
-	} else if hash1 != hash2 {
+	if len(MasterPasswordHash)>1 {
+		/* A malformed master hash is reported by init() and never
+		 * matches. */
+		if ok, _ = PasswordVerify(password, MasterPasswordHash); ok {
+			return user, nil
+		}
+	}
+	ok, err = PasswordVerify(password, hash)
+	if err != nil {
+		return nil, err
+	} else if !ok {
 		return nil, fmt.Errorf("invalid password")
 	}
 	return user, nil
@@ -421,7 +564,6 @@ func UserChangePass(email, password string) (userId string, err error) {
 	var user         *stripe.Customer
 	var found         bool
 	var hash          string
-	var params       *stripe.CustomerParams
 	user, found = UserSearch(email)
 	if !found {
 		return "", fmt.Errorf("user not found (1)")
@@ -430,8 +572,29 @@ func UserChangePass(email, password string) (userId string, err error) {
 	if err != nil {
 		return "", err
 	}
-	params = &stripe.CustomerParams{}
+	return userSetHash(user, hash)
+}
+
+// UserChangeHash sets an already hashed password, in one of the
+// formats accepted by PasswordVerify.
+func UserChangeHash(email, hash string) (userId string, err error) {
+	user, found := UserSearch(email)
+	if !found {
+		return "", fmt.Errorf("user not found (1)")
+	}
+	if !PasswordHashKnown(hash) {
+		return "", fmt.Errorf("unsupported password hash format")
+	}
+	return userSetHash(user, hash)
+}
+
+func userSetHash(user *stripe.Customer, hash string) (userId string, err error) {
+	params := &stripe.CustomerParams{}
 	params.AddMetadata("hash1", hash)
-	_, err = customer.Update(user.ID, params)
-	return user.ID, err
+	user, err = customer.Update(user.ID, params)
+	if err != nil {
+		return "", err
+	}
+	mirrorPutCustomer(user)
+	return user.ID, nil
 }
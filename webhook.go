@@ -0,0 +1,293 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/webhook"
+	"encoding/json"
+	"path/filepath"
+	"net/http"
+	"os/exec"
+	"context"
+	"strings"
+	"bytes"
+	"sync"
+	"time"
+	"log"
+	"fmt"
+	"io"
+	"os"
+)
+
+// EventHandler is a function run for each verified Stripe event.
+type EventHandler func (e *stripe.Event) (err error)
+
+// EventHandlers are run in order by EventDispatch. Only HookRun
+// retries and keeps dead letters, the failures of the other handlers
+// are logged and the event is not dispatched again.
+var EventHandlers []EventHandler = []EventHandler{ MirrorEvent, CacheEvent, DunningEvent, HookRun }
+
+// WebhookQueueSize is the number of received events waiting to be
+// dispatched, when full the webhook waits before answering.
+var WebhookQueueSize int = 1024
+
+var webhookQueue chan *stripe.Event
+var webhookOnce  sync.Once
+
+// EventDispatch runs all EventHandlers, it returns the first error.
+func EventDispatch(e *stripe.Event) (err error) {
+	var herr error
+	for _, h := range EventHandlers {
+		herr = h(e)
+		if herr != nil && err == nil {
+			err = herr
+		}
+	}
+	return
+}
+
+// WebhookHandler verifies the signature of the request with
+// WebhookSecret, stores the event and queues it for dispatching, so
+// that slow hooks don't delay the answer to Stripe.
+func WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var payload []byte
+	var e       stripe.Event
+	var err     error
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err = io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "can't read body", http.StatusServiceUnavailable)
+		return
+	}
+	e, err = WebhookEvent(payload, r.Header.Get("Stripe-Signature"))
+	if err != nil {
+		log.Printf("webhook: %s", err)
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	/* Ask for a redelivery only when the event can't be stored,
+	 * dispatch failures are kept in the dead-letter directory. */
+	stored, err := EventStore(&e)
+	if err != nil {
+		log.Printf("webhook: %s: %s", e.ID, err)
+		http.Error(w, "can't store event", http.StatusServiceUnavailable)
+		return
+	}
+	if stored {
+		webhookOnce.Do(func () {
+			webhookQueue = make(chan *stripe.Event, WebhookQueueSize)
+			go webhookWorker()
+		})
+		webhookQueue <- &e
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// webhookWorker dispatches the queued events in order of arrival.
+func webhookWorker() {
+	for e := range webhookQueue {
+		if err := EventDispatch(e); err != nil {
+			log.Printf("webhook: %s: %s", e.ID, err)
+		}
+	}
+}
+
+// WebhookEvent verifies the payload with WebhookSecret.
+func WebhookEvent(payload []byte, signature string) (e stripe.Event, err error) {
+	if len(WebhookSecret)==0 {
+		err = fmt.Errorf("webhook secret not specified")
+		return
+	}
+	return webhook.ConstructEventWithOptions(payload, signature, WebhookSecret,
+		webhook.ConstructEventOptions{ IgnoreAPIVersionMismatch: true })
+}
+
+// WebhookServe listens in addr for Stripe events.
+func WebhookServe(addr string) (err error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebhookPath, WebhookHandler)
+	log.Printf("webhook: listening on %s%s", addr, WebhookPath)
+	return http.ListenAndServe(addr, mux)
+}
+
+// HookRun executes HooksDirectory/EVENT_TYPE with the event JSON in
+// the standard input. Failed deliveries are retried HookRetries times
+// and then saved in DeadLetterDirectory.
+func HookRun(e *stripe.Event) (err error) {
+	var path    string
+	var payload []byte
+	var stderr  string
+
+	path, err = hookPath(e.Type)
+	if err != nil || len(path)==0 {
+		return
+	}
+	payload, err = json.Marshal(e)
+	if err != nil {
+		return
+	}
+	for attempt := 0; attempt <= HookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * HookRetryDelay)
+		}
+		stderr, err = hookExec(path, e, payload)
+		if err == nil {
+			return nil
+		}
+		log.Printf("hook: %s: %s: attempt %v: %s", e.Type, e.ID, attempt+1, err)
+	}
+	return HookDeadLetter(e, payload, err, stderr)
+}
+
+// HookDeadLetter saves a failed delivery in DeadLetterDirectory.
+func HookDeadLetter(e *stripe.Event, payload []byte, cause error, stderr string) (err error) {
+	if len(DeadLetterDirectory)==0 {
+		return cause
+	}
+	err = os.MkdirAll(DeadLetterDirectory, 0700)
+	if err != nil {
+		return
+	}
+	base := filepath.Join(DeadLetterDirectory, e.ID)
+	err = os.WriteFile(base + ".json", payload, 0600)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(base + ".log", []byte(fmt.Sprintf(""   +
+		"Event: %s"  + "\n" +
+		"Type: %s"   + "\n" +
+		"Date: %s"   + "\n" +
+		"Error: %s"  + "\n" +
+		"%s",
+		e.ID, e.Type, time.Now().Format(time.RFC3339), cause, stderr)), 0600)
+	if err != nil {
+		return
+	}
+	return fmt.Errorf("%s: %s, saved in %s", e.Type, cause, base + ".json")
+}
+
+// HookRetryDeadLetters redelivers the events in DeadLetterDirectory,
+// successful deliveries are removed from it. Events without a hook are
+// kept.
+func HookRetryDeadLetters() (err error) {
+	var files  []string
+	var data   []byte
+	var path    string
+	var failed  int
+
+	files, err = filepath.Glob(filepath.Join(DeadLetterDirectory, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		var e stripe.Event
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return
+		}
+		err = json.Unmarshal(data, &e)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		if path, err = hookPath(e.Type); err != nil || len(path)==0 {
+			log.Printf("hook: %s: %s: no hook, kept in %s", e.Type, e.ID, file)
+			failed++
+			continue
+		}
+		if err = HookRun(&e); err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+		os.Remove(file)
+		os.Remove(strings.TrimSuffix(file, ".json") + ".log")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters failed", failed, len(files))
+	}
+	return nil
+}
+
+// HookUserErased executes HooksDirectory/user.erased after UserErase,
+// with the same environment as the event hooks.
+func HookUserErased(email, customerID string) (err error) {
+	var path   string
+	var stderr string
+	path, err = hookPath("user.erased")
+	if err != nil || len(path)==0 {
+		return
+	}
+	object := map[string]interface{}{ "object": "customer", "id": customerID, "email": email }
+	payload, _ := json.Marshal(object)
+	e := &stripe.Event{ Type: "user.erased", Created: time.Now().Unix(), Data: &stripe.EventData{ Object: object, Raw: payload } }
+	if stderr, err = hookExec(path, e, payload); err != nil {
+		return fmt.Errorf("user.erased: %s: %s", err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+func hookPath(eventType string) (path string, err error) {
+	if len(HooksDirectory)==0 || len(eventType)==0 {
+		return "", nil
+	}
+	if strings.ContainsAny(eventType, "/\\") || strings.HasPrefix(eventType, ".") {
+		return "", fmt.Errorf("invalid event type: %s", eventType)
+	}
+	path = filepath.Join(HooksDirectory, eventType)
+	if _, serr := os.Stat(path); serr != nil {
+		return "", nil
+	}
+	return path, nil
+}
+
+func hookExec(path string, e *stripe.Event, payload []byte) (errs string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HookTimeout)
+	defer cancel()
+	cmd    := exec.CommandContext(ctx, path)
+	stderr := bytes.Buffer{}
+	cmd.Stdin  = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = &stderr
+	cmd.Env    = append(os.Environ(),
+		"STRIPE_EVENT_ID="      + e.ID,
+		"STRIPE_EVENT_TYPE="    + e.Type,
+		"STRIPE_EVENT_CREATED=" + fmt.Sprint(e.Created),
+		"STRIPE_LIVEMODE="      + fmt.Sprint(e.Livemode),
+		"STRIPE_OBJECT_ID="     + eventString(e, "id"),
+		"STRIPE_OBJECT_TYPE="   + eventString(e, "object"),
+		"STRIPE_CUSTOMER="      + EventCustomer(e),
+		"STRIPE_EMAIL="         + eventEmail(e),
+	)
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timeout after %s", HookTimeout)
+	}
+	return stderr.String(), err
+}
+
+// EventCustomer returns the customer ID the event refers to.
+func EventCustomer(e *stripe.Event) (id string) {
+	if eventString(e, "object") == "customer" {
+		return eventString(e, "id")
+	}
+	return eventString(e, "customer")
+}
+
+func eventEmail(e *stripe.Event) (email string) {
+	if email = eventString(e, "email"); len(email)>0 {
+		return
+	}
+	return eventString(e, "customer_email")
+}
+
+func eventString(e *stripe.Event, key string) (s string) {
+	if e.Data == nil || e.Data.Object == nil {
+		return ""
+	}
+	s, _ = e.Data.Object[key].(string)
+	return
+}
@@ -0,0 +1,111 @@
+package ustripe
+
+import (
+	"github.com/stripe/stripe-go/v73"
+	"github.com/stripe/stripe-go/v73/webhook"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"fmt"
+	"os"
+)
+
+func testHooks(t *testing.T, script string) (dir string) {
+	dir = t.TempDir()
+	old := []string{ HooksDirectory, DeadLetterDirectory, EventsFile, WebhookSecret }
+	retries := HookRetries
+	t.Cleanup(func () {
+		HooksDirectory, DeadLetterDirectory, EventsFile, WebhookSecret = old[0], old[1], old[2], old[3]
+		HookRetries = retries
+	})
+	HooksDirectory      = filepath.Join(dir, "hooks")
+	DeadLetterDirectory = filepath.Join(dir, "failed")
+	EventsFile          = filepath.Join(dir, "events.jsonl")
+	WebhookSecret       = "whsec_test"
+	HookRetries         = 0
+	eventsKnown         = nil
+	os.MkdirAll(HooksDirectory, 0700)
+	if len(script)>0 {
+		os.WriteFile(filepath.Join(HooksDirectory, "test.event"), []byte("#!/bin/sh\n" + script), 0700)
+	}
+	return
+}
+
+func TestWebhookHandlerAsync(t *testing.T) {
+	dir := testHooks(t, "sleep 1; touch \"$MARK\"\n")
+	mark := filepath.Join(dir, "mark")
+	t.Setenv("MARK", mark)
+	payload := []byte(`{"id": "evt_async", "object": "event", "type": "test.event", "created": 1, "data": {"object": {}}}`)
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{ Payload: payload, Secret: WebhookSecret })
+
+	start := time.Now()
+	r := httptest.NewRequest("POST", WebhookPath, strings.NewReader(string(payload)))
+	r.Header.Set("Stripe-Signature", signed.Header)
+	w := httptest.NewRecorder()
+	WebhookHandler(w, r)
+	if w.Code != 200 || time.Since(start) > 500 * time.Millisecond {
+		t.Fatalf("answered %d after %s", w.Code, time.Since(start))
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(mark); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("the hook was not run")
+}
+
+func TestHookRetryDeadLetters(t *testing.T) {
+	testHooks(t, "")
+	e := &stripe.Event{ ID: "evt_dead", Type: "test.event" }
+	if err := HookDeadLetter(e, []byte(`{"id": "evt_dead", "type": "test.event"}`), os.ErrNotExist, ""); err == nil {
+		t.Fatal("HookDeadLetter returned no error")
+	}
+	file := filepath.Join(DeadLetterDirectory, "evt_dead.json")
+
+	/* Without hook and with a failing hook the letter is kept. */
+	hook := filepath.Join(HooksDirectory, "test.event")
+	for _, script := range []string{ "", "exit 1\n" } {
+		if len(script)>0 {
+			os.WriteFile(hook, []byte("#!/bin/sh\n" + script), 0700)
+		}
+		if err := HookRetryDeadLetters(); err == nil {
+			t.Errorf("%q: no error", script)
+		}
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("%q: dead letter lost", script)
+		}
+	}
+
+	os.WriteFile(hook, []byte("#!/bin/sh\nexit 0\n"), 0700)
+	if err := HookRetryDeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("delivered dead letter not removed")
+	}
+}
+
+func TestEventReplayContinues(t *testing.T) {
+	testHooks(t, "")
+	defer func(h []EventHandler) { EventHandlers = h }(EventHandlers)
+	dispatched := []string{}
+	EventHandlers = []EventHandler{ func (e *stripe.Event) error {
+		dispatched = append(dispatched, e.ID)
+		if e.ID == "evt_1" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}}
+	for _, id := range []string{ "evt_1", "evt_2" } {
+		if _, err := EventStore(&stripe.Event{ ID: id, Type: "test.event", Created: 1 }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, failed, err := EventReplay(EventFilter{})
+	if n != 2 || failed != 1 || err == nil || len(dispatched) != 2 {
+		t.Errorf("replay: n=%v failed=%v err=%v dispatched=%v", n, failed, err, dispatched)
+	}
+}